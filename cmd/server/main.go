@@ -12,6 +12,7 @@ import (
 
 	"crom-vision/internal/database"
 	"crom-vision/internal/handlers"
+	"crom-vision/internal/metrics"
 	"crom-vision/internal/services"
 	"crom-vision/internal/utils"
 )
@@ -102,11 +103,18 @@ func main() {
 	database.InitDB()
 	defer database.DB.Close()
 
+	// Bases anteriores aos rollups: agrega os access_logs existentes antes de servir os gráficos
+	if err := services.BackfillRollups(); err != nil {
+		log.Printf("[ROLLUP] Falha no backfill dos rollups: %v", err)
+	}
+
 	utils.InitGeoIP()
 	defer utils.CloseGeoIP()
 
 	go utils.CleanupAntiF5()
 	go services.HardDeleteExpired()
+	go services.RunWebhookDispatcher()
+	go services.RunDigestScheduler()
 
 	mux := http.NewServeMux()
 
@@ -117,14 +125,43 @@ func main() {
 	mux.HandleFunc("/api/payment-info", handlers.PaymentInfoHandler)
 	mux.HandleFunc("/api/check-payment", handlers.CheckPaymentHandler)
 	mux.HandleFunc("/api/public-links", handlers.PublicLinksHandler)
+	mux.HandleFunc("/api/public-stats", handlers.PublicLinkStatsHandler)
 	mux.HandleFunc("/api/private-stats", handlers.PrivateStatsHandler)
 	mux.HandleFunc("/api/link-stats", handlers.LinkStatsHandler)
 	mux.HandleFunc("/api/link-geo", handlers.LinkGeoHandler)
+	mux.HandleFunc("/api/link-clients", handlers.LinkClientsHandler)
+	mux.HandleFunc("/api/heatmap", handlers.HeatmapHandler)
+	mux.HandleFunc("/api/compare", handlers.CompareHandler)
+	mux.HandleFunc("/api/link-variants", handlers.LinkVariantsHandler)
+	mux.HandleFunc("/api/link-read-time", handlers.LinkReadTimeHandler)
+	mux.HandleFunc("/api/link-stream", handlers.LinkStreamHandler)
+	mux.HandleFunc("/api/link-webhooks", originGuard(handlers.LinkWebhooksHandler))
+	mux.HandleFunc("/api/link-webhook-deliveries", handlers.LinkWebhookDeliveriesHandler)
+	mux.HandleFunc("/api/link-alerts", originGuard(handlers.LinkAlertsHandler))
+	mux.HandleFunc("/api/link-fraud", originGuard(handlers.LinkFraudHandler))
+	mux.HandleFunc("/api/link-shares", originGuard(handlers.LinkSharesHandler))
+	mux.HandleFunc("/api/digest", originGuard(handlers.DigestHandler))
+	mux.HandleFunc("/api/digest/unsubscribe", handlers.DigestUnsubscribeHandler)
+	mux.HandleFunc("/api/campaigns", originGuard(handlers.CampaignsHandler))
+	mux.HandleFunc("/api/export", handlers.ExportHandler)
+	mux.HandleFunc("/api/auth/login", originGuard(handlers.AuthLoginHandler))
+	mux.HandleFunc("/api/auth/verify", handlers.AuthVerifyHandler)
+	mux.HandleFunc("/api/auth/logout", originGuard(handlers.AuthLogoutHandler))
+	mux.HandleFunc("/api/me", handlers.MeHandler)
+	mux.HandleFunc("/api/my-links", handlers.MyLinksHandler)
+	mux.HandleFunc("/api/my-links/claim", originGuard(handlers.MyLinksClaimHandler))
 	mux.HandleFunc("/p/", handlers.PreviewHandler)
+	mux.HandleFunc("/badge/", handlers.BadgeHandler)
+	mux.HandleFunc("/spark/", handlers.SparkHandler)
+	mux.HandleFunc("/oembed", handlers.OEmbedHandler)
 
 	// LGPD
 	mux.HandleFunc("/api/lgpd/consultar", originGuard(handlers.LGPDConsultarHandler))
 	mux.HandleFunc("/api/lgpd/apagar", originGuard(handlers.LGPDApagarHandler))
+	mux.HandleFunc("/api/lgpd/confirmar", originGuard(handlers.LGPDConfirmarHandler))
+
+	// Observabilidade
+	mux.HandleFunc("/metrics", handlers.MetricsHandler)
 
 	// Health check
 	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
@@ -158,7 +195,7 @@ func main() {
 	}
 
 	log.Printf("🚀 Imagem Acompanhada v5 operando na porta :%s\n", port)
-	if err := http.ListenAndServe(":"+port, corsMiddleware(mux)); err != nil {
+	if err := http.ListenAndServe(":"+port, corsMiddleware(metrics.InstrumentMux(mux))); err != nil {
 		log.Fatalf("[FALHA] Servidor HTTP interrompido: %v", err)
 	}
 }
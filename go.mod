@@ -2,12 +2,14 @@ module crom-vision
 
 go 1.22.2
 
-require github.com/mattn/go-sqlite3 v1.14.34
+require (
+	github.com/joho/godotenv v1.5.1
+	github.com/mattn/go-sqlite3 v1.14.34
+	github.com/oschwald/geoip2-golang v1.13.0
+)
 
 require (
 	github.com/h2non/filetype v1.1.3 // indirect
-	github.com/joho/godotenv v1.5.1 // indirect
-	github.com/oschwald/geoip2-golang v1.13.0 // indirect
 	github.com/oschwald/maxminddb-golang v1.13.0 // indirect
 	golang.org/x/sys v0.20.0 // indirect
 )
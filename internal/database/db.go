@@ -47,6 +47,124 @@ func InitDB() {
 		country TEXT,
 		city TEXT,
 		accessed_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS link_variants (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		link_id TEXT,
+		label TEXT,
+		file_path TEXT,
+		weight INTEGER DEFAULT 1,
+		total_views INTEGER DEFAULT 0,
+		unique_views INTEGER DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_link_variants_link ON link_variants(link_id);
+	CREATE TABLE IF NOT EXISTS read_sessions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		link_id TEXT,
+		ip_hash TEXT,
+		duration_ms INTEGER,
+		started_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_read_sessions_link ON read_sessions(link_id);
+	CREATE TABLE IF NOT EXISTS webhooks (
+		id TEXT PRIMARY KEY,
+		link_id TEXT,
+		url TEXT,
+		secret TEXT,
+		events TEXT,
+		sample_rate REAL DEFAULT 1,
+		threshold INTEGER DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_webhooks_link ON webhooks(link_id);
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		webhook_id TEXT,
+		link_id TEXT,
+		event TEXT,
+		url TEXT,
+		secret TEXT,
+		payload TEXT,
+		status TEXT DEFAULT 'pending',
+		attempts INTEGER DEFAULT 0,
+		response_code INTEGER,
+		last_error TEXT,
+		next_attempt_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		delivered_at DATETIME
+	);
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_queue ON webhook_deliveries(status, next_attempt_at);
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_link ON webhook_deliveries(link_id, id);
+	CREATE TABLE IF NOT EXISTS stats_hourly (
+		link_id TEXT,
+		bucket TEXT,
+		country TEXT DEFAULT '',
+		client_class TEXT DEFAULT '',
+		views INTEGER DEFAULT 0,
+		uniques INTEGER DEFAULT 0,
+		PRIMARY KEY (link_id, bucket, country, client_class)
+	);
+	CREATE TABLE IF NOT EXISTS stats_daily (
+		link_id TEXT,
+		bucket TEXT,
+		country TEXT DEFAULT '',
+		client_class TEXT DEFAULT '',
+		views INTEGER DEFAULT 0,
+		uniques INTEGER DEFAULT 0,
+		PRIMARY KEY (link_id, bucket, country, client_class)
+	);
+	CREATE TABLE IF NOT EXISTS stats_visitors (
+		link_id TEXT,
+		dimension TEXT,
+		value TEXT DEFAULT '',
+		visitors INTEGER DEFAULT 0,
+		PRIMARY KEY (link_id, dimension, value)
+	);
+	CREATE TABLE IF NOT EXISTS campaigns (
+		id TEXT PRIMARY KEY,
+		name TEXT,
+		password_hash TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS share_tokens (
+		id TEXT PRIMARY KEY,
+		link_id TEXT,
+		token_hash TEXT UNIQUE,
+		label TEXT,
+		scopes TEXT,
+		expires_at DATETIME,
+		revoked_at DATETIME,
+		last_used_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_share_tokens_link ON share_tokens(link_id);
+	CREATE TABLE IF NOT EXISTS users (
+		id TEXT PRIMARY KEY,
+		email TEXT UNIQUE,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		last_login_at DATETIME
+	);
+	CREATE TABLE IF NOT EXISTS login_tokens (
+		token_hash TEXT PRIMARY KEY,
+		email TEXT,
+		expires_at DATETIME,
+		used_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_login_tokens_email ON login_tokens(email, created_at);
+	CREATE TABLE IF NOT EXISTS sessions (
+		id_hash TEXT PRIMARY KEY,
+		user_id TEXT,
+		expires_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(user_id);
+	CREATE TABLE IF NOT EXISTS digest_subscriptions (
+		email TEXT PRIMARY KEY,
+		frequency TEXT,
+		last_sent_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);`
 
 	if _, err := DB.Exec(schema); err != nil {
@@ -67,6 +185,61 @@ func InitDB() {
 		"ALTER TABLE links ADD COLUMN mp_qr_code TEXT",
 		"ALTER TABLE links ADD COLUMN mp_qr_base64 TEXT",
 		"ALTER TABLE links ADD COLUMN mp_ticket_url TEXT",
+		// Variantes A/B por trás do mesmo pixel
+		"ALTER TABLE links ADD COLUMN variant_mode TEXT DEFAULT 'weighted'",
+		"ALTER TABLE access_logs ADD COLUMN variant_id INTEGER",
+		// Regras de entrega (cerca geográfica e janela de horário)
+		"ALTER TABLE links ADD COLUMN allowed_countries TEXT",
+		"ALTER TABLE links ADD COLUMN blocked_countries TEXT",
+		"ALTER TABLE links ADD COLUMN delivery_hours TEXT",
+		"ALTER TABLE links ADD COLUMN delivery_days TEXT",
+		"ALTER TABLE links ADD COLUMN delivery_tz TEXT",
+		"ALTER TABLE access_logs ADD COLUMN status TEXT DEFAULT 'served'",
+		// Ativação agendada
+		"ALTER TABLE links ADD COLUMN not_before DATETIME",
+		// Medição de tempo de leitura (slow-drip)
+		"ALTER TABLE links ADD COLUMN read_tracking BOOLEAN DEFAULT 0",
+		// Classe do cliente e flag de único por acesso (stream ao vivo e séries)
+		"ALTER TABLE access_logs ADD COLUMN client_class TEXT",
+		"ALTER TABLE access_logs ADD COLUMN is_unique BOOLEAN DEFAULT 0",
+		"CREATE INDEX IF NOT EXISTS idx_access_logs_link ON access_logs(link_id, id)",
+		// Alertas por e-mail (cada *_sent_at garante envio único)
+		"ALTER TABLE links ADD COLUMN alert_first_open BOOLEAN DEFAULT 0",
+		"ALTER TABLE links ADD COLUMN alert_threshold INTEGER DEFAULT 0",
+		"ALTER TABLE links ADD COLUMN alert_near_limit_pct INTEGER DEFAULT 0",
+		"ALTER TABLE links ADD COLUMN alert_first_open_sent_at DATETIME",
+		"ALTER TABLE links ADD COLUMN alert_threshold_sent_at DATETIME",
+		"ALTER TABLE links ADD COLUMN alert_near_limit_sent_at DATETIME",
+		// Metadados da página de compartilhamento (Open Graph)
+		"ALTER TABLE links ADD COLUMN title TEXT",
+		"ALTER TABLE links ADD COLUMN description TEXT",
+		"ALTER TABLE links ADD COLUMN alt_text TEXT",
+		// Dimensões da imagem (oEmbed e og:image:width/height)
+		"ALTER TABLE links ADD COLUMN image_width INTEGER",
+		"ALTER TABLE links ADD COLUMN image_height INTEGER",
+		// Região (estado/subdivisão) do GeoLite2 para o detalhamento geográfico
+		"ALTER TABLE access_logs ADD COLUMN region TEXT",
+		// Campanhas (agrupam links para exportação conjunta)
+		"ALTER TABLE links ADD COLUMN campaign_id TEXT",
+		"CREATE INDEX IF NOT EXISTS idx_links_campaign ON links(campaign_id)",
+		// Detecção de inflação de views (hits suspeitos e links em revisão)
+		"ALTER TABLE access_logs ADD COLUMN suspicious_reason TEXT",
+		"ALTER TABLE access_logs ADD COLUMN asn INTEGER",
+		"ALTER TABLE links ADD COLUMN fraud_exclude BOOLEAN DEFAULT 0",
+		"ALTER TABLE links ADD COLUMN fraud_flagged_at DATETIME",
+		"ALTER TABLE links ADD COLUMN fraud_reason TEXT",
+		// Contas (login por link mágico): dono do link
+		"ALTER TABLE links ADD COLUMN user_id TEXT",
+		"CREATE INDEX IF NOT EXISTS idx_links_user ON links(user_id)",
+		// Visitas de retorno nos rollups (a série deixa de depender dos logs brutos)
+		"ALTER TABLE stats_hourly ADD COLUMN returning_visits INTEGER DEFAULT 0",
+		"ALTER TABLE stats_daily ADD COLUMN returning_visits INTEGER DEFAULT 0",
+		"CREATE INDEX IF NOT EXISTS idx_access_logs_link_ip ON access_logs(link_id, ip_hash)",
+		// Tokens por e-mail com finalidade (login, confirmação de exclusão LGPD)
+		"ALTER TABLE login_tokens ADD COLUMN purpose TEXT DEFAULT 'login'",
+		// Confirmação do e-mail do checkout: só links confirmados entram na conta do e-mail
+		"ALTER TABLE login_tokens ADD COLUMN link_id TEXT",
+		"ALTER TABLE links ADD COLUMN email_confirmed_at DATETIME",
 	}
 	for _, q := range migrations {
 		DB.Exec(q) 
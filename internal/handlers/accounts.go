@@ -0,0 +1,459 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"os"
+	"strings"
+	"time"
+
+	"crom-vision/internal/database"
+	"crom-vision/internal/services"
+	"crom-vision/internal/utils"
+)
+
+// sessionCookie guarda o ID da sessão (só o hash vai para o banco). SameSite=Lax e a
+// ausência de Access-Control-Allow-Credentials mantêm a sessão restrita ao próprio site.
+const sessionCookie = "crom_session"
+
+// maxLoginRequestsPerHour limita os links de acesso enviados para o mesmo e-mail
+const maxLoginRequestsPerHour = 5
+
+// loginMailer é substituível nos testes
+var loginMailer = services.SendEmail
+
+// Finalidades dos tokens de uso único enviados por e-mail (login_tokens.purpose).
+// Um token só é aceito pelo fluxo da própria finalidade.
+const (
+	tokenLogin     = "login" // link mágico de acesso
+	tokenLinkEmail = "link"  // confirmação do e-mail informado no checkout de um link (também abre a sessão)
+	tokenLGPD      = "lgpd"  // confirmação do pedido de exclusão de dados
+)
+
+// linkEmailTokenTTL é a validade do link de confirmação enviado no e-mail do checkout
+const linkEmailTokenTTL = 7 * 24 * time.Hour
+
+// emailToken é um token consumido: o e-mail comprovado, a finalidade e, na
+// confirmação do checkout, o link a que se refere
+type emailToken struct {
+	Email   string
+	Purpose string
+	LinkID  string
+}
+
+// issueEmailToken grava um token de uso único para o e-mail e devolve o valor em claro
+// (só o hash vai para o banco). linkID só é usado na confirmação do checkout.
+func issueEmailToken(email, purpose, linkID string, ttl time.Duration) (string, error) {
+	now := time.Now().UTC()
+	token := utils.GenerateRandomString(32)
+	_, err := database.DB.Exec("INSERT INTO login_tokens (token_hash, email, purpose, link_id, expires_at, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		hashPassword(token), email, purpose, sql.NullString{String: linkID, Valid: linkID != ""}, now.Add(ttl).Format(sqliteTimeFormat), now.Format(sqliteTimeFormat))
+	return token, err
+}
+
+// consumeEmailToken consome o token se ainda válido e de uma das finalidades pedidas.
+// Uso único: só um UPDATE consome o token, mesmo com cliques repetidos.
+func consumeEmailToken(token string, purposes ...string) (emailToken, bool) {
+	var t emailToken
+	if token == "" || len(purposes) == 0 {
+		return t, false
+	}
+	now := time.Now().UTC().Format(sqliteTimeFormat)
+	args := []interface{}{now, hashPassword(token), now}
+	for _, p := range purposes {
+		args = append(args, p)
+	}
+	err := database.DB.QueryRow(`
+		UPDATE login_tokens SET used_at = ?
+		WHERE token_hash = ? AND used_at IS NULL AND expires_at > ? AND purpose IN (?`+strings.Repeat(", ?", len(purposes)-1)+`)
+		RETURNING email, purpose, COALESCE(link_id, '')`, args...).Scan(&t.Email, &t.Purpose, &t.LinkID)
+	return t, err == nil
+}
+
+// recentEmailTokens conta os tokens da finalidade emitidos para o e-mail na última hora
+func recentEmailTokens(email, purpose string) int {
+	var n int
+	database.DB.QueryRow("SELECT COUNT(*) FROM login_tokens WHERE email = ? AND purpose = ? AND created_at > ?",
+		email, purpose, time.Now().UTC().Add(-time.Hour).Format(sqliteTimeFormat)).Scan(&n)
+	return n
+}
+
+// sessionUser resolve a conta da sessão do cookie; ok=false sem sessão válida
+func sessionUser(r *http.Request) (userID, email string, ok bool) {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil || c.Value == "" {
+		return "", "", false
+	}
+	err = database.DB.QueryRow(`
+		SELECT u.id, u.email FROM sessions s JOIN users u ON u.id = s.user_id
+		WHERE s.id_hash = ? AND s.expires_at > ?`, hashPassword(c.Value), time.Now().UTC().Format(sqliteTimeFormat)).Scan(&userID, &email)
+	if err != nil {
+		return "", "", false
+	}
+	return userID, email, true
+}
+
+// sessionOwnsLink indica se a conta logada é dona do link
+func sessionOwnsLink(r *http.Request, linkID string) bool {
+	userID, _, ok := sessionUser(r)
+	if !ok || linkID == "" {
+		return false
+	}
+	var n int
+	database.DB.QueryRow("SELECT COUNT(*) FROM links WHERE id = ? AND user_id = ?", linkID, userID).Scan(&n)
+	return n == 1
+}
+
+// ownerAuthorized confere a credencial do dono: a Senha Mágica do link ou a sessão da conta dona dele
+func ownerAuthorized(r *http.Request, linkID, password string) bool {
+	if password != "" && linkPasswordMatches(linkID, password) {
+		return true
+	}
+	return sessionOwnsLink(r, linkID)
+}
+
+// claimConfirmedLinks vincula à conta os links sem dono cujo e-mail do checkout foi
+// confirmado (email_confirmed_at). O campo email do checkout é texto livre: sem a
+// confirmação, qualquer um poderia plantar links na conta de outra pessoa.
+func claimConfirmedLinks(userID, email string) int64 {
+	res, err := database.DB.Exec(`
+		UPDATE links SET user_id = ?
+		WHERE user_id IS NULL AND email_confirmed_at IS NOT NULL AND LOWER(TRIM(email)) = ?`, userID, email)
+	if err != nil {
+		log.Printf("[DB ERR] Falha ao vincular links de %s: %v", email, err)
+		return 0
+	}
+	n, _ := res.RowsAffected()
+	return n
+}
+
+// linkEmailConfirmation prepara a confirmação do e-mail informado no checkout do link.
+// Com a sessão da conta desse mesmo e-mail, o link já nasce na conta e o e-mail fica
+// confirmado na hora (devolve ""). Sem ela, devolve o link de confirmação para o e-mail
+// do checkout: ao clicar, o dono comprova o endereço, entra e o link vai para a conta.
+func linkEmailConfirmation(r *http.Request, linkID, rawEmail string) string {
+	email, ok := normalizeEmail(rawEmail)
+	if !ok {
+		return ""
+	}
+	if _, sessionEmail, ok := sessionUser(r); ok {
+		if sessionEmail == email {
+			database.DB.Exec("UPDATE links SET email_confirmed_at = ? WHERE id = ?", time.Now().UTC().Format(sqliteTimeFormat), linkID)
+		}
+		return ""
+	}
+	return linkConfirmationURL(linkID, email)
+}
+
+// linkConfirmationURL emite o token de confirmação do e-mail (já normalizado) do link
+// e devolve o endereço de /api/auth/verify; "" se o token não pôde ser gravado
+func linkConfirmationURL(linkID, email string) string {
+	token, err := issueEmailToken(email, tokenLinkEmail, linkID, linkEmailTokenTTL)
+	if err != nil {
+		log.Printf("[DB ERR] Falha ao criar a confirmação de e-mail do link %s: %v", linkID, err)
+		return ""
+	}
+	baseURL := os.Getenv("BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:8080"
+	}
+	return baseURL + "/api/auth/verify?token=" + token
+}
+
+// deleteAccountByEmail apaga a conta do e-mail com as sessões e links de acesso (LGPD).
+// Os links em si são apagados antes pelo fluxo da LGPD (eraseTitularData), que os localiza
+// pelo e-mail e pelo user_id da conta.
+func deleteAccountByEmail(email string) bool {
+	email = strings.ToLower(strings.TrimSpace(email))
+	database.DB.Exec("DELETE FROM sessions WHERE user_id IN (SELECT id FROM users WHERE email = ?)", email)
+	database.DB.Exec("DELETE FROM login_tokens WHERE email = ?", email)
+	res, err := database.DB.Exec("DELETE FROM users WHERE email = ?", email)
+	if err != nil {
+		return false
+	}
+	n, _ := res.RowsAffected()
+	return n > 0
+}
+
+// normalizeEmail valida e normaliza (minúsculas) o e-mail usado no login
+func normalizeEmail(raw string) (string, bool) {
+	email := strings.ToLower(strings.TrimSpace(raw))
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return "", false
+	}
+	return email, true
+}
+
+// MyLinksClaimHandler traz para a conta logada um link antigo, sem dono e sem e-mail
+// confirmado (criado antes das contas ou sem clicar na confirmação do checkout).
+// POST /api/my-links/claim {"id":"c_xxx","password":"yyy"}
+// Com a Senha Mágica o link entra na conta na hora. Sem ela, a confirmação é reenviada
+// para o e-mail informado no checkout; o link vai para a conta desse e-mail ao clicar.
+func MyLinksClaimHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, _, ok := sessionUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized (login required)", http.StatusUnauthorized)
+		return
+	}
+	var req struct {
+		ID       string `json:"id"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == "" {
+		http.Error(w, "Invalid Payload", http.StatusBadRequest)
+		return
+	}
+
+	if req.Password != "" {
+		if !linkPasswordMatches(req.ID, req.Password) {
+			http.Error(w, "Unauthorized (Invalid Password)", http.StatusUnauthorized)
+			return
+		}
+		res, err := database.DB.Exec("UPDATE links SET user_id = ? WHERE id = ? AND user_id IS NULL", userID, req.ID)
+		if err != nil {
+			http.Error(w, "DB Error", http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 && !sessionOwnsLink(r, req.ID) {
+			http.Error(w, "Este link já pertence a outra conta.", http.StatusConflict)
+			return
+		}
+		log.Printf("[👤 CONTA] Link %s vinculado à conta %s pela Senha Mágica", req.ID, userID)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "claimed", "id": req.ID})
+		return
+	}
+
+	// Sem a senha, só quem lê o e-mail do checkout pode confirmar. A resposta é a mesma com
+	// ou sem e-mail cadastrado, para não revelar o endereço do dono.
+	var rawEmail string
+	database.DB.QueryRow("SELECT COALESCE(email, '') FROM links WHERE id = ? AND user_id IS NULL", req.ID).Scan(&rawEmail)
+	if email, ok := normalizeEmail(rawEmail); ok {
+		if recentEmailTokens(email, tokenLinkEmail) >= maxLoginRequestsPerHour {
+			http.Error(w, "Muitos pedidos de confirmação para este link. Tente novamente mais tarde.", http.StatusTooManyRequests)
+			return
+		}
+		if link := linkConfirmationURL(req.ID, email); link != "" {
+			go loginMailer(email, "Crom-Vision - Confirme o e-mail do seu link", fmt.Sprintf(`<h2>Confirmar o e-mail do link %s</h2>
+	<p>Alguém pediu para vincular este link a uma conta. Ao confirmar, ele entra na conta deste e-mail.</p>
+	<p><a href="%s" style="display:inline-block;background:#10b981;color:white;padding:12px 24px;border-radius:8px;text-decoration:none;font-weight:bold">Confirmar e entrar →</a></p>
+	<p style="color:#71717a">Se você não pediu, ignore este e-mail.</p>`, req.ID, link))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":   "pending",
+		"mensagem": "Se o link tiver um e-mail cadastrado, enviamos a confirmação para ele.",
+	})
+}
+
+// AuthLoginHandler envia o link mágico de acesso para o e-mail.
+// POST /api/auth/login {"email":"dono@exemplo.com"}
+// A resposta é a mesma com ou sem conta: a conta nasce na primeira verificação.
+func AuthLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid Payload", http.StatusBadRequest)
+		return
+	}
+	email, ok := normalizeEmail(req.Email)
+	if !ok {
+		http.Error(w, "E-mail inválido", http.StatusBadRequest)
+		return
+	}
+
+	if recentEmailTokens(email, tokenLogin) >= maxLoginRequestsPerHour {
+		http.Error(w, "Muitos pedidos de acesso para este e-mail. Tente novamente mais tarde.", http.StatusTooManyRequests)
+		return
+	}
+
+	ttl := time.Duration(utils.EnvInt("LOGIN_TOKEN_MINUTES", 15)) * time.Minute
+	token, err := issueEmailToken(email, tokenLogin, "", ttl)
+	if err != nil {
+		http.Error(w, "Failed to create resource", http.StatusInternalServerError)
+		return
+	}
+
+	baseURL := os.Getenv("BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:8080"
+	}
+	link := baseURL + "/api/auth/verify?token=" + token
+	go loginMailer(email, "Crom-Vision - Seu link de acesso", fmt.Sprintf(`<h2>Entrar no Crom-Vision</h2>
+	<p>Clique no botão abaixo para acessar seus links. Ele vale por %d minutos e só pode ser usado uma vez.</p>
+	<p><a href="%s" style="display:inline-block;background:#10b981;color:white;padding:12px 24px;border-radius:8px;text-decoration:none;font-weight:bold">Entrar →</a></p>
+	<p style="color:#71717a">Se você não pediu este acesso, ignore este e-mail.</p>`, int(ttl.Minutes()), link))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":   "sent",
+		"mensagem": "Enviamos um link de acesso para o seu e-mail.",
+	})
+}
+
+// AuthVerifyHandler consome o link mágico (ou a confirmação de e-mail do checkout), cria a
+// conta se preciso e abre a sessão. Os links com e-mail confirmado entram na conta só aqui:
+// na primeira verificação (conta nova) e quando o token confirma um link do checkout.
+// GET /api/auth/verify?token=xxx → redireciona para /my-links.html
+func AuthVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		http.Error(w, "Token missing", http.StatusBadRequest)
+		return
+	}
+
+	t, ok := consumeEmailToken(token, tokenLogin, tokenLinkEmail)
+	if !ok {
+		http.Error(w, "Link de acesso inválido, expirado ou já utilizado.", http.StatusBadRequest)
+		return
+	}
+	email := t.Email
+
+	now := time.Now().UTC()
+	if t.LinkID != "" {
+		database.DB.Exec("UPDATE links SET email_confirmed_at = COALESCE(email_confirmed_at, ?) WHERE id = ? AND LOWER(TRIM(email)) = ?",
+			now.Format(sqliteTimeFormat), t.LinkID, email)
+	}
+
+	var userID string
+	created := false
+	err := database.DB.QueryRow("UPDATE users SET last_login_at = ? WHERE email = ? RETURNING id", now.Format(sqliteTimeFormat), email).Scan(&userID)
+	if err == sql.ErrNoRows {
+		created = true
+		err = database.DB.QueryRow("INSERT INTO users (id, email, last_login_at) VALUES (?, ?, ?) RETURNING id",
+			"usr_"+utils.GenerateRandomString(8), email, now.Format(sqliteTimeFormat)).Scan(&userID)
+	}
+	if err != nil {
+		http.Error(w, "DB Error", http.StatusInternalServerError)
+		return
+	}
+	if created || t.LinkID != "" {
+		if n := claimConfirmedLinks(userID, email); n > 0 {
+			log.Printf("[👤 CONTA] %d links vinculados à conta %s", n, userID)
+		}
+	}
+
+	ttl := time.Duration(utils.EnvInt("SESSION_DAYS", 30)) * 24 * time.Hour
+	sessionID := utils.GenerateRandomString(32)
+	_, err = database.DB.Exec("INSERT INTO sessions (id_hash, user_id, expires_at) VALUES (?, ?, ?)",
+		hashPassword(sessionID), userID, now.Add(ttl).Format(sqliteTimeFormat))
+	if err != nil {
+		http.Error(w, "DB Error", http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    sessionID,
+		Path:     "/",
+		MaxAge:   int(ttl.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(os.Getenv("BASE_URL"), "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, "/my-links.html", http.StatusSeeOther)
+}
+
+// AuthLogoutHandler encerra a sessão atual.
+// POST /api/auth/logout
+func AuthLogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if c, err := r.Cookie(sessionCookie); err == nil && c.Value != "" {
+		database.DB.Exec("DELETE FROM sessions WHERE id_hash = ?", hashPassword(c.Value))
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: "", Path: "/", MaxAge: -1, HttpOnly: true, SameSite: http.SameSiteLaxMode})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "logged_out"})
+}
+
+// MeHandler devolve a conta da sessão.
+// GET /api/me
+func MeHandler(w http.ResponseWriter, r *http.Request) {
+	userID, email, ok := sessionUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized (login required)", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"user_id": userID, "email": email})
+}
+
+// MyLinksHandler lista os links da conta logada (os criados com a sessão aberta e os
+// vinculados na verificação de e-mail).
+// GET /api/my-links
+func MyLinksHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, email, ok := sessionUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized (login required)", http.StatusUnauthorized)
+		return
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT id, COALESCE(title, ''), COALESCE(original_url, ''), max_views, total_views, unique_views,
+			expires_at, payment_status, is_private, created_at
+		FROM links WHERE user_id = ? ORDER BY created_at DESC, id`, userID)
+	if err != nil {
+		http.Error(w, "DB Error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	links := []map[string]interface{}{}
+	for rows.Next() {
+		var id, title, originalURL string
+		var maxViews, totalViews, uniqueViews int
+		var expiresAt, createdAt sql.NullTime
+		var paymentStatus sql.NullString
+		var isPrivate bool
+		if rows.Scan(&id, &title, &originalURL, &maxViews, &totalViews, &uniqueViews, &expiresAt, &paymentStatus, &isPrivate, &createdAt) != nil {
+			continue
+		}
+		var expires interface{}
+		if expiresAt.Valid {
+			expires = expiresAt.Time.Format(time.RFC3339)
+		}
+		links = append(links, map[string]interface{}{
+			"id":             id,
+			"title":          title,
+			"original_url":   originalURL,
+			"max_views":      maxViews,
+			"total_views":    totalViews,
+			"unique_views":   uniqueViews,
+			"expires_at":     expires,
+			"payment_status": paymentStatus.String,
+			"is_private":     isPrivate,
+			"created_at":     createdAt.Time.Format(time.RFC3339),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"email": email,
+		"links": links,
+	})
+}
@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"crom-vision/internal/database"
+)
+
+var loginTokenRe = regexp.MustCompile(`token=([0-9a-f]+)`)
+
+// loginAs percorre o fluxo do link mágico e devolve o cookie de sessão
+func loginAs(t *testing.T, email string) *http.Cookie {
+	t.Helper()
+	mails := make(chan string, 1)
+	orig := loginMailer
+	loginMailer = func(to, subject, body string) { mails <- body }
+	defer func() { loginMailer = orig }()
+
+	w := httptest.NewRecorder()
+	AuthLoginHandler(w, httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(`{"email":"`+email+`"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("login: esperava 200, obteve %d: %s", w.Code, w.Body.String())
+	}
+	var body string
+	select {
+	case body = <-mails:
+	case <-time.After(2 * time.Second):
+		t.Fatal("e-mail com o link de acesso não foi enviado")
+	}
+	m := loginTokenRe.FindStringSubmatch(body)
+	if m == nil {
+		t.Fatalf("e-mail sem o link de acesso: %s", body)
+	}
+
+	w = httptest.NewRecorder()
+	AuthVerifyHandler(w, httptest.NewRequest(http.MethodGet, "/api/auth/verify?token="+m[1], nil))
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/my-links.html" {
+		t.Fatalf("verify: esperava 303 para /my-links.html, obteve %d %q", w.Code, w.Header().Get("Location"))
+	}
+	for _, c := range w.Result().Cookies() {
+		if c.Name == sessionCookie && c.HttpOnly {
+			// O link é de uso único
+			w = httptest.NewRecorder()
+			AuthVerifyHandler(w, httptest.NewRequest(http.MethodGet, "/api/auth/verify?token="+m[1], nil))
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("reuso do link de acesso: esperava 400, obteve %d", w.Code)
+			}
+			return c
+		}
+	}
+	t.Fatal("verify não abriu a sessão")
+	return nil
+}
+
+func TestAccounts_ClaimsOnlyConfirmedLinks(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	insertLivePasswordLink(t, "acc1")
+	insertLivePasswordLink(t, "acc2")
+	insertLivePasswordLink(t, "other")
+	// acc1 teve o e-mail confirmado; acc2 foi criado por alguém que só digitou o e-mail do dono
+	database.DB.Exec("UPDATE links SET email = 'Dono@Test.com', is_private = 1 WHERE id IN ('acc1', 'acc2')")
+	database.DB.Exec("UPDATE links SET email_confirmed_at = CURRENT_TIMESTAMP WHERE id = 'acc1'")
+	database.DB.Exec("UPDATE links SET email = 'outro@test.com', is_private = 1, email_confirmed_at = CURRENT_TIMESTAMP WHERE id = 'other'")
+
+	cookie := loginAs(t, "dono@test.com")
+
+	req := httptest.NewRequest(http.MethodGet, "/api/my-links", nil)
+	req.AddCookie(cookie)
+	var resp struct {
+		Email string `json:"email"`
+		Links []struct {
+			ID string `json:"id"`
+		} `json:"links"`
+	}
+	list := func() {
+		w := httptest.NewRecorder()
+		MyLinksHandler(w, req)
+		json.NewDecoder(w.Body).Decode(&resp)
+	}
+	list()
+	if resp.Email != "dono@test.com" || len(resp.Links) != 1 || resp.Links[0].ID != "acc1" {
+		t.Fatalf("esperava só o link com e-mail confirmado, obteve %+v", resp)
+	}
+
+	// A listagem não vincula nada: um link novo com o mesmo e-mail fica de fora
+	insertTestLink(t, "acc3", "https://crom.run", "approved", "", 0, 0, true)
+	database.DB.Exec("UPDATE links SET email = 'dono@test.com', email_confirmed_at = CURRENT_TIMESTAMP WHERE id = 'acc3'")
+	list()
+	if len(resp.Links) != 1 {
+		t.Errorf("a listagem não deveria vincular links, obteve %d", len(resp.Links))
+	}
+
+	// A confirmação enviada no e-mail do checkout comprova o endereço e vincula o link
+	confirmURL := linkEmailConfirmation(httptest.NewRequest(http.MethodPost, "/api/checkout", nil), "acc2", "dono@test.com")
+	m := loginTokenRe.FindStringSubmatch(confirmURL)
+	if m == nil {
+		t.Fatalf("checkout sem sessão deveria gerar o link de confirmação, obteve %q", confirmURL)
+	}
+	w := httptest.NewRecorder()
+	AuthVerifyHandler(w, httptest.NewRequest(http.MethodGet, "/api/auth/verify?token="+m[1], nil))
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("confirmação: esperava 303, obteve %d", w.Code)
+	}
+	list()
+	if len(resp.Links) != 3 {
+		t.Errorf("esperava acc1, acc2 e acc3 após a confirmação, obteve %+v", resp.Links)
+	}
+
+	// O token do LGPD não abre sessão
+	token, _ := issueEmailToken("dono@test.com", tokenLGPD, "", time.Hour)
+	w = httptest.NewRecorder()
+	AuthVerifyHandler(w, httptest.NewRequest(http.MethodGet, "/api/auth/verify?token="+token, nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("token de outra finalidade: esperava 400, obteve %d", w.Code)
+	}
+
+	// Sem cookie não há listagem
+	w = httptest.NewRecorder()
+	MyLinksHandler(w, httptest.NewRequest(http.MethodGet, "/api/my-links", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("sem sessão: esperava 401, obteve %d", w.Code)
+	}
+}
+
+func TestMyLinksClaimHandler_LegacyLinks(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	// Links antigos: sem dono e sem email_confirmed_at
+	insertLivePasswordLink(t, "old1")
+	insertTestLink(t, "old2", "https://crom.run", "approved", "", 0, 0, false)
+	database.DB.Exec("UPDATE links SET email = 'Dono@Test.com' WHERE id IN ('old1', 'old2')")
+
+	cookie := loginAs(t, "dono@test.com")
+	claim := func(body string, c *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/my-links/claim", strings.NewReader(body))
+		if c != nil {
+			req.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		MyLinksClaimHandler(w, req)
+		return w
+	}
+	owner := func(id string) string {
+		var userID sql.NullString
+		database.DB.QueryRow("SELECT user_id FROM links WHERE id = ?", id).Scan(&userID)
+		return userID.String
+	}
+
+	if w := claim(`{"id":"old1","password":"123"}`, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("sem sessão: esperava 401, obteve %d", w.Code)
+	}
+	if w := claim(`{"id":"old1","password":"errada"}`, cookie); w.Code != http.StatusUnauthorized || owner("old1") != "" {
+		t.Errorf("senha errada: esperava 401 sem vincular, obteve %d", w.Code)
+	}
+
+	// Sessão + Senha Mágica vinculam na hora, mesmo sem o e-mail confirmado
+	if w := claim(`{"id":"old1","password":"123"}`, cookie); w.Code != http.StatusOK {
+		t.Fatalf("esperava 200, obteve %d: %s", w.Code, w.Body.String())
+	}
+	if owner("old1") == "" {
+		t.Fatal("old1 deveria estar na conta")
+	}
+	other := loginAs(t, "outro@test.com")
+	if w := claim(`{"id":"old1","password":"123"}`, other); w.Code != http.StatusConflict {
+		t.Errorf("link de outra conta: esperava 409, obteve %d", w.Code)
+	}
+
+	// Sem senha, a confirmação vai para o e-mail do checkout, não para quem pediu
+	mails := make(chan [2]string, 1)
+	orig := loginMailer
+	loginMailer = func(to, subject, body string) { mails <- [2]string{to, body} }
+	defer func() { loginMailer = orig }()
+	if w := claim(`{"id":"old2"}`, other); w.Code != http.StatusAccepted {
+		t.Fatalf("esperava 202, obteve %d: %s", w.Code, w.Body.String())
+	}
+	if owner("old2") != "" {
+		t.Fatal("o pedido sem senha não deveria vincular o link")
+	}
+	var mail [2]string
+	select {
+	case mail = <-mails:
+	case <-time.After(2 * time.Second):
+		t.Fatal("a confirmação não foi enviada")
+	}
+	m := loginTokenRe.FindStringSubmatch(mail[1])
+	if mail[0] != "dono@test.com" || m == nil {
+		t.Fatalf("esperava a confirmação em dono@test.com, obteve %q", mail[0])
+	}
+	w := httptest.NewRecorder()
+	AuthVerifyHandler(w, httptest.NewRequest(http.MethodGet, "/api/auth/verify?token="+m[1], nil))
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("confirmação: esperava 303, obteve %d", w.Code)
+	}
+	if owner("old2") != owner("old1") {
+		t.Errorf("old2 deveria ir para a conta de dono@test.com, user_id=%q", owner("old2"))
+	}
+}
+
+func TestAccounts_SessionReplacesLinkPassword(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	insertLivePasswordLink(t, "own1")
+	insertLivePasswordLink(t, "notmine")
+	database.DB.Exec("UPDATE links SET email = 'dono@test.com', is_private = 1, email_confirmed_at = CURRENT_TIMESTAMP WHERE id = 'own1'")
+	database.DB.Exec("UPDATE links SET email = 'outro@test.com', is_private = 1 WHERE id = 'notmine'")
+
+	cookie := loginAs(t, "dono@test.com")
+	withSession := func(req *http.Request) *http.Request {
+		req.AddCookie(cookie)
+		return req
+	}
+
+	w := httptest.NewRecorder()
+	PrivateStatsHandler(w, withSession(httptest.NewRequest(http.MethodPost, "/api/private-stats", strings.NewReader(`{"id":"own1"}`))))
+	if w.Code != http.StatusOK {
+		t.Errorf("private-stats com sessão: esperava 200, obteve %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	LinkStatsHandler(w, withSession(httptest.NewRequest(http.MethodGet, "/api/link-stats?id=own1", nil)))
+	if w.Code != http.StatusOK {
+		t.Errorf("link-stats com sessão: esperava 200, obteve %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	LinkWebhooksHandler(w, withSession(httptest.NewRequest(http.MethodGet, "/api/link-webhooks?id=own1", nil)))
+	if w.Code != http.StatusOK {
+		t.Errorf("link-webhooks com sessão: esperava 200, obteve %d", w.Code)
+	}
+
+	// A sessão não abre links de outra conta
+	w = httptest.NewRecorder()
+	LinkStatsHandler(w, withSession(httptest.NewRequest(http.MethodGet, "/api/link-stats?id=notmine", nil)))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("link de outro dono: esperava 401, obteve %d", w.Code)
+	}
+
+	// Depois do logout o cookie não vale mais
+	w = httptest.NewRecorder()
+	AuthLogoutHandler(w, withSession(httptest.NewRequest(http.MethodPost, "/api/auth/logout", nil)))
+	w = httptest.NewRecorder()
+	LinkStatsHandler(w, withSession(httptest.NewRequest(http.MethodGet, "/api/link-stats?id=own1", nil)))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("após logout: esperava 401, obteve %d", w.Code)
+	}
+}
+
+func TestAuthLoginHandler_ValidationAndRateLimit(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	orig := loginMailer
+	loginMailer = func(to, subject, body string) {}
+	defer func() { loginMailer = orig }()
+
+	post := func(email string) int {
+		w := httptest.NewRecorder()
+		AuthLoginHandler(w, httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(`{"email":"`+email+`"}`)))
+		return w.Code
+	}
+	if code := post("sem-arroba"); code != http.StatusBadRequest {
+		t.Errorf("e-mail inválido: esperava 400, obteve %d", code)
+	}
+	for i := 0; i < maxLoginRequestsPerHour; i++ {
+		if code := post("limite@test.com"); code != http.StatusOK {
+			t.Fatalf("pedido %d: esperava 200, obteve %d", i+1, code)
+		}
+	}
+	if code := post("limite@test.com"); code != http.StatusTooManyRequests {
+		t.Errorf("acima do limite: esperava 429, obteve %d", code)
+	}
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"crom-vision/internal/database"
+)
+
+// alertSettings são os alertas por e-mail configuráveis por link
+type alertSettings struct {
+	FirstOpen    bool `json:"first_open"`
+	Threshold    int  `json:"threshold"`
+	NearLimitPct int  `json:"near_limit_pct"`
+}
+
+func (a alertSettings) validate() error {
+	if a.Threshold < 0 {
+		return errors.New("alert_threshold deve ser positivo")
+	}
+	if a.NearLimitPct < 0 || a.NearLimitPct > 100 {
+		return errors.New("alert_near_limit_pct deve estar entre 1 e 100")
+	}
+	return nil
+}
+
+// parseAlertSettings lê os campos de formulário do checkout
+func parseAlertSettings(firstOpen, threshold, nearLimitPct string) (alertSettings, error) {
+	var a alertSettings
+	a.FirstOpen = firstOpen == "1" || firstOpen == "true"
+	if v := strings.TrimSpace(threshold); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return a, errors.New("alert_threshold inválido")
+		}
+		a.Threshold = n
+	}
+	if v := strings.TrimSpace(nearLimitPct); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return a, errors.New("alert_near_limit_pct inválido")
+		}
+		a.NearLimitPct = n
+	}
+	return a, a.validate()
+}
+
+// LinkAlertsHandler consulta ou altera os alertas por e-mail do link.
+// GET  /api/link-alerts?id=xxx&password=yyy
+// POST /api/link-alerts {"id","password","first_open":true,"threshold":100,"near_limit_pct":90}
+// Alterar um alerta o rearma: ele volta a disparar uma vez quando a condição for atingida.
+func LinkAlertsHandler(w http.ResponseWriter, r *http.Request) {
+	var id, password string
+	var req struct {
+		ID       string `json:"id"`
+		Password string `json:"password"`
+		alertSettings
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		id, password = credentialFromQuery(r)
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid Payload", http.StatusBadRequest)
+			return
+		}
+		id, password = req.ID, req.Password
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if id == "" {
+		http.Error(w, "ID missing", http.StatusBadRequest)
+		return
+	}
+	if !ownerAuthorized(r, id, password) {
+		http.Error(w, "Unauthorized (Invalid Password)", http.StatusUnauthorized)
+		return
+	}
+
+	var email sql.NullString
+	var current alertSettings
+	var firstSent, thresholdSent, nearSent sql.NullTime
+	err := database.DB.QueryRow(`
+		SELECT email, COALESCE(alert_first_open, 0), COALESCE(alert_threshold, 0), COALESCE(alert_near_limit_pct, 0),
+			alert_first_open_sent_at, alert_threshold_sent_at, alert_near_limit_sent_at
+		FROM links WHERE id = ?`, id).Scan(&email, &current.FirstOpen, &current.Threshold, &current.NearLimitPct,
+		&firstSent, &thresholdSent, &nearSent)
+	if err != nil {
+		http.Error(w, "Link not found", http.StatusNotFound)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		next := req.alertSettings
+		if err := next.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !email.Valid || email.String == "" {
+			http.Error(w, "Link sem e-mail cadastrado para receber alertas", http.StatusBadRequest)
+			return
+		}
+
+		_, err := database.DB.Exec(`
+			UPDATE links SET alert_first_open = ?, alert_threshold = ?, alert_near_limit_pct = ?,
+				alert_first_open_sent_at = CASE WHEN ? THEN alert_first_open_sent_at ELSE NULL END,
+				alert_threshold_sent_at = CASE WHEN ? THEN alert_threshold_sent_at ELSE NULL END,
+				alert_near_limit_sent_at = CASE WHEN ? THEN alert_near_limit_sent_at ELSE NULL END
+			WHERE id = ?`,
+			next.FirstOpen, next.Threshold, next.NearLimitPct,
+			next.FirstOpen == current.FirstOpen, next.Threshold == current.Threshold, next.NearLimitPct == current.NearLimitPct,
+			id)
+		if err != nil {
+			http.Error(w, "DB Error", http.StatusInternalServerError)
+			return
+		}
+		if next.FirstOpen != current.FirstOpen {
+			firstSent = sql.NullTime{}
+		}
+		if next.Threshold != current.Threshold {
+			thresholdSent = sql.NullTime{}
+		}
+		if next.NearLimitPct != current.NearLimitPct {
+			nearSent = sql.NullTime{}
+		}
+		current = next
+	}
+
+	sentAt := func(t sql.NullTime) interface{} {
+		if t.Valid {
+			return t.Time.Format(time.RFC3339)
+		}
+		return nil
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":             id,
+		"first_open":     current.FirstOpen,
+		"threshold":      current.Threshold,
+		"near_limit_pct": current.NearLimitPct,
+		"sent": map[string]interface{}{
+			"first_open":     sentAt(firstSent),
+			"threshold":      sentAt(thresholdSent),
+			"near_limit_pct": sentAt(nearSent),
+		},
+	})
+}
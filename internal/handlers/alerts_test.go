@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"crom-vision/internal/database"
+)
+
+func TestParseAlertSettings(t *testing.T) {
+	a, err := parseAlertSettings("1", "100", "90")
+	if err != nil || !a.FirstOpen || a.Threshold != 100 || a.NearLimitPct != 90 {
+		t.Errorf("parse inesperado: %+v %v", a, err)
+	}
+	if _, err := parseAlertSettings("", "", "150"); err == nil {
+		t.Error("percentual acima de 100 deveria falhar")
+	}
+	if _, err := parseAlertSettings("", "abc", ""); err == nil {
+		t.Error("marco não numérico deveria falhar")
+	}
+}
+
+func TestLinkAlertsHandler_UpdateRearms(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	insertLivePasswordLink(t, "alh1")
+	database.DB.Exec(`UPDATE links SET email = 'dono@test.com', alert_threshold = 10, alert_first_open = 1,
+		alert_threshold_sent_at = datetime('now'), alert_first_open_sent_at = datetime('now') WHERE id = 'alh1'`)
+
+	body := `{"id":"alh1","password":"123","first_open":true,"threshold":50,"near_limit_pct":90}`
+	w := httptest.NewRecorder()
+	LinkAlertsHandler(w, httptest.NewRequest(http.MethodPost, "/api/link-alerts", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("esperava 200, obteve %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		Threshold int                    `json:"threshold"`
+		Sent      map[string]interface{} `json:"sent"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.Threshold != 50 {
+		t.Errorf("marco não atualizado: %d", resp.Threshold)
+	}
+	if resp.Sent["threshold"] != nil {
+		t.Error("alterar o marco deveria rearmar o alerta")
+	}
+	if resp.Sent["first_open"] == nil {
+		t.Error("alerta de abertura inalterado não deveria ser rearmado")
+	}
+}
+
+func TestLinkAlertsHandler_RequiresEmailAndPassword(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	insertLivePasswordLink(t, "alh2")
+
+	w := httptest.NewRecorder()
+	LinkAlertsHandler(w, httptest.NewRequest(http.MethodGet, "/api/link-alerts?id=alh2&password=errada", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("esperava 401, obteve %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	LinkAlertsHandler(w, httptest.NewRequest(http.MethodPost, "/api/link-alerts",
+		strings.NewReader(`{"id":"alh2","password":"123","first_open":true}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("link sem e-mail deveria retornar 400, obteve %d", w.Code)
+	}
+}
@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"html"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"crom-vision/internal/database"
+)
+
+// Cores nomeadas aceitas em ?color= e ?label_color= (além de hex sem #)
+var badgeColors = map[string]string{
+	"brightgreen": "#4c1",
+	"green":       "#97ca00",
+	"yellow":      "#dfb317",
+	"orange":      "#fe7d37",
+	"red":         "#e05d44",
+	"blue":        "#007ec6",
+	"gray":        "#555",
+	"grey":        "#555",
+	"lightgray":   "#9f9f9f",
+	"crom":        "#10b981",
+}
+
+var hexColorRe = regexp.MustCompile(`^[0-9a-fA-F]{3}([0-9a-fA-F]{3})?$`)
+
+func badgeColor(raw, def string) string {
+	raw = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(raw), "#"))
+	if c, ok := badgeColors[raw]; ok {
+		return c
+	}
+	if hexColorRe.MatchString(raw) {
+		return "#" + raw
+	}
+	return def
+}
+
+// compactCount formata contagens como os badges do shields.io (1.2k, 3.4M)
+func compactCount(n int) string {
+	switch {
+	case n >= 1000000:
+		return strings.TrimSuffix(fmt.Sprintf("%.1f", float64(n)/1000000), ".0") + "M"
+	case n >= 10000:
+		return fmt.Sprintf("%dk", n/1000)
+	case n >= 1000:
+		return strings.TrimSuffix(fmt.Sprintf("%.1f", float64(n)/1000), ".0") + "k"
+	}
+	return strconv.Itoa(n)
+}
+
+// setEmbedNoCache impede que proxies de imagem (ex: camo do GitHub) congelem o contador
+func setEmbedNoCache(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "image/svg+xml; charset=utf-8")
+	w.Header().Set("Cache-Control", "max-age=0, no-cache, no-store, must-revalidate, private")
+	w.Header().Set("Pragma", "no-cache")
+	w.Header().Set("Expires", "Thu, 01 Jan 1970 00:00:00 GMT")
+	w.Header().Set("ETag", `"`+strconv.FormatInt(time.Now().UnixNano(), 36)+`"`)
+}
+
+// embedLink são os campos do link necessários para badges e sparklines
+type embedLink struct {
+	TotalViews  int
+	UniqueViews int
+	Status      string // "" quando o hit foi admitido, senão o X-Crom-Status
+	Unique      bool
+	Counted     bool // o hit entrou no contador (não é unfurl nem suspeito excluído)
+	Private     bool // link privado: o embed conta o hit, mas não exibe números
+}
+
+// countEmbedHit passa o acesso pela mesma admissão e registro do pixel /i/ (regras de
+// entrega, robôs de pré-visualização, detecção de fraude) e devolve os totais já
+// incluindo este acesso quando ele foi contado.
+func countEmbedHit(r *http.Request, id string) (embedLink, error) {
+	link, err := loadPixelLink(id)
+	if err != nil {
+		return embedLink{}, err
+	}
+	l := embedLink{TotalViews: link.TotalViews, UniqueViews: link.UniqueViews, Private: link.IsPrivate}
+
+	if l.Status = link.gate(time.Now()); l.Status != "" {
+		return l, nil
+	}
+	visitor, blocked := admitVisitor(r, link)
+	if blocked != "" {
+		l.Status = blocked
+		return l, nil
+	}
+
+	hit := recordPixelHit(link, visitor, sql.NullInt64{})
+	if !hit.Counted {
+		return l, nil
+	}
+	l.Counted = true
+	l.TotalViews++
+	if hit.Unique {
+		l.UniqueViews++
+	}
+	l.Unique = hit.Unique
+	return l, nil
+}
+
+// renderBadge gera um badge SVG no estilo flat do shields.io
+func renderBadge(label, value, labelColor, valueColor string) string {
+	// Largura aproximada: ~6.5px por caractere em Verdana 11px
+	textWidth := func(s string) int { return len([]rune(s))*13/2 + 10 }
+	lw, vw := textWidth(label), textWidth(value)
+	total := lw + vw
+	label, value = html.EscapeString(label), html.EscapeString(value)
+
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="20" role="img" aria-label="%s: %s">`+
+		`<title>%s: %s</title>`+
+		`<linearGradient id="s" x2="0" y2="100%%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>`+
+		`<clipPath id="r"><rect width="%d" height="20" rx="3" fill="#fff"/></clipPath>`+
+		`<g clip-path="url(#r)"><rect width="%d" height="20" fill="%s"/><rect x="%d" width="%d" height="20" fill="%s"/><rect width="%d" height="20" fill="url(#s)"/></g>`+
+		`<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">`+
+		`<text x="%d" y="15" fill="#010101" fill-opacity=".3">%s</text><text x="%d" y="14">%s</text>`+
+		`<text x="%d" y="15" fill="#010101" fill-opacity=".3">%s</text><text x="%d" y="14">%s</text></g></svg>`,
+		total, label, value, label, value,
+		total,
+		lw, labelColor, lw, vw, valueColor, total,
+		lw/2, label, lw/2, label,
+		lw+vw/2, value, lw+vw/2, value)
+}
+
+// BadgeHandler serve um contador de visualizações em SVG e contabiliza o próprio hit.
+// GET /badge/:id.svg[?metric=total|unique][&label=views][&color=crom][&label_color=gray]
+func BadgeHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSuffix(r.URL.Path[len("/badge/"):], ".svg")
+	if id == "" {
+		http.Error(w, "ID missing", http.StatusBadRequest)
+		return
+	}
+	setEmbedNoCache(w)
+
+	q := r.URL.Query()
+	label := q.Get("label")
+	if label == "" {
+		label = "views"
+	}
+	if len([]rune(label)) > 40 {
+		label = string([]rune(label)[:40])
+	}
+	labelColor := badgeColor(q.Get("label_color"), "#555")
+	valueColor := badgeColor(q.Get("color"), badgeColors["crom"])
+
+	link, err := countEmbedHit(r, id)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, renderBadge(label, "not found", labelColor, badgeColors["lightgray"]))
+		return
+	}
+	if link.Status != "" {
+		w.Header().Set("X-Crom-Status", link.Status)
+	}
+	if link.Private {
+		fmt.Fprint(w, renderBadge(label, "private", labelColor, badgeColors["lightgray"]))
+		return
+	}
+
+	count := link.TotalViews
+	if q.Get("metric") == "unique" {
+		count = link.UniqueViews
+	}
+	fmt.Fprint(w, renderBadge(label, compactCount(count), labelColor, valueColor))
+}
+
+// sparkSeries conta os hits servidos do link em n intervalos iguais terminando agora
+func sparkSeries(id string, now time.Time, bucket time.Duration, n int, uniqueOnly bool) []int {
+	start := now.Add(-bucket * time.Duration(n))
+	query := "SELECT accessed_at FROM access_logs WHERE link_id = ? AND status = 'served' AND accessed_at >= ?"
+	if uniqueOnly {
+		query += " AND is_unique = 1"
+	}
+	series := make([]int, n)
+	rows, err := database.DB.Query(query, id, start.UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return series
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var at time.Time
+		if rows.Scan(&at) == nil {
+			if i := int(at.Sub(start) / bucket); i >= 0 && i < n {
+				series[i]++
+			}
+		}
+	}
+	return series
+}
+
+// renderSparkline desenha a série como uma linha com área preenchida
+func renderSparkline(series []int, width, height int, color string) string {
+	max := 1
+	for _, v := range series {
+		if v > max {
+			max = v
+		}
+	}
+	step := float64(width)
+	if len(series) > 1 {
+		step = float64(width) / float64(len(series)-1)
+	}
+
+	var points strings.Builder
+	for i, v := range series {
+		x := float64(i) * step
+		y := float64(height-2) - float64(v)*float64(height-4)/float64(max)
+		fmt.Fprintf(&points, "%.1f,%.1f ", x, y)
+	}
+	line := strings.TrimSpace(points.String())
+
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" role="img" aria-label="atividade recente">`+
+		`<polygon points="0,%d %s %d,%d" fill="%s" fill-opacity=".15"/>`+
+		`<polyline points="%s" fill="none" stroke="%s" stroke-width="1.5" stroke-linejoin="round" stroke-linecap="round"/></svg>`,
+		width, height, width, height,
+		height, line, width, height, color,
+		line, color)
+}
+
+// SparkHandler serve um sparkline SVG da atividade recente e contabiliza o próprio hit.
+// GET /spark/:id.svg[?period=24h|7d|30d][&metric=total|unique][&color=crom][&width=120][&height=30]
+func SparkHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSuffix(r.URL.Path[len("/spark/"):], ".svg")
+	if id == "" {
+		http.Error(w, "ID missing", http.StatusBadRequest)
+		return
+	}
+	setEmbedNoCache(w)
+
+	q := r.URL.Query()
+	bucket, n := time.Hour, 24
+	switch q.Get("period") {
+	case "7d":
+		bucket, n = 6*time.Hour, 28
+	case "30d":
+		bucket, n = 24*time.Hour, 30
+	}
+
+	width, _ := strconv.Atoi(q.Get("width"))
+	if width < 20 || width > 1000 {
+		width = 120
+	}
+	height, _ := strconv.Atoi(q.Get("height"))
+	if height < 10 || height > 300 {
+		height = 30
+	}
+	color := badgeColor(q.Get("color"), badgeColors["crom"])
+
+	link, err := countEmbedHit(r, id)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, renderSparkline(make([]int, n), width, height, badgeColors["lightgray"]))
+		return
+	}
+	if link.Status != "" {
+		w.Header().Set("X-Crom-Status", link.Status)
+	}
+	if link.Private {
+		fmt.Fprint(w, renderSparkline(make([]int, n), width, height, badgeColors["lightgray"]))
+		return
+	}
+
+	uniqueOnly := q.Get("metric") == "unique"
+	series := sparkSeries(id, time.Now().UTC(), bucket, n, uniqueOnly)
+	// O hit atual ainda está sendo gravado em segundo plano
+	if link.Counted && (!uniqueOnly || link.Unique) {
+		series[n-1]++
+	}
+	fmt.Fprint(w, renderSparkline(series, width, height, color))
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"crom-vision/internal/database"
+)
+
+func TestCompactCount(t *testing.T) {
+	tests := map[int]string{0: "0", 999: "999", 1000: "1k", 1250: "1.2k", 15300: "15k", 2000000: "2M", 3450000: "3.5M"}
+	for n, want := range tests {
+		if got := compactCount(n); got != want {
+			t.Errorf("compactCount(%d) = %q, esperava %q", n, got, want)
+		}
+	}
+}
+
+func TestBadgeColor(t *testing.T) {
+	if badgeColor("blue", "#000") != "#007ec6" || badgeColor("#ff0000", "#000") != "#ff0000" || badgeColor("fff", "#000") != "#fff" {
+		t.Error("cores válidas deveriam ser aceitas")
+	}
+	if badgeColor(`red"/><script>`, "#000") != "#000" {
+		t.Error("valor inválido deveria cair no padrão")
+	}
+}
+
+func TestBadgeHandler_CountsHitAndDefeatsCache(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	insertTestLink(t, "bdg1", "", "approved", "", 0, 41, false)
+
+	req := httptest.NewRequest(http.MethodGet, "/badge/bdg1.svg?label=acessos&color=blue", nil)
+	w := httptest.NewRecorder()
+	BadgeHandler(w, req)
+
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "image/svg+xml") {
+		t.Errorf("Content-Type inesperado: %s", ct)
+	}
+	if cc := w.Header().Get("Cache-Control"); !strings.Contains(cc, "no-cache") || !strings.Contains(cc, "no-store") {
+		t.Errorf("Cache-Control deveria impedir cache: %s", cc)
+	}
+	if w.Header().Get("ETag") == "" || w.Header().Get("Expires") == "" {
+		t.Error("ETag e Expires deveriam estar presentes")
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, ">acessos<") || !strings.Contains(body, ">42<") || !strings.Contains(body, "#007ec6") {
+		t.Errorf("badge inesperado: %s", body)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	var total int
+	database.DB.QueryRow("SELECT total_views FROM links WHERE id = 'bdg1'").Scan(&total)
+	if total != 42 {
+		t.Errorf("o badge deveria contabilizar o hit, total=%d", total)
+	}
+}
+
+func TestBadgeHandler_ExpiredDoesNotCount(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	insertTestLink(t, "bdg2", "", "approved", "", 10, 10, false)
+
+	w := httptest.NewRecorder()
+	BadgeHandler(w, httptest.NewRequest(http.MethodGet, "/badge/bdg2.svg", nil))
+	if w.Header().Get("X-Crom-Status") != "Limit-Reached" || !strings.Contains(w.Body.String(), ">10<") {
+		t.Errorf("badge de link esgotado deveria mostrar o total sem contar: %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	BadgeHandler(w, httptest.NewRequest(http.MethodGet, "/badge/naoexiste.svg", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("esperava 404, obteve %d", w.Code)
+	}
+}
+
+func TestSparkHandler_RendersSeries(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	insertTestLink(t, "spk1", "", "approved", "", 0, 0, false)
+	for i := 0; i < 3; i++ {
+		database.DB.Exec("INSERT INTO access_logs (link_id, ip_hash, accessed_at) VALUES ('spk1', 'h', ?)",
+			time.Now().UTC().Add(-2*time.Hour).Format("2006-01-02 15:04:05"))
+	}
+
+	series := sparkSeries("spk1", time.Now().UTC(), time.Hour, 24, false)
+	if series[21] != 3 {
+		t.Errorf("hits de 2h atrás deveriam cair no bucket 21: %v", series)
+	}
+
+	w := httptest.NewRecorder()
+	SparkHandler(w, httptest.NewRequest(http.MethodGet, "/spark/spk1.svg?width=200&height=40", nil))
+	body := w.Body.String()
+	if !strings.Contains(body, `width="200"`) || !strings.Contains(body, "<polyline") {
+		t.Errorf("sparkline inesperado: %s", body)
+	}
+}
+
+func TestBadgeHandler_SharesPixelAdmission(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	insertTestLink(t, "bdg3", "", "approved", "", 0, 5, false)
+	insertTestLink(t, "bdg4", "", "approved", "", 0, 5, true)
+	insertTestLink(t, "bdg5", "", "approved", "", 0, 5, false)
+	// Janela de entrega que não inclui a hora atual
+	h := time.Now().UTC().Hour()
+	database.DB.Exec("UPDATE links SET delivery_hours = ?, delivery_tz = 'UTC' WHERE id = 'bdg5'", fmt.Sprintf("%d-%d", (h+2)%24, (h+3)%24))
+
+	badge := func(id, ua string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/badge/"+id+".svg", nil)
+		req.Header.Set("User-Agent", ua)
+		w := httptest.NewRecorder()
+		BadgeHandler(w, req)
+		return w
+	}
+
+	// Robô de pré-visualização recebe o badge, mas não conta
+	if w := badge("bdg3", "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)"); !strings.Contains(w.Body.String(), ">5<") {
+		t.Errorf("unfurl não deveria somar ao contador: %s", w.Body.String())
+	}
+
+	// Link privado conta o hit, mas o badge não exibe números
+	w := badge("bdg4", "Mozilla/5.0")
+	if body := w.Body.String(); !strings.Contains(body, ">private<") || strings.Contains(body, ">6<") {
+		t.Errorf("badge de link privado não deveria exibir contagens: %s", body)
+	}
+
+	// Fora da janela de horário: a tentativa é registrada sem consumir views
+	if w := badge("bdg5", "Mozilla/5.0"); w.Header().Get("X-Crom-Status") != "Outside-Window" {
+		t.Errorf("esperava Outside-Window, obteve %q", w.Header().Get("X-Crom-Status"))
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	var total, unfurls, blocked int
+	database.DB.QueryRow("SELECT total_views FROM links WHERE id = 'bdg3'").Scan(&total)
+	database.DB.QueryRow("SELECT COUNT(*) FROM access_logs WHERE link_id = 'bdg3' AND status = 'unfurl'").Scan(&unfurls)
+	if total != 5 || unfurls != 1 {
+		t.Errorf("unfurl: esperava total 5 e 1 log de unfurl, obteve %d/%d", total, unfurls)
+	}
+	database.DB.QueryRow("SELECT total_views FROM links WHERE id = 'bdg4'").Scan(&total)
+	if total != 6 {
+		t.Errorf("hit no badge do link privado deveria contar, total=%d", total)
+	}
+	database.DB.QueryRow("SELECT total_views FROM links WHERE id = 'bdg5'").Scan(&total)
+	database.DB.QueryRow("SELECT COUNT(*) FROM access_logs WHERE link_id = 'bdg5' AND status = 'time_blocked'").Scan(&blocked)
+	if total != 5 || blocked != 1 {
+		t.Errorf("bloqueio por horário: esperava total 5 e 1 log time_blocked, obteve %d/%d", total, blocked)
+	}
+}
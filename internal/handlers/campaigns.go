@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"crom-vision/internal/database"
+	"crom-vision/internal/utils"
+)
+
+const maxCampaignNameLen = 80
+
+// campaignPasswordMatches confere a senha de uma campanha (mesmo SHA-256 da Senha Mágica dos links)
+func campaignPasswordMatches(id, password string) bool {
+	var dbPassHash sql.NullString
+	if err := database.DB.QueryRow("SELECT password_hash FROM campaigns WHERE id = ?", id).Scan(&dbPassHash); err != nil {
+		return false
+	}
+	return dbPassHash.Valid && dbPassHash.String != "" && hashPassword(password) == dbPassHash.String
+}
+
+// CampaignsHandler cria uma campanha para agrupar links.
+// POST /api/campaigns {"name":"Black Friday"} — devolve campaign_id e a senha (exibida uma única vez).
+// Os links entram na campanha pelo checkout (campaign_id + campaign_password).
+func CampaignsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid Payload", http.StatusBadRequest)
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len([]rune(name)) > maxCampaignNameLen {
+		http.Error(w, "name obrigatório (até 80 caracteres)", http.StatusBadRequest)
+		return
+	}
+
+	id := "cmp_" + utils.GenerateRandomString(8)
+	password := utils.GenerateRandomString(10)
+	if _, err := database.DB.Exec("INSERT INTO campaigns (id, name, password_hash) VALUES (?, ?, ?)", id, name, hashPassword(password)); err != nil {
+		log.Printf("[DB ERR] Falha ao criar campanha: %v", err)
+		http.Error(w, "DB Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{
+		"campaign_id": id,
+		"name":        name,
+		"password":    password,
+	})
+}
@@ -2,15 +2,19 @@ package handlers
 
 import (
 	"crypto/sha256"
+	"database/sql"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"crom-vision/internal/database"
@@ -51,10 +55,7 @@ func CheckoutHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ip := r.RemoteAddr
-	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
-		ip = forwarded
-	}
+	ip := utils.ClientIP(r)
 	ipHash := utils.ComposeFingerprintHash(ip, "")
 
 	appMode := os.Getenv("APP_MODE")
@@ -95,48 +96,163 @@ func CheckoutHandler(w http.ResponseWriter, r *http.Request) {
 	originalURL := r.FormValue("original_url")
 	maxViews, _ := strconv.Atoi(r.FormValue("max_views"))
 
+	// Arquivos gravados nesta requisição: qualquer erro antes do INSERT os apaga,
+	// para uploads recusados pelas validações seguintes não ficarem ocupando o disco
+	var savedPaths []string
+	committed := false
+	defer func() {
+		if committed {
+			return
+		}
+		for _, p := range savedPaths {
+			os.Remove(p)
+		}
+	}()
+
 	var savedFilePath string
 	file, header, errFile := r.FormFile("image")
 	if errFile == nil {
 		defer file.Close()
-		
-		buffer := make([]byte, 512)
-		if n, err := file.Read(buffer); err == nil && n > 0 {
-			contentType := http.DetectContentType(buffer[:n])
-			if contentType != "image/jpeg" && contentType != "image/png" && contentType != "image/gif" {
-				http.Error(w, "Invalid file format. Only JPG, PNG and GIF are allowed.", http.StatusBadRequest)
+
+		path, status, err := saveUploadedImage(file, header)
+		if err != nil && status != 0 {
+			http.Error(w, err.Error(), status)
+			return
+		}
+		savedFilePath = path
+		if path != "" {
+			savedPaths = append(savedPaths, path)
+		}
+	}
+
+	// Teste A/B: múltiplas imagens no campo "variants", com pesos e rótulos opcionais
+	// (variant_weights=70,30 / variant_labels=Azul,Verde / variant_mode=weighted|sticky)
+	type uploadedVariant struct {
+		label    string
+		filePath string
+		weight   int
+	}
+	var variants []uploadedVariant
+	if r.MultipartForm != nil {
+		weights := strings.Split(r.FormValue("variant_weights"), ",")
+		labels := strings.Split(r.FormValue("variant_labels"), ",")
+		for i, vh := range r.MultipartForm.File["variants"] {
+			vf, err := vh.Open()
+			if err != nil {
+				http.Error(w, "Failed to inspect file.", http.StatusInternalServerError)
 				return
 			}
-		} else {
-             http.Error(w, "Failed to inspect file.", http.StatusInternalServerError)
-             return
-        }
-        
-        file.Seek(0, 0)
-		
-		storagePath := os.Getenv("STORAGE_PATH")
-		if storagePath == "" {
-			storagePath = "./storage"
+			path, status, err := saveUploadedImage(vf, vh)
+			vf.Close()
+			if err != nil && status != 0 {
+				http.Error(w, err.Error(), status)
+				return
+			}
+			if path == "" {
+				continue
+			}
+			savedPaths = append(savedPaths, path)
+
+			weight := 1
+			if i < len(weights) {
+				if n, err := strconv.Atoi(strings.TrimSpace(weights[i])); err == nil && n >= 0 {
+					weight = n
+				}
+			}
+			label := string(rune('A' + i%26))
+			if i < len(labels) && strings.TrimSpace(labels[i]) != "" {
+				label = strings.TrimSpace(labels[i])
+			}
+			variants = append(variants, uploadedVariant{label: label, filePath: path, weight: weight})
 		}
-		
-		ext := ""
-		if header.Filename != "" {
-			ext = filepath.Ext(header.Filename)
+	}
+	variantMode := r.FormValue("variant_mode")
+	if variantMode != "sticky" {
+		variantMode = "weighted"
+	}
+	rules := deliveryRules{
+		AllowedCountries: r.FormValue("allowed_countries"),
+		BlockedCountries: r.FormValue("blocked_countries"),
+		Hours:            r.FormValue("delivery_hours"),
+		Days:             r.FormValue("delivery_days"),
+		TZ:               r.FormValue("delivery_tz"),
+	}
+	if err := rules.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Ativação agendada: o link só passa a servir o conteúdo real a partir de not_before
+	var notBefore interface{}
+	var notBeforeTime time.Time
+	if raw := strings.TrimSpace(r.FormValue("not_before")); raw != "" {
+		// Sem offset, o horário é lido no fuso "tz" (ou no delivery_tz do link)
+		tz := strings.TrimSpace(r.FormValue("tz"))
+		if tz == "" {
+			tz = strings.TrimSpace(rules.TZ)
 		}
-		if ext == "" {
-			ext = ".png"
+		nb, err := parseNotBefore(raw, tz)
+		if err != nil {
+			http.Error(w, "not_before inválido: "+err.Error()+". Use RFC3339 (ex: 2025-01-15T09:00:00-03:00) ou informe tz (ex: America/Sao_Paulo).", http.StatusBadRequest)
+			return
 		}
+		notBeforeTime = nb.UTC()
+		notBefore = notBeforeTime.Format("2006-01-02 15:04:05")
+	}
 
-		fileName := utils.GenerateRandomString(12) + ext
-		savedFilePath = filepath.Join(storagePath, fileName)
+	readTracking := r.FormValue("read_tracking") == "1" || r.FormValue("read_tracking") == "true"
 
-		out, err := os.Create(savedFilePath)
-		if err == nil {
-			defer out.Close()
-			io.Copy(out, file)
-		} else {
-			savedFilePath = "" // failed to save
-			log.Printf("[ERR] Falha ao tentar salvar arquivo: %v", err)
+	// Hits marcados como suspeitos pela detecção de fraude não consomem max_views
+	fraudExclude := r.FormValue("fraud_exclude") == "1" || r.FormValue("fraud_exclude") == "true"
+
+	// Alertas por e-mail (exigem o campo email)
+	alerts, err := parseAlertSettings(r.FormValue("alert_first_open"), r.FormValue("alert_threshold"), r.FormValue("alert_near_limit_pct"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Metadados da página de compartilhamento /p/:id
+	title := strings.TrimSpace(r.FormValue("title"))
+	description := strings.TrimSpace(r.FormValue("description"))
+	altText := strings.TrimSpace(r.FormValue("alt_text"))
+	if len([]rune(title)) > maxTitleLen || len([]rune(description)) > maxDescriptionLen || len([]rune(altText)) > maxAltTextLen {
+		http.Error(w, fmt.Sprintf("Metadados longos demais (title até %d, description e alt_text até %d caracteres).", maxTitleLen, maxDescriptionLen), http.StatusBadRequest)
+		return
+	}
+
+	digest := r.FormValue("digest")
+	if digest != "" && digest != services.DigestDaily && digest != services.DigestWeekly {
+		http.Error(w, "digest inválido (use daily ou weekly)", http.StatusBadRequest)
+		return
+	}
+
+	// Campanha opcional: exige a senha da campanha para anexar o link
+	var campaignID sql.NullString
+	if cid := strings.TrimSpace(r.FormValue("campaign_id")); cid != "" {
+		if !campaignPasswordMatches(cid, r.FormValue("campaign_password")) {
+			http.Error(w, "Campanha inexistente ou senha da campanha inválida.", http.StatusForbidden)
+			return
+		}
+		campaignID = sql.NullString{String: cid, Valid: true}
+	}
+
+	// Com sessão aberta, o link já nasce na conta (sem esperar o vínculo por e-mail)
+	var ownerID sql.NullString
+	if userID, _, ok := sessionUser(r); ok {
+		ownerID = sql.NullString{String: userID, Valid: true}
+	}
+
+	// Sem imagem principal, a primeira variante vira o arquivo do /p/:id
+	if savedFilePath == "" && len(variants) > 0 {
+		savedFilePath = variants[0].filePath
+	}
+
+	var imageWidth, imageHeight sql.NullInt64
+	if savedFilePath != "" {
+		if iw, ih, err := utils.ImageDimensions(savedFilePath); err == nil {
+			imageWidth = sql.NullInt64{Int64: int64(iw), Valid: true}
+			imageHeight = sql.NullInt64{Int64: int64(ih), Valid: true}
 		}
 	}
 
@@ -161,6 +277,10 @@ func CheckoutHandler(w http.ResponseWriter, r *http.Request) {
 
 	id := "c_" + utils.GenerateRandomString(4)
 	expiresAt := time.Now().Add(duration)
+	// O tempo contratado começa a contar a partir da ativação agendada
+	if notBeforeTime.After(time.Now()) {
+		expiresAt = notBeforeTime.Add(duration)
+	}
 
 	paymentStatus := "pending"
 	isPrivate := true
@@ -202,16 +322,28 @@ func CheckoutHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	_, errDB := database.DB.Exec(`
-		INSERT INTO links (id, original_url, max_views, expires_at, tier, email, payment_status, is_private, password_hash, file_path, creator_ip, price, mp_payment_id, mp_qr_code, mp_qr_base64, mp_ticket_url)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		INSERT INTO links (id, original_url, max_views, expires_at, tier, email, payment_status, is_private, password_hash, file_path, creator_ip, price, mp_payment_id, mp_qr_code, mp_qr_base64, mp_ticket_url, variant_mode,
+			allowed_countries, blocked_countries, delivery_hours, delivery_days, delivery_tz, not_before, read_tracking,
+			alert_first_open, alert_threshold, alert_near_limit_pct, title, description, alt_text, image_width, image_height, campaign_id, fraud_exclude, user_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		id, originalURL, maxViews, expiresAt, tierReq, email, paymentStatus, isPrivate, passwordHash, savedFilePath, ipHash,
-		price, mpPaymentID, mpQRCode, mpQRBase64, mpTicketURL)
+		price, mpPaymentID, mpQRCode, mpQRBase64, mpTicketURL, variantMode,
+		strings.ToUpper(rules.AllowedCountries), strings.ToUpper(rules.BlockedCountries), rules.Hours, strings.ToLower(rules.Days), rules.TZ, notBefore, readTracking,
+		alerts.FirstOpen, alerts.Threshold, alerts.NearLimitPct, title, description, altText, imageWidth, imageHeight, campaignID, fraudExclude, ownerID)
 
 	if errDB != nil {
 		log.Printf("[DB ERR] Falha ao inserir link: %v", errDB)
 		http.Error(w, "Failed to create resource", http.StatusInternalServerError)
 		return
 	}
+	committed = true
+
+	for _, v := range variants {
+		if _, err := database.DB.Exec("INSERT INTO link_variants (link_id, label, file_path, weight) VALUES (?, ?, ?, ?)",
+			id, v.label, v.filePath, v.weight); err != nil {
+			log.Printf("[DB ERR] Falha ao inserir variante %s do link %s: %v", v.label, id, err)
+		}
+	}
 
 	baseURL := os.Getenv("BASE_URL")
 	if baseURL == "" {
@@ -220,7 +352,15 @@ func CheckoutHandler(w http.ResponseWriter, r *http.Request) {
 	pixelUrl := baseURL + "/i/" + id
 	previewUrl := baseURL + "/p/" + id
 
+	if email != "" && digest != "" {
+		if err := services.SubscribeDigest(email, digest); err != nil {
+			log.Printf("[DIGEST ERR] Falha ao inscrever resumo do link %s: %v", id, err)
+		}
+	}
+
 	if email != "" {
+		// O e-mail do checkout é texto livre: o link só entra na conta desse e-mail depois de confirmado
+		confirmURL := linkEmailConfirmation(r, id, email)
 		go func(e, lid, pwd, status, ticketURL string) {
 			subject := "Crom-Vision - Ativo Operante!"
 			body := fmt.Sprintf(`<h2>Gerenciador de Ativos Crom-Vision</h2>
@@ -245,6 +385,10 @@ func CheckoutHandler(w http.ResponseWriter, r *http.Request) {
 					body += `<p>Sua conta Free está ativa, acesse o link do Dashboard acima para acompanhar!</p>`
 				}
 			}
+			if confirmURL != "" {
+				body += fmt.Sprintf(`<hr><p><b>Acompanhar em Meus Links:</b> <a href="%s">confirme este e-mail</a> para vincular o link à sua conta. A confirmação vale por 7 dias.</p>
+				<p style="color:#71717a">Se você não criou este link, ignore este e-mail: ele não será vinculado a você.</p>`, confirmURL)
+			}
 			services.SendEmail(e, subject, body)
 		}(email, id, clearPassword, paymentStatus, mpTicketURL)
 	}
@@ -259,5 +403,79 @@ func CheckoutHandler(w http.ResponseWriter, r *http.Request) {
 		"is_private":     isPrivate,
 		"expires_at":     expiresAt,
 		"temp_password":  clearPassword,
+		"variants":       len(variants),
+		"not_before":     notBefore,
+		"campaign_id":    campaignID.String,
 	})
 }
+
+// parseNotBefore aceita RFC3339 ou o formato de <input type="datetime-local">; este último
+// não tem offset e só é aceito com um fuso IANA (tz), rejeitando horários que não existem
+// ou que se repetem na troca do horário de verão
+func parseNotBefore(raw, tz string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+	const localLayout = "2006-01-02T15:04"
+	if _, err := time.Parse(localLayout, raw); err != nil {
+		return time.Time{}, errors.New("formato desconhecido")
+	}
+	if tz == "" {
+		return time.Time{}, errors.New("horário sem fuso")
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return time.Time{}, errors.New("fuso horário inválido: " + tz)
+	}
+	t, _ := time.ParseInLocation(localLayout, raw, loc)
+	if t.Format(localLayout) != raw {
+		return time.Time{}, errors.New("horário inexistente no fuso " + tz)
+	}
+	for _, d := range []time.Duration{-time.Hour, time.Hour} {
+		if t.Add(d).Format(localLayout) == raw {
+			return time.Time{}, errors.New("horário ambíguo no fuso " + tz)
+		}
+	}
+	return t, nil
+}
+
+// saveUploadedImage valida o tipo real do arquivo (JPG, PNG ou GIF) e o grava em STORAGE_PATH.
+// Retorna o caminho salvo; em caso de erro, status != 0 indica a resposta HTTP a devolver.
+// Falha de escrita em disco apenas loga e devolve caminho vazio (o link segue sem imagem).
+func saveUploadedImage(file multipart.File, header *multipart.FileHeader) (string, int, error) {
+	buffer := make([]byte, 512)
+	if n, err := file.Read(buffer); err == nil && n > 0 {
+		contentType := http.DetectContentType(buffer[:n])
+		if contentType != "image/jpeg" && contentType != "image/png" && contentType != "image/gif" {
+			return "", http.StatusBadRequest, errors.New("Invalid file format. Only JPG, PNG and GIF are allowed.")
+		}
+	} else {
+		return "", http.StatusInternalServerError, errors.New("Failed to inspect file.")
+	}
+
+	file.Seek(0, 0)
+
+	storagePath := os.Getenv("STORAGE_PATH")
+	if storagePath == "" {
+		storagePath = "./storage"
+	}
+
+	ext := ""
+	if header != nil && header.Filename != "" {
+		ext = filepath.Ext(header.Filename)
+	}
+	if ext == "" {
+		ext = ".png"
+	}
+
+	savedFilePath := filepath.Join(storagePath, utils.GenerateRandomString(12)+ext)
+	out, err := os.Create(savedFilePath)
+	if err != nil {
+		log.Printf("[ERR] Falha ao tentar salvar arquivo: %v", err)
+		return "", 0, err
+	}
+	defer out.Close()
+	io.Copy(out, file)
+
+	return savedFilePath, 0, nil
+}
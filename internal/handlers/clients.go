@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"crom-vision/internal/database"
+	"crom-vision/internal/services"
+)
+
+// clientEntry é uma classe de cliente (desktop, mobile, email...) no detalhamento
+type clientEntry struct {
+	Client string `json:"client"`
+	Views  int    `json:"views"`
+	Unique int    `json:"unique"`
+}
+
+// LinkClientsHandler devolve os acessos servidos do link agrupados por classe de cliente.
+// GET /api/link-clients?id=xxx[&password=yyy]
+// Mesmas regras de k-anonimato do /api/link-geo: classes com menos de K_ANON_MIN_GROUP
+// visitantes vão para "Other" e, sem a Senha Mágica, as contagens saem arredondadas.
+// Exige a senha, a sessão do dono ou um share token com o escopo clients.
+func LinkClientsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "ID missing", http.StatusBadRequest)
+		return
+	}
+	if !authorizeStats(w, r, id, scopeClients) {
+		return
+	}
+
+	// O k-anonimato se baseia nos visitantes distintos de cada classe, não nos uniques diários
+	visitors, err := groupVisitors(id, services.VisitorsByClient, clientGroup, time.Time{}, time.Time{})
+	if err != nil {
+		http.Error(w, "DB Error", http.StatusInternalServerError)
+		return
+	}
+	rows, err := database.DB.Query(`
+		SELECT `+clientGroup+` AS c, SUM(views), SUM(uniques) FROM stats_daily
+		WHERE link_id = ? GROUP BY c ORDER BY SUM(views) DESC, c`, id)
+	if err != nil {
+		http.Error(w, "DB Error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	kAnon := loadKAnonymity(isPublicRequest(r))
+	items := []clientEntry{}
+	other := clientEntry{Client: otherLabel}
+	for rows.Next() {
+		var e clientEntry
+		if rows.Scan(&e.Client, &e.Views, &e.Unique) != nil {
+			continue
+		}
+		if kAnon.hides(visitors[e.Client]) {
+			other.Views += e.Views
+			other.Unique += e.Unique
+			continue
+		}
+		e.Views, e.Unique = kAnon.round(e.Views), kAnon.round(e.Unique)
+		items = append(items, e)
+	}
+	if other.Views > 0 {
+		other.Views, other.Unique = kAnon.round(other.Views), kAnon.round(other.Unique)
+		items = append(items, other)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":     id,
+		"items":  items,
+		"k_anon": kAnon,
+	})
+}
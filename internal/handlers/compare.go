@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/url"
+	"time"
+
+	"crom-vision/internal/database"
+	"crom-vision/internal/services"
+)
+
+// Limites da comparação lado a lado
+const (
+	maxCompareLinks     = 10
+	compareTopCountries = 5
+)
+
+// compareCountry é um país no top da comparação
+type compareCountry struct {
+	Country string `json:"country"`
+	Views   int    `json:"views"`
+}
+
+// compareLink é a série e o resumo de um link na comparação
+type compareLink struct {
+	ID              string           `json:"id"`
+	Title           string           `json:"title,omitempty"`
+	Data            []int            `json:"data"`
+	Unique          []int            `json:"unique"`
+	TotalViews      int              `json:"total_views"`
+	UniqueViews     int              `json:"unique_views"`
+	PreviousTotal   int              `json:"previous_total"`
+	PreviousUnique  int              `json:"previous_unique"`
+	GrowthPct       *float64         `json:"growth_pct"`
+	UniqueGrowthPct *float64         `json:"unique_growth_pct"`
+	TopCountries    []compareCountry `json:"top_countries"`
+}
+
+// growthPct compara com o período anterior; nil quando não há base (período anterior zerado)
+func growthPct(current, previous int) *float64 {
+	if previous == 0 {
+		return nil
+	}
+	g := math.Round(float64(current-previous)/float64(previous)*1000) / 10
+	return &g
+}
+
+// sumCounts soma as contagens por minuto/hora de um mapa
+func sumCounts(m map[time.Time]int) int {
+	total := 0
+	for _, n := range m {
+		total += n
+	}
+	return total
+}
+
+// topCountries lê os países com mais views do rollup horário dentro do intervalo.
+// Países abaixo do k-anonimato (visitantes distintos nos logs retidos) são somados em "Other", depois do top-N.
+func topCountries(linkID string, sr statsRange, limit int) ([]compareCountry, error) {
+	visitors, err := groupVisitors(linkID, services.VisitorsByCountry, countryGroup, sr.From, sr.To)
+	if err != nil {
+		return nil, err
+	}
+	from, to := sr.utcBounds()
+	rows, err := database.DB.Query(`
+		SELECT `+countryGroup+` AS c, SUM(views) FROM stats_hourly
+		WHERE link_id = ? AND bucket >= ? AND bucket < ?
+		GROUP BY c ORDER BY SUM(views) DESC, c`, linkID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	kAnon := loadKAnonymity(false)
+	countries := []compareCountry{}
+	other := 0
+	for rows.Next() {
+		var c compareCountry
+		if rows.Scan(&c.Country, &c.Views) != nil {
+			continue
+		}
+		// Antes da retenção o país traz os visitantes do histórico inteiro; no período
+		// ele não pode ter mais visitantes do que views
+		if kAnon.hides(min(visitors[c.Country], c.Views)) {
+			other += c.Views
+		} else if len(countries) < limit {
+			countries = append(countries, c)
+		}
+	}
+	if other > 0 {
+		countries = append(countries, compareCountry{Country: otherLabel, Views: other})
+	}
+	return countries, rows.Err()
+}
+
+// CompareHandler devolve séries alinhadas e resumos de vários links para o mesmo período.
+// POST /api/compare {"links":[{"id":"c_x","password":"..."},...], "period":"7d" | "from":"...","to":"...", "granularity":"day", "tz":"America/Sao_Paulo"}
+// Cada link exige a própria Senha Mágica. O crescimento compara com o período imediatamente anterior de mesma duração.
+func CompareHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Links []struct {
+			ID       string `json:"id"`
+			Password string `json:"password"`
+		} `json:"links"`
+		Period      string `json:"period"`
+		From        string `json:"from"`
+		To          string `json:"to"`
+		Granularity string `json:"granularity"`
+		TZ          string `json:"tz"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid Payload", http.StatusBadRequest)
+		return
+	}
+	if len(req.Links) < 2 || len(req.Links) > maxCompareLinks {
+		http.Error(w, "Informe de 2 a 10 links", http.StatusBadRequest)
+		return
+	}
+	seen := make(map[string]bool)
+	for _, l := range req.Links {
+		if l.ID == "" || seen[l.ID] {
+			http.Error(w, "IDs vazios ou repetidos", http.StatusBadRequest)
+			return
+		}
+		seen[l.ID] = true
+		if !ownerAuthorized(r, l.ID, l.Password) {
+			http.Error(w, "Unauthorized (Invalid Password): "+l.ID, http.StatusUnauthorized)
+			return
+		}
+	}
+
+	// O mesmo intervalo para todos; period=all começa no link mais antigo
+	q := url.Values{}
+	for k, v := range map[string]string{"period": req.Period, "from": req.From, "to": req.To, "granularity": req.Granularity, "tz": req.TZ} {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	rangeLink := req.Links[0].ID
+	var oldest time.Time
+	for _, l := range req.Links {
+		var created time.Time
+		if database.DB.QueryRow("SELECT created_at FROM links WHERE id = ?", l.ID).Scan(&created) == nil && (oldest.IsZero() || created.Before(oldest)) {
+			oldest, rangeLink = created, l.ID
+		}
+	}
+	sr, err := parseStatsRange(q, rangeLink, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	starts, err := sr.buckets()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(starts) > 0 {
+		sr.From = starts[0]
+	}
+	prev := sr
+	prev.From, prev.To = sr.From.Add(-sr.To.Sub(sr.From)), sr.From
+
+	labels := make([]string, len(starts))
+	for i, t := range starts {
+		labels[i] = sr.label(t)
+	}
+
+	links := make([]compareLink, 0, len(req.Links))
+	for _, l := range req.Links {
+		cur, err := loadVisitCounts(l.ID, sr)
+		if err != nil {
+			http.Error(w, "DB Error", http.StatusInternalServerError)
+			return
+		}
+		before, err := loadVisitCounts(l.ID, prev)
+		if err != nil {
+			http.Error(w, "DB Error", http.StatusInternalServerError)
+			return
+		}
+		countries, err := topCountries(l.ID, sr, compareTopCountries)
+		if err != nil {
+			http.Error(w, "DB Error", http.StatusInternalServerError)
+			return
+		}
+		var title sql.NullString
+		database.DB.QueryRow("SELECT title FROM links WHERE id = ?", l.ID).Scan(&title)
+
+		cl := compareLink{
+			ID:             l.ID,
+			Title:          title.String,
+			Data:           sr.series(starts, cur.Total),
+			Unique:         sr.series(starts, cur.Unique),
+			PreviousTotal:  sumCounts(before.Total),
+			PreviousUnique: sumCounts(before.Unique),
+			TopCountries:   countries,
+		}
+		for i := range cl.Data {
+			cl.TotalViews += cl.Data[i]
+			cl.UniqueViews += cl.Unique[i]
+		}
+		cl.GrowthPct = growthPct(cl.TotalViews, cl.PreviousTotal)
+		cl.UniqueGrowthPct = growthPct(cl.UniqueViews, cl.PreviousUnique)
+		links = append(links, cl)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"labels":        labels,
+		"links":         links,
+		"period":        sr.Period,
+		"granularity":   sr.Granularity,
+		"tz":            sr.Loc.String(),
+		"from":          sr.From.Format(time.RFC3339),
+		"to":            sr.To.Format(time.RFC3339),
+		"previous_from": prev.From.Format(time.RFC3339),
+	})
+}
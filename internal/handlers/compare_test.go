@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"crom-vision/internal/database"
+)
+
+func postCompare(t *testing.T, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/compare", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	CompareHandler(w, req)
+	return w
+}
+
+func TestCompareHandler_AlignedSeriesAndGrowth(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	t.Setenv("K_ANON_MIN_GROUP", "1")
+	insertLivePasswordLink(t, "cmpA")
+	insertLivePasswordLink(t, "cmpB")
+	database.DB.Exec("UPDATE links SET title = 'Criativo A' WHERE id = 'cmpA'")
+	for _, h := range []struct{ link, ip, country, at string }{
+		{"cmpA", "a1", "BR", "2025-03-03 10:00:00"}, // período anterior
+		{"cmpA", "a1", "BR", "2025-03-10 10:00:00"},
+		{"cmpA", "a2", "US", "2025-03-11 10:00:00"},
+		{"cmpA", "a2", "US", "2025-03-11 10:01:00"}, // F5
+		{"cmpB", "b1", "PT", "2025-03-12 10:00:00"},
+	} {
+		database.DB.Exec("INSERT INTO access_logs (link_id, ip_hash, country, accessed_at) VALUES (?, ?, ?, ?)", h.link, h.ip, h.country, h.at)
+	}
+	rebuildRollups(t)
+
+	w := postCompare(t, `{"links":[{"id":"cmpA","password":"123"},{"id":"cmpB","password":"123"}],
+		"from":"2025-03-10","to":"2025-03-16","granularity":"day","tz":"UTC"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("esperava 200, obteve %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Labels []string      `json:"labels"`
+		Links  []compareLink `json:"links"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if len(resp.Labels) != 7 || len(resp.Links) != 2 {
+		t.Fatalf("resposta inesperada: %s", w.Body.String())
+	}
+	a, b := resp.Links[0], resp.Links[1]
+	if len(a.Data) != 7 || len(b.Data) != 7 {
+		t.Fatal("séries deveriam ter o mesmo tamanho dos labels")
+	}
+	if a.Title != "Criativo A" || a.TotalViews != 3 || a.UniqueViews != 2 || a.Data[1] != 2 || a.Unique[1] != 1 {
+		t.Errorf("link A inesperado: %+v", a)
+	}
+	if a.PreviousTotal != 1 || a.GrowthPct == nil || *a.GrowthPct != 200 {
+		t.Errorf("crescimento de A deveria ser 200%% sobre 1 view anterior: %+v", a)
+	}
+	if len(a.TopCountries) != 2 || a.TopCountries[0].Country != "US" || a.TopCountries[0].Views != 2 {
+		t.Errorf("top países de A inesperado: %+v", a.TopCountries)
+	}
+	if b.TotalViews != 1 || b.GrowthPct != nil || b.Data[2] != 1 {
+		t.Errorf("link B sem base anterior deveria ter growth_pct nulo: %+v", b)
+	}
+}
+
+func TestCompareHandler_Validation(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	insertLivePasswordLink(t, "cmpA")
+	insertLivePasswordLink(t, "cmpB")
+
+	cases := map[string]int{
+		`{"links":[{"id":"cmpA","password":"123"}]}`:                                              http.StatusBadRequest,
+		`{"links":[{"id":"cmpA","password":"123"},{"id":"cmpA","password":"123"}]}`:               http.StatusBadRequest,
+		`{"links":[{"id":"cmpA","password":"123"},{"id":"cmpB","password":"errada"}]}`:            http.StatusUnauthorized,
+		`{"links":[{"id":"cmpA","password":"123"},{"id":"cmpB","password":"123"}],"period":"2y"}`: http.StatusBadRequest,
+		`nada`: http.StatusBadRequest,
+	}
+	for body, want := range cases {
+		if w := postCompare(t, body); w.Code != want {
+			t.Errorf("%s: esperava %d, obteve %d", body, want, w.Code)
+		}
+	}
+}
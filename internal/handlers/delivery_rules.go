@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"crom-vision/internal/utils"
+)
+
+// deliveryRules agrupa as regras de entrega de um link: cerca geográfica
+// (países permitidos/bloqueados) e janela de horários/dias num fuso escolhido.
+// Campos vazios significam "sem restrição".
+type deliveryRules struct {
+	AllowedCountries string // ex: "BR,PT"
+	BlockedCountries string // ex: "US"
+	Hours            string // ex: "9-18" ou "8-12,14-18" (hora final exclusiva, aceita virada "22-6")
+	Days             string // ex: "mon-fri" ou "sat,sun"
+	TZ               string // ex: "America/Sao_Paulo" (padrão UTC)
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+	"dom": time.Sunday, "seg": time.Monday, "ter": time.Tuesday, "qua": time.Wednesday,
+	"qui": time.Thursday, "sex": time.Friday, "sab": time.Saturday,
+}
+
+// hasGeoRules indica se a avaliação precisa do país do visitante
+func (dr deliveryRules) hasGeoRules() bool {
+	return strings.TrimSpace(dr.AllowedCountries) != "" || strings.TrimSpace(dr.BlockedCountries) != ""
+}
+
+// validate confere a sintaxe das regras antes de gravá-las no link
+func (dr deliveryRules) validate() error {
+	if _, err := dr.location(); err != nil {
+		return fmt.Errorf("fuso horário inválido: %s", dr.TZ)
+	}
+	if _, err := parseHourRanges(dr.Hours); err != nil {
+		return err
+	}
+	if _, err := parseWeekdays(dr.Days); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (dr deliveryRules) location() (*time.Location, error) {
+	if strings.TrimSpace(dr.TZ) == "" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(strings.TrimSpace(dr.TZ))
+}
+
+// evaluate devolve "" quando a entrega é permitida, ou o X-Crom-Status do bloqueio
+func (dr deliveryRules) evaluate(country string, now time.Time) string {
+	country = strings.ToUpper(strings.TrimSpace(country))
+
+	if list := countryList(dr.BlockedCountries); len(list) > 0 && list[country] {
+		return "Geo-Blocked"
+	}
+	if list := countryList(dr.AllowedCountries); len(list) > 0 && !list[country] {
+		return "Geo-Blocked"
+	}
+
+	loc, err := dr.location()
+	if err != nil {
+		loc = time.UTC
+	}
+	local := now.In(loc)
+
+	if days, err := parseWeekdays(dr.Days); err == nil && len(days) > 0 && !days[local.Weekday()] {
+		return "Outside-Window"
+	}
+	if ranges, err := parseHourRanges(dr.Hours); err == nil && len(ranges) > 0 {
+		inside := false
+		for _, hr := range ranges {
+			if hr.contains(local.Hour()) {
+				inside = true
+				break
+			}
+		}
+		if !inside {
+			return "Outside-Window"
+		}
+	}
+	return ""
+}
+
+func countryList(raw string) map[string]bool {
+	set := map[string]bool{}
+	for _, c := range strings.Split(raw, ",") {
+		if c = strings.ToUpper(strings.TrimSpace(c)); c != "" {
+			set[c] = true
+		}
+	}
+	return set
+}
+
+type hourRange struct{ start, end int }
+
+func (hr hourRange) contains(h int) bool {
+	if hr.start < hr.end {
+		return h >= hr.start && h < hr.end
+	}
+	// Janela que atravessa a meia-noite (ex: 22-6)
+	return h >= hr.start || h < hr.end
+}
+
+func parseHourRanges(raw string) ([]hourRange, error) {
+	var ranges []hourRange
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		bounds := strings.SplitN(part, "-", 2)
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("janela de horas inválida: %s", part)
+		}
+		start, err1 := strconv.Atoi(strings.TrimSpace(bounds[0]))
+		end, err2 := strconv.Atoi(strings.TrimSpace(bounds[1]))
+		if err1 != nil || err2 != nil || start < 0 || start > 23 || end < 0 || end > 24 || start == end {
+			return nil, fmt.Errorf("janela de horas inválida: %s", part)
+		}
+		ranges = append(ranges, hourRange{start: start, end: end})
+	}
+	return ranges, nil
+}
+
+func parseWeekdays(raw string) (map[time.Weekday]bool, error) {
+	days := map[time.Weekday]bool{}
+	for _, part := range strings.Split(strings.ToLower(raw), ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		bounds := strings.SplitN(part, "-", 2)
+		first, ok := weekdayNames[strings.TrimSpace(bounds[0])]
+		if !ok {
+			return nil, fmt.Errorf("dia da semana inválido: %s", part)
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, ok = weekdayNames[strings.TrimSpace(bounds[1])]; !ok {
+				return nil, fmt.Errorf("dia da semana inválido: %s", part)
+			}
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+// serveFallback responde com a imagem substituta (FALLBACK_IMAGE_PATH) ou, na
+// ausência dela, com o GIF transparente, sinalizando o motivo em X-Crom-Status
+func serveFallback(w http.ResponseWriter, r *http.Request, status string) {
+	if status != "" {
+		w.Header().Set("X-Crom-Status", status)
+	}
+	if fallback := os.Getenv("FALLBACK_IMAGE_PATH"); fallback != "" {
+		if _, err := os.Stat(fallback); err == nil {
+			w.Header().Del("Content-Type")
+			http.ServeFile(w, r, fallback)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "image/gif")
+	w.Write(utils.TransparentGif)
+}
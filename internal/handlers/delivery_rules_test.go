@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"crom-vision/internal/database"
+)
+
+func TestDeliveryRules_Evaluate(t *testing.T) {
+	// Quarta-feira, 15h UTC = 12h em São Paulo
+	wed := time.Date(2025, 1, 15, 15, 0, 0, 0, time.UTC)
+	sat := time.Date(2025, 1, 18, 15, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		rules   deliveryRules
+		country string
+		now     time.Time
+		want    string
+	}{
+		{"sem regras", deliveryRules{}, "US", wed, ""},
+		{"país permitido", deliveryRules{AllowedCountries: "BR,PT"}, "BR", wed, ""},
+		{"país fora da allowlist", deliveryRules{AllowedCountries: "BR,PT"}, "US", wed, "Geo-Blocked"},
+		{"país desconhecido com allowlist", deliveryRules{AllowedCountries: "br"}, "??", wed, "Geo-Blocked"},
+		{"país bloqueado", deliveryRules{BlockedCountries: "US"}, "us", wed, "Geo-Blocked"},
+		{"dentro do horário local", deliveryRules{Hours: "9-18", TZ: "America/Sao_Paulo"}, "BR", wed, ""},
+		{"fora do horário local", deliveryRules{Hours: "13-18", TZ: "America/Sao_Paulo"}, "BR", wed, "Outside-Window"},
+		{"janela virando a meia-noite", deliveryRules{Hours: "22-6"}, "BR", time.Date(2025, 1, 15, 2, 0, 0, 0, time.UTC), ""},
+		{"dia útil permitido", deliveryRules{Days: "mon-fri"}, "BR", wed, ""},
+		{"fim de semana bloqueado", deliveryRules{Days: "mon-fri"}, "BR", sat, "Outside-Window"},
+		{"dias em português", deliveryRules{Days: "sab,dom"}, "BR", sat, ""},
+		{"dia inteiro", deliveryRules{Hours: "0-24"}, "BR", wed, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rules.evaluate(tt.country, tt.now); got != tt.want {
+				t.Errorf("esperava %q, obteve %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestDeliveryRules_Validate(t *testing.T) {
+	invalid := []deliveryRules{
+		{TZ: "Marte/Olympus"},
+		{Hours: "25-3"},
+		{Hours: "9"},
+		{Days: "funday"},
+	}
+	for _, dr := range invalid {
+		if err := dr.validate(); err == nil {
+			t.Errorf("regras %+v deveriam ser inválidas", dr)
+		}
+	}
+	if err := (deliveryRules{Hours: "8-12,14-18", Days: "mon-fri", TZ: "America/Sao_Paulo"}).validate(); err != nil {
+		t.Errorf("regras válidas rejeitadas: %v", err)
+	}
+}
+
+func TestImageHandler_GeoBlockedServesFallback(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+
+	fallback := filepath.Join(t.TempDir(), "fallback.png")
+	os.WriteFile(fallback, []byte("fallback-image"), 0644)
+	os.Setenv("FALLBACK_IMAGE_PATH", fallback)
+	defer os.Unsetenv("FALLBACK_IMAGE_PATH")
+
+	tmpFile := filepath.Join(t.TempDir(), "real.png")
+	os.WriteFile(tmpFile, []byte("real-image"), 0644)
+	insertTestLink(t, "test_geo", "", "approved", tmpFile, 0, 0, false)
+	// Placeholder GeoIP: IP terminando em 5 → US
+	database.DB.Exec("UPDATE links SET allowed_countries = 'BR' WHERE id = 'test_geo'")
+
+	req := httptest.NewRequest(http.MethodGet, "/i/test_geo", nil)
+	req.RemoteAddr = "10.0.0.5"
+	w := httptest.NewRecorder()
+	ImageHandler(w, req)
+
+	if w.Header().Get("X-Crom-Status") != "Geo-Blocked" {
+		t.Errorf("esperava X-Crom-Status=Geo-Blocked, obteve %q", w.Header().Get("X-Crom-Status"))
+	}
+	body, _ := io.ReadAll(w.Result().Body)
+	if string(body) != "fallback-image" {
+		t.Errorf("esperava a imagem substituta, obteve %q", string(body))
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	var status string
+	var totalViews int
+	database.DB.QueryRow("SELECT status FROM access_logs WHERE link_id = 'test_geo'").Scan(&status)
+	database.DB.QueryRow("SELECT total_views FROM links WHERE id = 'test_geo'").Scan(&totalViews)
+	if status != "geo_blocked" {
+		t.Errorf("tentativa bloqueada deveria ser registrada como geo_blocked, obteve %q", status)
+	}
+	if totalViews != 0 {
+		t.Errorf("tentativa bloqueada não deve consumir views, total_views=%d", totalViews)
+	}
+}
+
+func TestImageHandler_OutsideWindow(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+
+	insertTestLink(t, "test_window", "https://crom.run", "approved", "", 0, 0, false)
+	// Janela de uma hora que nunca contém a hora atual
+	hour := (time.Now().UTC().Hour() + 2) % 24
+	database.DB.Exec("UPDATE links SET delivery_hours = ?, delivery_tz = 'UTC' WHERE id = 'test_window'",
+		time.Date(2025, 1, 1, hour, 0, 0, 0, time.UTC).Format("15")+"-"+time.Date(2025, 1, 1, (hour+1)%24, 0, 0, 0, time.UTC).Format("15"))
+
+	req := httptest.NewRequest(http.MethodGet, "/i/test_window", nil)
+	w := httptest.NewRecorder()
+	ImageHandler(w, req)
+
+	if w.Code == http.StatusFound {
+		t.Error("fora da janela não deve redirecionar")
+	}
+	if w.Header().Get("X-Crom-Status") != "Outside-Window" {
+		t.Errorf("esperava X-Crom-Status=Outside-Window, obteve %q", w.Header().Get("X-Crom-Status"))
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "image/gif" {
+		t.Errorf("sem FALLBACK_IMAGE_PATH deve servir o GIF transparente, Content-Type=%s", ct)
+	}
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+
+	"crom-vision/internal/database"
+	"crom-vision/internal/services"
+)
+
+// DigestHandler ativa, altera ou desativa o resumo periódico do e-mail dono do link.
+// POST /api/digest {"id","password","frequency":"daily|weekly|off"}
+func DigestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ID        string `json:"id"`
+		Password  string `json:"password"`
+		Frequency string `json:"frequency"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid Payload", http.StatusBadRequest)
+		return
+	}
+	if !ownerAuthorized(r, req.ID, req.Password) {
+		http.Error(w, "Unauthorized (Invalid Password)", http.StatusUnauthorized)
+		return
+	}
+
+	var email sql.NullString
+	database.DB.QueryRow("SELECT email FROM links WHERE id = ?", req.ID).Scan(&email)
+	if !email.Valid || email.String == "" {
+		http.Error(w, "Link sem e-mail cadastrado para receber o resumo", http.StatusBadRequest)
+		return
+	}
+
+	if req.Frequency == "off" {
+		services.UnsubscribeDigest(email.String)
+	} else if err := services.SubscribeDigest(email.String, req.Frequency); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok", "frequency": req.Frequency})
+}
+
+// DigestUnsubscribeHandler atende o link assinado presente no rodapé do resumo.
+// GET /api/digest/unsubscribe?email=xxx&token=yyy
+func DigestUnsubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	email := r.URL.Query().Get("email")
+	if !services.VerifyDigestUnsubscribe(email, r.URL.Query().Get("token")) {
+		http.Error(w, "Link de descadastro inválido", http.StatusForbidden)
+		return
+	}
+	services.UnsubscribeDigest(email)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, `<!DOCTYPE html><html><head><meta charset="utf-8"><title>Resumo desativado</title></head>
+<body style="font-family:sans-serif;text-align:center;padding:40px">
+<h2>Resumo desativado</h2>
+<p>O e-mail <b>%s</b> não receberá mais o resumo periódico do Crom-Vision.</p>
+</body></html>`, html.EscapeString(email))
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"crom-vision/internal/database"
+	"crom-vision/internal/services"
+)
+
+func TestDigestHandler_SubscribeAndUnsubscribe(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	insertLivePasswordLink(t, "dgh1")
+	database.DB.Exec("UPDATE links SET email = 'dono@test.com' WHERE id = 'dgh1'")
+
+	w := httptest.NewRecorder()
+	DigestHandler(w, httptest.NewRequest(http.MethodPost, "/api/digest",
+		strings.NewReader(`{"id":"dgh1","password":"123","frequency":"weekly"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("esperava 200, obteve %d: %s", w.Code, w.Body.String())
+	}
+	var freq string
+	database.DB.QueryRow("SELECT frequency FROM digest_subscriptions WHERE email = 'dono@test.com'").Scan(&freq)
+	if freq != "weekly" {
+		t.Fatalf("inscrição não registrada: %q", freq)
+	}
+
+	// Token forjado é recusado
+	w = httptest.NewRecorder()
+	DigestUnsubscribeHandler(w, httptest.NewRequest(http.MethodGet, "/api/digest/unsubscribe?email=dono%40test.com&token=abc", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("esperava 403 com token inválido, obteve %d", w.Code)
+	}
+
+	q := url.Values{"email": {"dono@test.com"}, "token": {services.DigestUnsubscribeToken("dono@test.com")}}
+	w = httptest.NewRecorder()
+	DigestUnsubscribeHandler(w, httptest.NewRequest(http.MethodGet, "/api/digest/unsubscribe?"+q.Encode(), nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("esperava 200, obteve %d", w.Code)
+	}
+	var count int
+	database.DB.QueryRow("SELECT COUNT(*) FROM digest_subscriptions").Scan(&count)
+	if count != 0 {
+		t.Error("inscrição deveria ter sido removida")
+	}
+}
+
+func TestDigestHandler_InvalidFrequency(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	insertLivePasswordLink(t, "dgh2")
+	database.DB.Exec("UPDATE links SET email = 'dono@test.com' WHERE id = 'dgh2'")
+
+	w := httptest.NewRecorder()
+	DigestHandler(w, httptest.NewRequest(http.MethodPost, "/api/digest",
+		strings.NewReader(`{"id":"dgh2","password":"123","frequency":"hourly"}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("esperava 400, obteve %d", w.Code)
+	}
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"crom-vision/internal/database"
+	"crom-vision/internal/utils"
+)
+
+// Faixas de tempo de leitura (mesma convenção usada por ferramentas de e-mail marketing)
+const (
+	glancedBelowSeconds = 2 // < 2s: bateu o olho
+	readFromSeconds     = 8 // >= 8s: leu; entre os dois: passou os olhos
+)
+
+// activeDripStreams conta as conexões de slow-drip abertas em todo o servidor
+var activeDripStreams int64
+
+// serveDripPixel transmite um GIF animado 1x1, um quadro por segundo, até o cliente
+// fechar a conexão ou DRIP_MAX_SECONDS se esgotar. O tempo em que a conexão ficou
+// aberta aproxima quanto tempo o e-mail ficou na tela.
+// Retorna false (sem escrever nada) quando o limite global DRIP_MAX_STREAMS foi atingido,
+// para que o chamador sirva a resposta comum.
+func serveDripPixel(w http.ResponseWriter, r *http.Request, linkID, fingerprintHash string) bool {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return false
+	}
+
+	maxStreams := int64(utils.EnvInt("DRIP_MAX_STREAMS", 200))
+	if atomic.AddInt64(&activeDripStreams, 1) > maxStreams {
+		atomic.AddInt64(&activeDripStreams, -1)
+		return false
+	}
+	defer atomic.AddInt64(&activeDripStreams, -1)
+
+	maxSeconds := utils.EnvInt("DRIP_MAX_SECONDS", 30)
+	if maxSeconds <= 0 {
+		maxSeconds = 30
+	}
+
+	w.Header().Set("Content-Type", "image/gif")
+	w.Header().Set("X-Crom-Drip", "1")
+	w.WriteHeader(http.StatusOK)
+
+	start := time.Now()
+	w.Write(utils.DripGifHeader)
+	w.Write(utils.DripGifFrame)
+	flusher.Flush()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+stream:
+	for i := 1; i < maxSeconds; i++ {
+		select {
+		case <-r.Context().Done():
+			break stream
+		case <-ticker.C:
+			if _, err := w.Write(utils.DripGifFrame); err != nil {
+				break stream
+			}
+			flusher.Flush()
+		}
+	}
+	w.Write(utils.GifTrailer)
+	flusher.Flush()
+
+	duration := time.Since(start)
+	if max := time.Duration(maxSeconds) * time.Second; duration > max {
+		duration = max
+	}
+	go func(lid, fHash string, ms int64) {
+		if _, err := database.DB.Exec("INSERT INTO read_sessions (link_id, ip_hash, duration_ms) VALUES (?, ?, ?)", lid, fHash, ms); err != nil {
+			log.Printf("[DRIP ERR] Falha ao registrar tempo de leitura do link %s: %v", lid, err)
+		}
+	}(linkID, fingerprintHash, duration.Milliseconds())
+
+	return true
+}
+
+// LinkReadTimeHandler agrupa as sessões de slow-drip em glanced/skimmed/read
+// GET /api/link-read-time?id=xxx[&password=yyy]
+// Exige a senha, a sessão do dono ou um share token com o escopo read_time.
+func LinkReadTimeHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "ID missing", http.StatusBadRequest)
+		return
+	}
+	if !authorizeStats(w, r, id, scopeReadTime) {
+		return
+	}
+
+	var sessions, glanced, skimmed, read int
+	var avgMs float64
+	err := database.DB.QueryRow(`
+		SELECT COUNT(*),
+			COALESCE(SUM(CASE WHEN duration_ms < ? THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN duration_ms >= ? AND duration_ms < ? THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN duration_ms >= ? THEN 1 ELSE 0 END), 0),
+			COALESCE(AVG(duration_ms), 0)
+		FROM read_sessions WHERE link_id = ?`,
+		glancedBelowSeconds*1000, glancedBelowSeconds*1000, readFromSeconds*1000, readFromSeconds*1000, id).
+		Scan(&sessions, &glanced, &skimmed, &read, &avgMs)
+	if err != nil {
+		http.Error(w, "DB Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":          id,
+		"sessions":    sessions,
+		"avg_seconds": avgMs / 1000,
+		"buckets": map[string]int{
+			"glanced": glanced,
+			"skimmed": skimmed,
+			"read":    read,
+		},
+	})
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"crom-vision/internal/database"
+)
+
+func TestImageHandler_DripStreamsUntilDisconnect(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	os.Setenv("DRIP_MAX_SECONDS", "10")
+	defer os.Unsetenv("DRIP_MAX_SECONDS")
+
+	insertTestLink(t, "test_drip", "", "approved", "", 0, 0, false)
+	database.DB.Exec("UPDATE links SET read_tracking = 1 WHERE id = 'test_drip'")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, "/i/test_drip", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	go func() {
+		time.Sleep(1500 * time.Millisecond)
+		cancel()
+	}()
+	start := time.Now()
+	ImageHandler(w, req)
+	elapsed := time.Since(start)
+
+	if elapsed < time.Second || elapsed > 3*time.Second {
+		t.Errorf("stream deveria durar até o cliente desconectar (~1.5s), durou %v", elapsed)
+	}
+	if w.Header().Get("X-Crom-Drip") != "1" {
+		t.Error("esperava header X-Crom-Drip=1")
+	}
+	body := w.Body.Bytes()
+	if string(body[:6]) != "GIF89a" || body[len(body)-1] != 0x3B {
+		t.Error("stream deve ser um GIF completo (header + trailer)")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	var ms int64
+	database.DB.QueryRow("SELECT duration_ms FROM read_sessions WHERE link_id = 'test_drip'").Scan(&ms)
+	if ms < 1000 || ms > 3000 {
+		t.Errorf("duração registrada inesperada: %dms", ms)
+	}
+}
+
+func TestImageHandler_DripRespectsGlobalCap(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	os.Setenv("DRIP_MAX_STREAMS", "1")
+	defer os.Unsetenv("DRIP_MAX_STREAMS")
+
+	insertTestLink(t, "test_drip_cap", "https://crom.run", "approved", "", 0, 0, false)
+	database.DB.Exec("UPDATE links SET read_tracking = 1 WHERE id = 'test_drip_cap'")
+
+	// Simula um stream já ocupando a única vaga
+	atomic.AddInt64(&activeDripStreams, 1)
+	defer atomic.AddInt64(&activeDripStreams, -1)
+
+	req := httptest.NewRequest(http.MethodGet, "/i/test_drip_cap", nil)
+	w := httptest.NewRecorder()
+	ImageHandler(w, req)
+
+	if w.Header().Get("X-Crom-Drip") != "" {
+		t.Error("com o limite atingido não deve abrir novo stream")
+	}
+	if w.Code != http.StatusFound {
+		t.Errorf("sem vaga, deve servir a resposta comum (302), obteve %d", w.Code)
+	}
+}
+
+func TestLinkReadTimeHandler_Buckets(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	insertLivePasswordLink(t, "rt1")
+
+	for _, ms := range []int{500, 1500, 3000, 7000, 9000, 30000} {
+		database.DB.Exec("INSERT INTO read_sessions (link_id, ip_hash, duration_ms) VALUES ('rt1', 'h', ?)", ms)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/link-read-time?id=rt1&password=123", nil)
+	w := httptest.NewRecorder()
+	LinkReadTimeHandler(w, req)
+
+	var resp struct {
+		Sessions int            `json:"sessions"`
+		Buckets  map[string]int `json:"buckets"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+
+	if resp.Sessions != 6 {
+		t.Errorf("esperava 6 sessões, obteve %d", resp.Sessions)
+	}
+	if resp.Buckets["glanced"] != 2 || resp.Buckets["skimmed"] != 2 || resp.Buckets["read"] != 2 {
+		t.Errorf("buckets incorretos: %v", resp.Buckets)
+	}
+}
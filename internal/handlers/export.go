@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"crom-vision/internal/database"
+)
+
+// exportColumns são as colunas de access_logs liberadas na exportação, na ordem padrão
+var exportColumns = []string{
+	"id", "link_id", "accessed_at", "status", "ip_hash", "user_agent",
+	"country", "region", "city", "client_class", "is_unique", "variant_id",
+}
+
+// exportFlushEvery define de quantas em quantas linhas o stream é enviado ao cliente
+const exportFlushEvery = 500
+
+// exportContentTypes mapeia ?format= para o Content-Type da resposta
+var exportContentTypes = map[string]string{
+	"csv":    "text/csv; charset=utf-8",
+	"json":   "application/json",
+	"ndjson": "application/x-ndjson",
+}
+
+// parseExportColumns valida ?columns=a,b,c contra exportColumns (vazio = todas)
+func parseExportColumns(raw string) ([]string, error) {
+	if strings.TrimSpace(raw) == "" {
+		return exportColumns, nil
+	}
+	allowed := make(map[string]bool, len(exportColumns))
+	for _, c := range exportColumns {
+		allowed[c] = true
+	}
+	var cols []string
+	seen := make(map[string]bool)
+	for _, c := range strings.Split(raw, ",") {
+		c = strings.ToLower(strings.TrimSpace(c))
+		if !allowed[c] {
+			return nil, fmt.Errorf("coluna inválida: %q (use %s)", c, strings.Join(exportColumns, ", "))
+		}
+		if !seen[c] {
+			seen[c] = true
+			cols = append(cols, c)
+		}
+	}
+	return cols, nil
+}
+
+// exportValue normaliza o valor lido do SQLite para CSV/JSON (datas em RFC3339 UTC)
+func exportValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case []byte:
+		return string(x)
+	case time.Time:
+		return x.UTC().Format(time.RFC3339)
+	}
+	return v
+}
+
+// ExportHandler exporta os acessos brutos de um link ou de uma campanha, em streaming.
+// GET /api/export?id=xxx|campaign=cmp_xxx&password=yyy[&format=csv|json|ndjson][&from=...&to=...][&tz=...][&columns=accessed_at,country]
+// A senha também pode vir no header X-Crom-Password. from/to aceitam os mesmos formatos de /api/link-stats.
+func ExportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	id, password := credentialFromQuery(r)
+	campaign := q.Get("campaign")
+	if (id == "") == (campaign == "") {
+		http.Error(w, "Informe id ou campaign", http.StatusBadRequest)
+		return
+	}
+	if id != "" && !ownerAuthorized(r, id, password) || campaign != "" && !campaignPasswordMatches(campaign, password) {
+		http.Error(w, "Unauthorized (Invalid Password)", http.StatusUnauthorized)
+		return
+	}
+
+	format := q.Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	contentType, ok := exportContentTypes[format]
+	if !ok {
+		http.Error(w, "Invalid format (csv, json, ndjson)", http.StatusBadRequest)
+		return
+	}
+	cols, err := parseExportColumns(q.Get("columns"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	where := "link_id = ?"
+	args := []interface{}{id}
+	if campaign != "" {
+		where = "link_id IN (SELECT id FROM links WHERE campaign_id = ?)"
+		args = []interface{}{campaign}
+	}
+	loc, err := loadStatsLocation(q.Get("tz"))
+	if err != nil {
+		http.Error(w, "tz inválido", http.StatusBadRequest)
+		return
+	}
+	if raw := q.Get("from"); raw != "" {
+		from, err := parseStatsTime(raw, loc)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		where += " AND accessed_at >= ?"
+		args = append(args, from.UTC().Format(sqliteTimeFormat))
+	}
+	if raw := q.Get("to"); raw != "" {
+		to, err := parseStatsTime(raw, loc)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// "to" só com data inclui o dia inteiro
+		if len(raw) == len("2006-01-02") {
+			to = to.AddDate(0, 0, 1)
+		}
+		where += " AND accessed_at < ?"
+		args = append(args, to.UTC().Format(sqliteTimeFormat))
+	}
+
+	rows, err := database.DB.Query("SELECT "+strings.Join(cols, ", ")+" FROM access_logs WHERE "+where+" ORDER BY id ASC", args...)
+	if err != nil {
+		http.Error(w, "DB Error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	subject := id
+	if campaign != "" {
+		subject = campaign
+	}
+	filename := fmt.Sprintf("crom-%s-access-logs-%s.%s", subject, time.Now().UTC().Format("20060102"), format)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	w.Header().Set("Cache-Control", "no-store")
+	// O status só é conhecido no fim do streaming: "complete" ou "incomplete"
+	w.Header().Set("Trailer", "X-Crom-Export-Status")
+
+	n, err := streamExport(w, rows, format, cols)
+	if err != nil {
+		log.Printf("[EXPORT ERR] Exportação de %s interrompida após %d linhas: %v", subject, n, err)
+		w.Header().Set("X-Crom-Export-Status", "incomplete")
+		return
+	}
+	w.Header().Set("X-Crom-Export-Status", "complete")
+}
+
+// exportRows é o subconjunto de *sql.Rows usado no streaming
+type exportRows interface {
+	Next() bool
+	Scan(dest ...interface{}) error
+	Err() error
+}
+
+// streamExport escreve as linhas no formato pedido, enviando-as aos poucos. Numa falha
+// de leitura no meio do caminho o arquivo não pode parecer completo: o JSON fica sem o
+// "]" final (inválido de propósito) e CSV e NDJSON terminam com uma linha de erro.
+func streamExport(w http.ResponseWriter, rows exportRows, format string, cols []string) (int, error) {
+	flusher, _ := w.(http.Flusher)
+	csvWriter := csv.NewWriter(w)
+	enc := json.NewEncoder(w)
+	switch format {
+	case "csv":
+		csvWriter.Write(cols)
+	case "json":
+		w.Write([]byte("["))
+	}
+
+	values := make([]interface{}, len(cols))
+	ptrs := make([]interface{}, len(cols))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	record := make([]string, len(cols))
+	n := 0
+	var readErr error
+	for rows.Next() {
+		if readErr = rows.Scan(ptrs...); readErr != nil {
+			break
+		}
+		switch format {
+		case "csv":
+			for i, v := range values {
+				if v = exportValue(v); v == nil {
+					record[i] = ""
+				} else {
+					record[i] = fmt.Sprint(v)
+				}
+			}
+			csvWriter.Write(record)
+		default:
+			obj := make(map[string]interface{}, len(cols))
+			for i, c := range cols {
+				obj[c] = exportValue(values[i])
+			}
+			if format == "json" && n > 0 {
+				w.Write([]byte(","))
+			}
+			// Encode já termina cada objeto com \n, o que serve de separador no NDJSON
+			enc.Encode(obj)
+		}
+		n++
+		if n%exportFlushEvery == 0 {
+			csvWriter.Flush()
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+	if readErr == nil {
+		readErr = rows.Err()
+	}
+
+	if readErr != nil {
+		switch format {
+		case "csv":
+			csvWriter.Write([]string{"#ERROR", "exportação interrompida: arquivo incompleto"})
+		case "ndjson":
+			enc.Encode(map[string]string{"error": "exportação interrompida: arquivo incompleto"})
+		}
+		csvWriter.Flush()
+		return n, readErr
+	}
+
+	if format == "json" {
+		w.Write([]byte("]\n"))
+	}
+	csvWriter.Flush()
+	return n, nil
+}
@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"crom-vision/internal/database"
+)
+
+func seedExportLogs(t *testing.T) {
+	t.Helper()
+	insertLivePasswordLink(t, "exp1")
+	logs := []struct{ link, country, at string }{
+		{"exp1", "BR", "2025-03-10 10:00:00"},
+		{"exp1", "US", "2025-03-11 10:00:00"},
+		{"exp1", "PT", "2025-03-12 10:00:00"},
+		{"other", "JP", "2025-03-11 10:00:00"},
+	}
+	for _, l := range logs {
+		database.DB.Exec("INSERT INTO access_logs (link_id, ip_hash, user_agent, country, accessed_at) VALUES (?, 'h', 'Chrome, \"x\"', ?, ?)", l.link, l.country, l.at)
+	}
+}
+
+func getExport(t *testing.T, query string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/api/export?"+query, nil)
+	w := httptest.NewRecorder()
+	ExportHandler(w, req)
+	return w
+}
+
+func TestExportHandler_CSVWithFiltersAndColumns(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	seedExportLogs(t)
+
+	w := getExport(t, "id=exp1&password=123&from=2025-03-10&to=2025-03-11&columns=accessed_at,country,user_agent")
+	if w.Code != http.StatusOK {
+		t.Fatalf("esperava 200, obteve %d: %s", w.Code, w.Body.String())
+	}
+	if cd := w.Header().Get("Content-Disposition"); !strings.Contains(cd, `filename="crom-exp1-access-logs-`) || !strings.HasSuffix(cd, `.csv"`) {
+		t.Errorf("Content-Disposition inesperado: %q", cd)
+	}
+
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("CSV inválido: %v", err)
+	}
+	// Cabeçalho + 2 linhas (dia 12 fica fora do intervalo, "other" é de outro link)
+	if len(records) != 3 {
+		t.Fatalf("esperava 3 linhas, obteve %v", records)
+	}
+	if strings.Join(records[0], ",") != "accessed_at,country,user_agent" {
+		t.Errorf("cabeçalho inesperado: %v", records[0])
+	}
+	if records[1][0] != "2025-03-10T10:00:00Z" || records[2][1] != "US" || records[1][2] != `Chrome, "x"` {
+		t.Errorf("linhas inesperadas: %v", records[1:])
+	}
+}
+
+func TestExportHandler_JSONAndNDJSON(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	seedExportLogs(t)
+
+	w := getExport(t, "id=exp1&password=123&format=json&columns=country,is_unique")
+	var arr []map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &arr); err != nil {
+		t.Fatalf("JSON inválido: %v — %s", err, w.Body.String())
+	}
+	if len(arr) != 3 || arr[0]["country"] != "BR" || len(arr[0]) != 2 {
+		t.Errorf("array inesperado: %v", arr)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/export?id=exp1&format=ndjson", nil)
+	req.Header.Set("X-Crom-Password", "123")
+	w = httptest.NewRecorder()
+	ExportHandler(w, req)
+	if ct := w.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("Content-Type inesperado: %q", ct)
+	}
+	lines := 0
+	sc := bufio.NewScanner(bytes.NewReader(w.Body.Bytes()))
+	for sc.Scan() {
+		var obj map[string]interface{}
+		if err := json.Unmarshal(sc.Bytes(), &obj); err != nil {
+			t.Fatalf("linha NDJSON inválida: %q", sc.Text())
+		}
+		lines++
+	}
+	if lines != 3 {
+		t.Errorf("esperava 3 linhas NDJSON, obteve %d", lines)
+	}
+}
+
+// failingRows entrega "ok" linhas e então falha, como uma conexão que cai no meio da exportação
+type failingRows struct{ ok, read int }
+
+func (f *failingRows) Next() bool { return f.read <= f.ok }
+func (f *failingRows) Scan(dest ...interface{}) error {
+	f.read++
+	if f.read > f.ok {
+		return errors.New("disk I/O error")
+	}
+	*(dest[0].(*interface{})) = "BR"
+	return nil
+}
+func (f *failingRows) Err() error { return nil }
+
+func TestStreamExport_MidStreamFailureIsVisible(t *testing.T) {
+	cols := []string{"country"}
+
+	w := httptest.NewRecorder()
+	if n, err := streamExport(w, &failingRows{ok: 2}, "json", cols); err == nil || n != 2 {
+		t.Fatalf("esperava erro após 2 linhas, obteve n=%d err=%v", n, err)
+	}
+	var arr []map[string]interface{}
+	if json.Unmarshal(w.Body.Bytes(), &arr) == nil {
+		t.Errorf("JSON truncado não pode ser um array válido: %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	streamExport(w, &failingRows{ok: 2}, "ndjson", cols)
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[2], `"error"`) {
+		t.Errorf("NDJSON deveria terminar com a linha de erro: %q", lines)
+	}
+
+	w = httptest.NewRecorder()
+	streamExport(w, &failingRows{ok: 2}, "csv", cols)
+	if _, err := csv.NewReader(bytes.NewReader(w.Body.Bytes())).ReadAll(); err == nil || !strings.Contains(w.Body.String(), "#ERROR") {
+		t.Errorf("CSV truncado deveria terminar com o marcador de erro: %s", w.Body.String())
+	}
+}
+
+func TestExportHandler_CompleteTrailer(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	seedExportLogs(t)
+
+	w := getExport(t, "id=exp1&password=123&format=csv")
+	if got := w.Result().Trailer.Get("X-Crom-Export-Status"); got != "complete" {
+		t.Errorf("trailer X-Crom-Export-Status esperado complete, obteve %q", got)
+	}
+}
+
+func TestExportHandler_Campaign(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	seedExportLogs(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/campaigns", strings.NewReader(`{"name":"Lançamento"}`))
+	w := httptest.NewRecorder()
+	CampaignsHandler(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("esperava 201, obteve %d: %s", w.Code, w.Body.String())
+	}
+	var created map[string]string
+	json.Unmarshal(w.Body.Bytes(), &created)
+	cid := created["campaign_id"]
+	if !strings.HasPrefix(cid, "cmp_") || created["password"] == "" {
+		t.Fatalf("resposta inesperada: %v", created)
+	}
+	database.DB.Exec("UPDATE links SET campaign_id = ? WHERE id = 'exp1'", cid)
+	insertTestLink(t, "exp2", "", "approved", "", 0, 0, false)
+	database.DB.Exec("UPDATE links SET campaign_id = ? WHERE id = 'exp2'", cid)
+	database.DB.Exec("INSERT INTO access_logs (link_id, ip_hash, country) VALUES ('exp2', 'h', 'DE')")
+
+	w = getExport(t, "campaign="+cid+"&password="+created["password"]+"&format=ndjson&columns=link_id")
+	if w.Code != http.StatusOK {
+		t.Fatalf("esperava 200, obteve %d", w.Code)
+	}
+	if got := strings.Count(w.Body.String(), "\n"); got != 4 {
+		t.Errorf("esperava 4 acessos da campanha, obteve %d: %s", got, w.Body.String())
+	}
+	if strings.Contains(w.Body.String(), "other") {
+		t.Error("exportação da campanha vazou acessos de outro link")
+	}
+}
+
+func TestExportHandler_Validation(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	seedExportLogs(t)
+
+	cases := map[string]int{
+		"id=exp1&password=errada":             http.StatusUnauthorized,
+		"campaign=cmp_nada&password=123":      http.StatusUnauthorized,
+		"password=123":                        http.StatusBadRequest,
+		"id=exp1&password=123&format=xml":     http.StatusBadRequest,
+		"id=exp1&password=123&columns=email":  http.StatusBadRequest,
+		"id=exp1&password=123&from=ontem":     http.StatusBadRequest,
+		"id=exp1&campaign=cmp_x&password=123": http.StatusBadRequest,
+	}
+	for q, want := range cases {
+		if w := getExport(t, q); w.Code != want {
+			t.Errorf("%s: esperava %d, obteve %d", q, want, w.Code)
+		}
+	}
+}
+
+func TestCheckoutHandler_Campaign(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	// sha256("123")
+	database.DB.Exec("INSERT INTO campaigns (id, name, password_hash) VALUES ('cmp_t', 'T', 'a665a45920422f9d417e4867efdc4fb8a04a1f3fff1fa07e998e86f7f7a27ae3')")
+
+	checkout := func(password string) *httptest.ResponseRecorder {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		writer.WriteField("tier", "7d")
+		writer.WriteField("campaign_id", "cmp_t")
+		writer.WriteField("campaign_password", password)
+		writer.Close()
+		req := httptest.NewRequest(http.MethodPost, "/api/checkout", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		w := httptest.NewRecorder()
+		CheckoutHandler(w, req)
+		return w
+	}
+
+	if w := checkout("errada"); w.Code != http.StatusForbidden {
+		t.Fatalf("senha errada da campanha deveria dar 403, obteve %d", w.Code)
+	}
+	w := checkout("123")
+	if w.Code != http.StatusOK {
+		t.Fatalf("esperava 200, obteve %d: %s", w.Code, w.Body.String())
+	}
+	var resp map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	var campaignID string
+	database.DB.QueryRow("SELECT campaign_id FROM links WHERE id = ?", resp["id"]).Scan(&campaignID)
+	if campaignID != "cmp_t" || resp["campaign_id"] != "cmp_t" {
+		t.Errorf("link deveria entrar na campanha, obteve %q / %v", campaignID, resp["campaign_id"])
+	}
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"crom-vision/internal/database"
+)
+
+// LinkFraudHandler consulta ou altera a detecção de inflação de views do link.
+// GET  /api/link-fraud?id=xxx&password=yyy
+// POST /api/link-fraud {"id","password","exclude_suspicious":true,"clear_flag":true}
+// exclude_suspicious faz os hits suspeitos pararem de consumir max_views;
+// clear_flag encerra a revisão (uma nova detecção volta a marcar o link).
+func LinkFraudHandler(w http.ResponseWriter, r *http.Request) {
+	var id, password string
+	var req struct {
+		ID                string `json:"id"`
+		Password          string `json:"password"`
+		ExcludeSuspicious *bool  `json:"exclude_suspicious"`
+		ClearFlag         bool   `json:"clear_flag"`
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		id, password = credentialFromQuery(r)
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid Payload", http.StatusBadRequest)
+			return
+		}
+		id, password = req.ID, req.Password
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if id == "" {
+		http.Error(w, "ID missing", http.StatusBadRequest)
+		return
+	}
+	if !ownerAuthorized(r, id, password) {
+		http.Error(w, "Unauthorized (Invalid Password)", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		_, err := database.DB.Exec(`
+			UPDATE links SET fraud_exclude = COALESCE(?, fraud_exclude),
+				fraud_flagged_at = CASE WHEN ? THEN NULL ELSE fraud_flagged_at END,
+				fraud_reason = CASE WHEN ? THEN NULL ELSE fraud_reason END
+			WHERE id = ?`, req.ExcludeSuspicious, req.ClearFlag, req.ClearFlag, id)
+		if err != nil {
+			http.Error(w, "DB Error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	var exclude bool
+	var flaggedAt sql.NullTime
+	var reason sql.NullString
+	err := database.DB.QueryRow("SELECT COALESCE(fraud_exclude, 0), fraud_flagged_at, fraud_reason FROM links WHERE id = ?", id).
+		Scan(&exclude, &flaggedAt, &reason)
+	if err != nil {
+		http.Error(w, "Link not found", http.StatusNotFound)
+		return
+	}
+
+	// Hits suspeitos por motivo; "excluded" são os que não consumiram views
+	byReason := map[string]int{}
+	total, excluded := 0, 0
+	rows, err := database.DB.Query(`
+		SELECT suspicious_reason, COUNT(*), SUM(CASE WHEN status = 'suspicious' THEN 1 ELSE 0 END)
+		FROM access_logs WHERE link_id = ? AND suspicious_reason IS NOT NULL
+		GROUP BY suspicious_reason`, id)
+	if err != nil {
+		http.Error(w, "DB Error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var rsn string
+		var n, ex int
+		if rows.Scan(&rsn, &n, &ex) == nil {
+			byReason[rsn] = n
+			total += n
+			excluded += ex
+		}
+	}
+
+	resp := map[string]interface{}{
+		"id":                 id,
+		"flagged":            flaggedAt.Valid,
+		"flagged_at":         nil,
+		"reason":             nil,
+		"exclude_suspicious": exclude,
+		"suspicious_hits": map[string]interface{}{
+			"total":     total,
+			"excluded":  excluded,
+			"by_reason": byReason,
+		},
+	}
+	if flaggedAt.Valid {
+		resp["flagged_at"] = flaggedAt.Time.Format(time.RFC3339)
+	}
+	if reason.Valid {
+		resp["reason"] = reason.String
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"crom-vision/internal/database"
+	"crom-vision/internal/services"
+)
+
+func TestRecordHit_ExcludedSuspiciousDoesNotConsumeViews(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	insertTestLink(t, "fr1", "https://crom.run", "approved", "", 5, 0, false)
+
+	recordHit(accessHit{LinkID: "fr1", FingerprintHash: "h1", Country: "BR", ClientClass: "browser", Unique: true,
+		Suspicious: services.FraudFingerprintRate, ExcludeSuspicious: true})
+	recordHit(accessHit{LinkID: "fr1", FingerprintHash: "h2", Country: "BR", ClientClass: "browser", Unique: true,
+		Suspicious: services.FraudIdenticalUA})
+
+	var total int
+	database.DB.QueryRow("SELECT total_views FROM links WHERE id = 'fr1'").Scan(&total)
+	if total != 1 {
+		t.Errorf("só o hit suspeito não excluído deveria contar, total_views=%d", total)
+	}
+
+	var status string
+	database.DB.QueryRow("SELECT status FROM access_logs WHERE link_id = 'fr1' AND ip_hash = 'h1'").Scan(&status)
+	if status != "suspicious" {
+		t.Errorf("hit excluído deveria ficar com status suspicious, obteve %q", status)
+	}
+}
+
+func TestLinkFraudHandler(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	insertLivePasswordLink(t, "fr2")
+	services.FlagLinkForReview("fr2", services.FraudASNBurst)
+	recordHit(accessHit{LinkID: "fr2", FingerprintHash: "h1", Suspicious: services.FraudASNBurst, ExcludeSuspicious: true})
+	recordHit(accessHit{LinkID: "fr2", FingerprintHash: "h2", Suspicious: services.FraudASNBurst})
+	recordHit(accessHit{LinkID: "fr2", FingerprintHash: "h3"})
+
+	w := httptest.NewRecorder()
+	LinkFraudHandler(w, httptest.NewRequest(http.MethodGet, "/api/link-fraud?id=fr2&password=errada", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("senha errada: esperava 401, obteve %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	LinkFraudHandler(w, httptest.NewRequest(http.MethodGet, "/api/link-fraud?id=fr2&password=123", nil))
+	var resp struct {
+		Flagged           bool    `json:"flagged"`
+		Reason            *string `json:"reason"`
+		ExcludeSuspicious bool    `json:"exclude_suspicious"`
+		SuspiciousHits    struct {
+			Total    int            `json:"total"`
+			Excluded int            `json:"excluded"`
+			ByReason map[string]int `json:"by_reason"`
+		} `json:"suspicious_hits"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if !resp.Flagged || resp.Reason == nil || *resp.Reason != services.FraudASNBurst {
+		t.Errorf("esperava link marcado por %s: %+v", services.FraudASNBurst, resp)
+	}
+	if resp.SuspiciousHits.Total != 2 || resp.SuspiciousHits.Excluded != 1 || resp.SuspiciousHits.ByReason[services.FraudASNBurst] != 2 {
+		t.Errorf("contagem de suspeitos inesperada: %+v", resp.SuspiciousHits)
+	}
+
+	body := `{"id":"fr2","password":"123","exclude_suspicious":true,"clear_flag":true}`
+	w = httptest.NewRecorder()
+	LinkFraudHandler(w, httptest.NewRequest(http.MethodPost, "/api/link-fraud", strings.NewReader(body)))
+	resp.Reason = nil
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.Flagged || resp.Reason != nil || !resp.ExcludeSuspicious {
+		t.Errorf("POST deveria limpar a marcação e ativar a exclusão: %+v", resp)
+	}
+}
+
+func TestImageHandler_EmailProxyBurstIsNotSuspicious(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	insertTestLink(t, "fr3", "", "approved", "", 0, 0, false)
+	database.DB.Exec("UPDATE links SET fraud_exclude = 1 WHERE id = 'fr3'")
+
+	// Newsletter para leitores do Gmail: todas as aberturas chegam pelo mesmo proxy (faixa
+	// do Google), acima dos limites de fingerprint e de User-Agent idêntico usados para navegadores
+	for i := 0; i < 80; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/i/fr3", nil)
+		req.RemoteAddr = "66.249.84.10:41000"
+		req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 5.1; rv:11.0) Gecko Firefox/11.0 (via ggpht.com GoogleImageProxy)")
+		ImageHandler(httptest.NewRecorder(), req)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	var total, suspicious int
+	var flagged sql.NullTime
+	database.DB.QueryRow("SELECT total_views, fraud_flagged_at FROM links WHERE id = 'fr3'").Scan(&total, &flagged)
+	database.DB.QueryRow("SELECT COUNT(*) FROM access_logs WHERE link_id = 'fr3' AND suspicious_reason IS NOT NULL").Scan(&suspicious)
+	if total != 80 || suspicious != 0 || flagged.Valid {
+		t.Errorf("aberturas via proxy de e-mail não são fraude: total=%d suspeitos=%d em revisão=%v", total, suspicious, flagged.Valid)
+	}
+}
+
+func TestImageHandler_SpoofedEmailProxyUAIsStillFlagged(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	insertTestLink(t, "fr4", "", "approved", "", 0, 0, false)
+	database.DB.Exec("UPDATE links SET fraud_exclude = 1 WHERE id = 'fr4'")
+
+	// Script com o UA do GoogleImageProxy, mas saindo de um IP qualquer
+	for i := 0; i < 80; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/i/fr4", nil)
+		req.RemoteAddr = "198.51.100.7:41000"
+		req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 5.1; rv:11.0) Gecko Firefox/11.0 (via ggpht.com GoogleImageProxy)")
+		ImageHandler(httptest.NewRecorder(), req)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	var total, suspicious int
+	var flagged sql.NullTime
+	database.DB.QueryRow("SELECT total_views, fraud_flagged_at FROM links WHERE id = 'fr4'").Scan(&total, &flagged)
+	database.DB.QueryRow("SELECT COUNT(*) FROM access_logs WHERE link_id = 'fr4' AND suspicious_reason IS NOT NULL").Scan(&suspicious)
+	if suspicious == 0 || !flagged.Valid || total >= 80 {
+		t.Errorf("UA de proxy forjado deveria seguir as regras normais: total=%d suspeitos=%d em revisão=%v", total, suspicious, flagged.Valid)
+	}
+}
@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"crom-vision/internal/database"
+	"crom-vision/internal/services"
+)
+
+// Limites do top-N do detalhamento geográfico
+const (
+	defaultGeoLimit = 250
+	maxGeoLimit     = 1000
+)
+
+// geoLevels mapeia ?level= para as colunas agrupadas e o cabeçalho do GeoChart
+var geoLevels = map[string]struct {
+	columns []string
+	header  string
+}{
+	"country": {[]string{"country"}, "Country"},
+	"region":  {[]string{"country", "region"}, "Region"},
+	"city":    {[]string{"country", "region", "city"}, "City"},
+}
+
+// geoEntry é uma linha do formato JSON simples (?format=json)
+type geoEntry struct {
+	Country string `json:"country"`
+	Region  string `json:"region,omitempty"`
+	City    string `json:"city,omitempty"`
+	Views   int    `json:"views"`
+	// visitors (distintos) decide o k-anonimato do grupo; não é exposto
+	visitors int
+}
+
+// LinkGeoHandler devolve os acessos servidos agrupados por país, região ou cidade.
+// GET /api/link-geo?id=xxx[&level=country|region|city][&country=BR][&limit=N][&format=chart|json]
+// format=chart (padrão) mantém o array do Google GeoChart; format=json devolve objetos simples.
+// Grupos com menos de K_ANON_MIN_GROUP visitantes vão para "Other"; sem a Senha Mágica
+// (&password= ou X-Crom-Password) as contagens saem arredondadas por K_ANON_ROUND_TO.
+// Exige a senha, a sessão do dono ou um share token com o escopo geo, mesmo na vitrine pública.
+func LinkGeoHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	id := q.Get("id")
+	if id == "" {
+		http.Error(w, "ID missing", http.StatusBadRequest)
+		return
+	}
+	if !authorizeStats(w, r, id, scopeGeo) {
+		return
+	}
+
+	levelName := q.Get("level")
+	if levelName == "" {
+		levelName = "country"
+	}
+	level, ok := geoLevels[levelName]
+	if !ok {
+		http.Error(w, "Invalid level (country, region, city)", http.StatusBadRequest)
+		return
+	}
+	format := q.Get("format")
+	if format == "" {
+		format = "chart"
+	}
+	if format != "chart" && format != "json" {
+		http.Error(w, "Invalid format (chart, json)", http.StatusBadRequest)
+		return
+	}
+	country := strings.ToUpper(strings.TrimSpace(q.Get("country")))
+	if country != "" && len(country) != 2 {
+		http.Error(w, "Invalid country (ISO 3166-1 alpha-2)", http.StatusBadRequest)
+		return
+	}
+	limit := defaultGeoLimit
+	if raw := q.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		if n > maxGeoLimit {
+			n = maxGeoLimit
+		}
+		limit = n
+	}
+
+	var entries []geoEntry
+	kAnon := loadKAnonymity(isPublicRequest(r))
+	// Se geo tracking desativado, retorna vazio
+	if strings.ToLower(os.Getenv("GEO_TRACKING_ENABLED")) != "false" {
+		var err error
+		if entries, err = geoBreakdown(id, level.columns, country); err != nil {
+			http.Error(w, "DB Error", http.StatusInternalServerError)
+			return
+		}
+		entries = anonymizeGeo(entries, len(level.columns), limit, kAnon)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if format == "json" {
+		if entries == nil {
+			entries = []geoEntry{}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"level":   levelName,
+			"country": country,
+			"items":   entries,
+			"k_anon":  kAnon,
+		})
+		return
+	}
+
+	// First row represents the columns for Google GeoChart
+	data := [][]interface{}{{level.header, "Views"}}
+	for _, e := range entries {
+		label := e.Country
+		switch levelName {
+		case "region":
+			label = e.Region
+		case "city":
+			label = e.City
+		}
+		data = append(data, []interface{}{label, e.Views})
+	}
+	json.NewEncoder(w).Encode(data)
+}
+
+// geoBreakdown agrupa os acessos servidos do link pelas colunas do nível pedido.
+// Logs anteriores à coluna region (ou sem subdivisão no GeoLite2) caem em "Unknown".
+// Região e cidade só existem nos logs brutos e seguem LOG_RETENTION_DAYS.
+func geoBreakdown(linkID string, columns []string, country string) ([]geoEntry, error) {
+	// País sai do rollup diário, que preserva o histórico além da retenção dos logs
+	if len(columns) == 1 {
+		return countryBreakdown(linkID, country)
+	}
+
+	selects := make([]string, len(columns))
+	for i, c := range columns {
+		selects[i] = "COALESCE(NULLIF(" + c + ", ''), 'Unknown')"
+	}
+	group := strings.Join(selects, ", ")
+
+	query := "SELECT " + group + ", COUNT(*), COUNT(DISTINCT ip_hash) FROM access_logs WHERE link_id = ? AND status = 'served'"
+	args := []interface{}{linkID}
+	if country != "" {
+		query += " AND country = ?"
+		args = append(args, country)
+	}
+	query += " GROUP BY " + group + " ORDER BY COUNT(*) DESC, " + group
+
+	rows, err := database.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []geoEntry
+	for rows.Next() {
+		var e geoEntry
+		dest := []interface{}{&e.Country, &e.Region, &e.City}[:len(columns)]
+		if err := rows.Scan(append(dest, &e.Views, &e.visitors)...); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// countryBreakdown soma as views por país a partir de stats_daily. Os visitantes que
+// decidem o k-anonimato são os distintos de cada país (groupVisitors), não os uniques diários.
+func countryBreakdown(linkID, country string) ([]geoEntry, error) {
+	visitors, err := groupVisitors(linkID, services.VisitorsByCountry, countryGroup, time.Time{}, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+
+	query := "SELECT " + countryGroup + " AS c, SUM(views) FROM stats_daily WHERE link_id = ?"
+	args := []interface{}{linkID}
+	if country != "" {
+		query += " AND country = ?"
+		args = append(args, country)
+	}
+	query += " GROUP BY c ORDER BY SUM(views) DESC, c"
+
+	rows, err := database.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []geoEntry
+	for rows.Next() {
+		var e geoEntry
+		if err := rows.Scan(&e.Country, &e.Views); err != nil {
+			return nil, err
+		}
+		e.visitors = visitors[e.Country]
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// anonymizeGeo soma em "Other" os grupos abaixo do k-anonimato, corta no top-N e
+// arredonda as contagens. Os grupos cortados pelo limite não entram em "Other".
+func anonymizeGeo(entries []geoEntry, depth, limit int, k kAnonymity) []geoEntry {
+	kept := make([]geoEntry, 0, len(entries))
+	other := 0
+	for _, e := range entries {
+		if k.hides(e.visitors) {
+			other += e.Views
+			continue
+		}
+		if len(kept) < limit {
+			e.Views = k.round(e.Views)
+			kept = append(kept, e)
+		}
+	}
+	if other > 0 {
+		o := geoEntry{Country: otherLabel, Views: k.round(other)}
+		if depth > 1 {
+			o.Region = otherLabel
+		}
+		if depth > 2 {
+			o.City = otherLabel
+		}
+		kept = append(kept, o)
+	}
+	return kept
+}
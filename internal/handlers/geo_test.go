@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"crom-vision/internal/database"
+)
+
+func seedGeoLogs(t *testing.T) {
+	t.Helper()
+	seedLinks(t)
+	database.DB.Exec("DELETE FROM access_logs WHERE link_id = 'pub1'")
+	logs := []struct{ country, region, city, status string }{
+		{"BR", "São Paulo", "São Paulo", "served"},
+		{"BR", "São Paulo", "Campinas", "served"},
+		{"BR", "São Paulo", "Campinas", "served"},
+		{"BR", "Rio de Janeiro", "Rio de Janeiro", "served"},
+		{"US", "New York", "New York", "served"},
+		{"BR", "Bahia", "Salvador", "geo_blocked"},
+	}
+	for _, l := range logs {
+		database.DB.Exec("INSERT INTO access_logs (link_id, ip_hash, country, region, city, status) VALUES ('pub1', 'h', ?, ?, ?, ?)",
+			l.country, l.region, l.city, l.status)
+	}
+	// Log antigo, anterior à coluna region
+	database.DB.Exec("INSERT INTO access_logs (link_id, ip_hash, country, city) VALUES ('pub1', 'h', 'BR', 'Recife')")
+	rebuildRollups(t)
+}
+
+type geoJSONResponse struct {
+	Level string     `json:"level"`
+	Items []geoEntry `json:"items"`
+}
+
+func getGeo(t *testing.T, query string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/api/link-geo?"+query, nil)
+	req.Header.Set("X-Crom-Password", "123")
+	w := httptest.NewRecorder()
+	LinkGeoHandler(w, req)
+	return w
+}
+
+func TestLinkGeoHandler_RegionJSONFilteredByCountry(t *testing.T) {
+	cleanup := setupStatsDB(t)
+	defer cleanup()
+	seedGeoLogs(t)
+
+	w := getGeo(t, "id=pub1&level=region&country=br&format=json")
+	if w.Code != http.StatusOK {
+		t.Fatalf("esperava 200, obteve %d: %s", w.Code, w.Body.String())
+	}
+	var resp geoJSONResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+
+	want := []geoEntry{
+		{Country: "BR", Region: "São Paulo", Views: 3},
+		{Country: "BR", Region: "Rio de Janeiro", Views: 1},
+		{Country: "BR", Region: "Unknown", Views: 1},
+	}
+	if resp.Level != "region" || len(resp.Items) != len(want) {
+		t.Fatalf("resposta inesperada: %s", w.Body.String())
+	}
+	for i := range want {
+		if resp.Items[i] != want[i] {
+			t.Errorf("item %d: esperava %+v, obteve %+v", i, want[i], resp.Items[i])
+		}
+	}
+}
+
+func TestLinkGeoHandler_CityChartWithLimit(t *testing.T) {
+	cleanup := setupStatsDB(t)
+	defer cleanup()
+	seedGeoLogs(t)
+
+	w := getGeo(t, "id=pub1&level=city&limit=1")
+	var data [][]interface{}
+	json.Unmarshal(w.Body.Bytes(), &data)
+	if len(data) != 2 || data[0][0] != "City" {
+		t.Fatalf("esperava header City + 1 linha, obteve %v", data)
+	}
+	if data[1][0] != "Campinas" || data[1][1] != float64(2) {
+		t.Errorf("esperava Campinas com 2 views, obteve %v", data[1])
+	}
+}
+
+func TestLinkGeoHandler_Validation(t *testing.T) {
+	cleanup := setupStatsDB(t)
+	defer cleanup()
+	seedGeoLogs(t)
+
+	for _, q := range []string{"id=pub1&level=street", "id=pub1&format=csv", "id=pub1&country=BRA", "id=pub1&limit=0"} {
+		if w := getGeo(t, q); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: esperava 400, obteve %d", q, w.Code)
+		}
+	}
+}
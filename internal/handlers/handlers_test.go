@@ -158,6 +158,39 @@ func TestCheckoutHandler_InvalidFileFormat(t *testing.T) {
 	}
 }
 
+func TestCheckoutHandler_RejectedRequestLeavesNoFiles(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	os.MkdirAll(os.Getenv("STORAGE_PATH"), 0755)
+
+	png := append([]byte{0x89, 0x50, 0x4E, 0x47, 0x0D, 0x0A, 0x1A, 0x0A}, make([]byte, 512)...)
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("tier", "3d")
+	part, _ := writer.CreateFormFile("image", "main.png")
+	part.Write(png)
+	for _, name := range []string{"a.png", "b.png"} {
+		part, _ = writer.CreateFormFile("variants", name)
+		part.Write(png)
+	}
+	// Validação que só roda depois de gravar os uploads
+	writer.WriteField("delivery_tz", "Fuso/Inexistente")
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/checkout", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	CheckoutHandler(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("esperava 400, obteve %d — body: %s", w.Code, w.Body.String())
+	}
+	entries, _ := os.ReadDir(os.Getenv("STORAGE_PATH"))
+	if len(entries) != 0 {
+		t.Errorf("requisição recusada não deveria deixar arquivos no storage, encontrou %d", len(entries))
+	}
+}
+
 func TestCheckoutHandler_SaaS_PendingPayment(t *testing.T) {
 	cleanup := setupTestDB(t)
 	defer cleanup()
@@ -408,3 +441,167 @@ func TestPreviewHandler_Success(t *testing.T) {
 		t.Errorf("esperava 200, obteve %d", w.Code)
 	}
 }
+
+func TestImageHandler_NotYetActive(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	insertTestLink(t, "test_scheduled", "https://crom.run", "approved", "", 0, 0, false)
+	database.DB.Exec("UPDATE links SET not_before = datetime('now', '+2 hours') WHERE id = 'test_scheduled'")
+
+	req := httptest.NewRequest(http.MethodGet, "/i/test_scheduled", nil)
+	w := httptest.NewRecorder()
+	ImageHandler(w, req)
+
+	if w.Code == http.StatusFound {
+		t.Error("link agendado não deve redirecionar antes de not_before")
+	}
+	if w.Header().Get("X-Crom-Status") != "Not-Yet-Active" {
+		t.Errorf("esperava X-Crom-Status=Not-Yet-Active, obteve %q", w.Header().Get("X-Crom-Status"))
+	}
+}
+
+func TestPreviewHandler_NotYetActive(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	tmpFile := filepath.Join(t.TempDir(), "img.png")
+	os.WriteFile(tmpFile, []byte("segredo-do-lancamento"), 0644)
+	insertTestLink(t, "test_sched_prev", "", "approved", tmpFile, 0, 0, false)
+	database.DB.Exec("UPDATE links SET not_before = datetime('now', '+1 day') WHERE id = 'test_sched_prev'")
+
+	req := httptest.NewRequest(http.MethodGet, "/p/test_sched_prev", nil)
+	w := httptest.NewRecorder()
+	PreviewHandler(w, req)
+
+	if w.Header().Get("X-Crom-Status") != "Not-Yet-Active" {
+		t.Errorf("esperava X-Crom-Status=Not-Yet-Active, obteve %q", w.Header().Get("X-Crom-Status"))
+	}
+	if bytes.Contains(w.Body.Bytes(), []byte("segredo-do-lancamento")) {
+		t.Error("conteúdo real não pode vazar antes de not_before")
+	}
+}
+
+func TestCheckoutHandler_NotBefore(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("tier", "7d")
+	writer.WriteField("not_before", "invalido")
+	writer.Close()
+	req := httptest.NewRequest(http.MethodPost, "/api/checkout", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	CheckoutHandler(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("not_before inválido deveria retornar 400, obteve %d", w.Code)
+	}
+
+	body = &bytes.Buffer{}
+	writer = multipart.NewWriter(body)
+	writer.WriteField("tier", "7d")
+	writer.WriteField("not_before", "2099-01-15T09:00:00-03:00")
+	writer.Close()
+	req = httptest.NewRequest(http.MethodPost, "/api/checkout", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w = httptest.NewRecorder()
+	CheckoutHandler(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("esperava 200, obteve %d — body: %s", w.Code, w.Body.String())
+	}
+
+	var resp map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp["not_before"] != "2099-01-15 12:00:00" {
+		t.Errorf("not_before deveria ser normalizado para UTC, obteve %v", resp["not_before"])
+	}
+	if exp, _ := resp["expires_at"].(string); exp < "2099-01-22" {
+		t.Errorf("expiração deveria contar a partir de not_before, obteve %v", resp["expires_at"])
+	}
+}
+
+func TestCheckoutHandler_NotBeforeWithoutOffset(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+
+	checkout := func(fields map[string]string) *httptest.ResponseRecorder {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		writer.WriteField("tier", "7d")
+		for k, v := range fields {
+			writer.WriteField(k, v)
+		}
+		writer.Close()
+		req := httptest.NewRequest(http.MethodPost, "/api/checkout", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		w := httptest.NewRecorder()
+		CheckoutHandler(w, req)
+		return w
+	}
+	notBefore := func(w *httptest.ResponseRecorder) interface{} {
+		var resp map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp["not_before"]
+	}
+
+	// datetime-local sem fuso é ambíguo
+	if w := checkout(map[string]string{"not_before": "2099-01-15T09:00"}); w.Code != http.StatusBadRequest {
+		t.Errorf("not_before sem offset nem tz: esperava 400, obteve %d", w.Code)
+	}
+
+	// 09:00 em São Paulo é 12:00 UTC
+	w := checkout(map[string]string{"not_before": "2099-01-15T09:00", "tz": "America/Sao_Paulo"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("esperava 200, obteve %d — body: %s", w.Code, w.Body.String())
+	}
+	if nb := notBefore(w); nb != "2099-01-15 12:00:00" {
+		t.Errorf("09:00 em America/Sao_Paulo deveria virar 12:00 UTC, obteve %v", nb)
+	}
+
+	// Sem tz, vale o delivery_tz do link
+	w = checkout(map[string]string{"not_before": "2099-01-15T09:00", "delivery_tz": "Europe/Lisbon"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("esperava 200, obteve %d — body: %s", w.Code, w.Body.String())
+	}
+	if nb := notBefore(w); nb != "2099-01-15 09:00:00" {
+		t.Errorf("09:00 em Europe/Lisbon (inverno) deveria virar 09:00 UTC, obteve %v", nb)
+	}
+
+	// Horário que não existe na virada do horário de verão
+	if w := checkout(map[string]string{"not_before": "2099-03-29T01:30", "tz": "Europe/Lisbon"}); w.Code != http.StatusBadRequest {
+		t.Errorf("horário inexistente: esperava 400, obteve %d", w.Code)
+	}
+	// Horário que se repete no fim do horário de verão
+	if w := checkout(map[string]string{"not_before": "2099-10-25T01:30", "tz": "Europe/Lisbon"}); w.Code != http.StatusBadRequest {
+		t.Errorf("horário ambíguo: esperava 400, obteve %d", w.Code)
+	}
+	if w := checkout(map[string]string{"not_before": "2099-01-15T09:00", "tz": "Marte/Olympus"}); w.Code != http.StatusBadRequest {
+		t.Errorf("tz inválido: esperava 400, obteve %d", w.Code)
+	}
+}
+
+func TestCheckoutHandler_SpoofedXFFDoesNotBypassLimit(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	os.Setenv("FREE_UPLOADS_IP_LIMIT", "1")
+	defer os.Unsetenv("FREE_UPLOADS_IP_LIMIT")
+
+	codes := []int{}
+	for _, spoofed := range []string{"1.1.1.1", "2.2.2.2"} {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		writer.WriteField("tier", "1d")
+		writer.Close()
+		req := httptest.NewRequest(http.MethodPost, "/api/checkout", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("X-Forwarded-For", spoofed)
+		req.RemoteAddr = "203.0.113.50:40000"
+		w := httptest.NewRecorder()
+		CheckoutHandler(w, req)
+		codes = append(codes, w.Code)
+	}
+
+	if codes[0] != http.StatusOK || codes[1] != http.StatusTooManyRequests {
+		t.Errorf("XFF de cliente não confiável deve ser ignorado na cota, códigos: %v", codes)
+	}
+}
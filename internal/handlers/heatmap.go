@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"crom-vision/internal/database"
+)
+
+// heatmapDays segue a semana ISO (segunda primeiro), como os buckets semanais das séries
+var heatmapDays = []string{"Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"}
+
+// heatmapCounts devolve as contagens do link por hora (rollup) ou por minuto (logs brutos)
+// no intervalo; o rollup horário só serve fusos com deslocamento de horas inteiras.
+func heatmapCounts(linkID string, sr statsRange, unique bool) (map[time.Time]int, error) {
+	var counts visitCounts
+	var err error
+	if sr.rollupTable() == "stats_hourly" {
+		counts, err = rollupCounts(linkID, sr, "stats_hourly")
+	} else {
+		counts, err = minuteCounts(linkID, sr)
+	}
+	if unique {
+		return counts.Unique, err
+	}
+	return counts.Total, err
+}
+
+// HeatmapHandler devolve a matriz 7x24 (dia da semana x hora local) de views de um link ou campanha.
+// GET /api/heatmap?id=xxx|campaign=cmp_xxx&password=yyy[&metric=total|unique][&period=7d|30d|90d|all | &from=...&to=...][&tz=America/Sao_Paulo]
+// Campanhas exigem a senha da campanha; links seguem o acesso de /api/link-stats (senha, sessão ou share token).
+func HeatmapHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	id, password := credentialFromQuery(r)
+	campaign := q.Get("campaign")
+	if (id == "") == (campaign == "") {
+		http.Error(w, "Informe id ou campaign", http.StatusBadRequest)
+		return
+	}
+	if campaign != "" && !campaignPasswordMatches(campaign, password) {
+		http.Error(w, "Unauthorized (Invalid Password)", http.StatusUnauthorized)
+		return
+	}
+	if id != "" && !authorizeStats(w, r, id, scopeHeatmap) {
+		return
+	}
+
+	metric := q.Get("metric")
+	if metric == "" {
+		metric = "total"
+	}
+	if metric != "total" && metric != "unique" {
+		http.Error(w, "Invalid metric (total, unique)", http.StatusBadRequest)
+		return
+	}
+
+	linkIDs := []string{id}
+	if campaign != "" {
+		linkIDs = nil
+		rows, err := database.DB.Query("SELECT id FROM links WHERE campaign_id = ? ORDER BY created_at ASC", campaign)
+		if err != nil {
+			http.Error(w, "DB Error", http.StatusInternalServerError)
+			return
+		}
+		for rows.Next() {
+			var lid string
+			if rows.Scan(&lid) == nil {
+				linkIDs = append(linkIDs, lid)
+			}
+		}
+		rows.Close()
+	}
+
+	// Sem intervalo explícito, o padrão é o último mês (24h não diz nada sobre a semana)
+	if q.Get("period") == "" && q.Get("from") == "" && q.Get("to") == "" {
+		q.Set("period", "30d")
+	}
+	// period=all começa no link mais antigo da campanha
+	rangeLink := id
+	if len(linkIDs) > 0 {
+		rangeLink = linkIDs[0]
+	}
+	sr, err := parseStatsRange(q, rangeLink, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// A matriz é por hora: alinha o início para o rollup horário cobrir a hora inteira
+	sr.Granularity = granHour
+	sr.From = sr.truncate(sr.From)
+
+	matrix := make([][]int, 7)
+	for d := range matrix {
+		matrix[d] = make([]int, 24)
+	}
+	total, max := 0, 0
+	for _, lid := range linkIDs {
+		counts, err := heatmapCounts(lid, sr, metric == "unique")
+		if err != nil {
+			http.Error(w, "DB Error", http.StatusInternalServerError)
+			return
+		}
+		for at, n := range counts {
+			local := at.In(sr.Loc)
+			day := (int(local.Weekday()) + 6) % 7
+			matrix[day][local.Hour()] += n
+			total += n
+		}
+	}
+	for _, row := range matrix {
+		for _, n := range row {
+			if n > max {
+				max = n
+			}
+		}
+	}
+
+	hours := make([]int, 24)
+	for h := range hours {
+		hours[h] = h
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"days":   heatmapDays,
+		"hours":  hours,
+		"matrix": matrix,
+		"metric": metric,
+		"total":  total,
+		"max":    max,
+		"period": sr.Period,
+		"tz":     sr.Loc.String(),
+		"from":   sr.From.Format(time.RFC3339),
+		"to":     sr.To.Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"crom-vision/internal/database"
+)
+
+type heatmapResponse struct {
+	Days   []string `json:"days"`
+	Matrix [][]int  `json:"matrix"`
+	Total  int      `json:"total"`
+	Max    int      `json:"max"`
+	TZ     string   `json:"tz"`
+}
+
+func getHeatmap(t *testing.T, query string) (*httptest.ResponseRecorder, heatmapResponse) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/api/heatmap?"+query, nil)
+	req.Header.Set("X-Crom-Password", "123")
+	w := httptest.NewRecorder()
+	HeatmapHandler(w, req)
+	var resp heatmapResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return w, resp
+}
+
+func seedHeatmapLogs(t *testing.T) {
+	t.Helper()
+	insertLivePasswordLink(t, "hm1")
+	// 2025-03-10 é segunda-feira
+	for _, h := range []struct{ ip, at string }{
+		{"a", "2025-03-10 12:00:00"}, // 09h seg em Brasília
+		{"a", "2025-03-10 12:01:00"}, // F5: total, não único
+		{"b", "2025-03-10 12:30:00"},
+		{"c", "2025-03-16 02:00:00"}, // domingo 02h UTC = sábado 23h em Brasília
+		{"d", "2025-04-20 10:00:00"}, // fora do intervalo
+	} {
+		database.DB.Exec("INSERT INTO access_logs (link_id, ip_hash, accessed_at) VALUES ('hm1', ?, ?)", h.ip, h.at)
+	}
+	rebuildRollups(t)
+}
+
+func TestHeatmapHandler_LocalTimezone(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	seedHeatmapLogs(t)
+
+	w, resp := getHeatmap(t, "id=hm1&from=2025-03-10&to=2025-03-16&tz=America/Sao_Paulo")
+	if w.Code != http.StatusOK {
+		t.Fatalf("esperava 200, obteve %d: %s", w.Code, w.Body.String())
+	}
+	if len(resp.Matrix) != 7 || len(resp.Matrix[0]) != 24 || resp.Days[0] != "Mon" {
+		t.Fatalf("matriz deveria ser 7x24 começando na segunda: %s", w.Body.String())
+	}
+	if resp.Matrix[0][9] != 3 || resp.Matrix[5][23] != 1 || resp.Total != 4 || resp.Max != 3 {
+		t.Errorf("contagens inesperadas: seg 09h=%d, sáb 23h=%d, total=%d, max=%d",
+			resp.Matrix[0][9], resp.Matrix[5][23], resp.Total, resp.Max)
+	}
+
+	_, resp = getHeatmap(t, "id=hm1&from=2025-03-10&to=2025-03-16&tz=America/Sao_Paulo&metric=unique")
+	if resp.Matrix[0][9] != 2 || resp.Total != 3 {
+		t.Errorf("únicos: esperava seg 09h=2 e total=3, obteve %d e %d", resp.Matrix[0][9], resp.Total)
+	}
+}
+
+func TestHeatmapHandler_HalfHourTimezoneUsesRawLogs(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	seedHeatmapLogs(t)
+
+	// Índia (UTC+5:30): 12:00 e 12:01 UTC caem às 17h, 12:30 UTC às 18h
+	_, resp := getHeatmap(t, "id=hm1&from=2025-03-10&to=2025-03-16&tz=Asia/Kolkata")
+	if resp.Matrix[0][17] != 2 || resp.Matrix[0][18] != 1 || resp.TZ != "Asia/Kolkata" {
+		t.Errorf("esperava seg 17h=2 e 18h=1, obteve %d e %d", resp.Matrix[0][17], resp.Matrix[0][18])
+	}
+}
+
+func TestHeatmapHandler_CampaignAndValidation(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	seedHeatmapLogs(t)
+	// sha256("123")
+	database.DB.Exec("INSERT INTO campaigns (id, name, password_hash) VALUES ('cmp_hm', 'HM', 'a665a45920422f9d417e4867efdc4fb8a04a1f3fff1fa07e998e86f7f7a27ae3')")
+	database.DB.Exec("UPDATE links SET campaign_id = 'cmp_hm' WHERE id = 'hm1'")
+
+	w, resp := getHeatmap(t, "campaign=cmp_hm&password=123&from=2025-03-10&to=2025-03-16&tz=UTC")
+	if w.Code != http.StatusOK || resp.Matrix[0][12] != 3 || resp.Matrix[6][2] != 1 {
+		t.Errorf("heatmap da campanha inesperado: %s", w.Body.String())
+	}
+
+	cases := map[string]int{
+		"campaign=cmp_hm&password=errada": http.StatusUnauthorized,
+		"password=123":                    http.StatusBadRequest,
+		"id=hm1&metric=median":            http.StatusBadRequest,
+		"id=hm1&tz=Marte/Olympus":         http.StatusBadRequest,
+	}
+	for q, want := range cases {
+		if w, _ := getHeatmap(t, q); w.Code != want {
+			t.Errorf("%s: esperava %d, obteve %d", q, want, w.Code)
+		}
+	}
+}
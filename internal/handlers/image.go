@@ -2,15 +2,264 @@ package handlers
 
 import (
 	"database/sql"
+	"log"
 	"net/http"
 	"os"
 	"strings"
 	"time"
 
 	"crom-vision/internal/database"
+	"crom-vision/internal/metrics"
+	"crom-vision/internal/services"
 	"crom-vision/internal/utils"
 )
 
+// accessHit é uma visualização válida pronta para ser contabilizada
+type accessHit struct {
+	LinkID          string
+	FingerprintHash string
+	UserAgent       string
+	Country         string
+	Region          string
+	City            string
+	ClientClass     string
+	Unique          bool
+	VariantID       sql.NullInt64
+	ASN             uint
+	// Suspicious é o motivo do detector de fraude ("" = hit normal); com ExcludeSuspicious
+	// (escolha do dono do link) o hit suspeito é só registrado, sem consumir max_views
+	Suspicious        string
+	ExcludeSuspicious bool
+}
+
+// enqueueHit grava o hit em segundo plano, contando-o na fila de ingestão até terminar
+func enqueueHit(h accessHit) {
+	metrics.IngestQueueDepth.Inc()
+	go func() {
+		defer metrics.IngestQueueDepth.Dec()
+		recordHit(h)
+	}()
+}
+
+// recordHit incrementa os contadores do link (e da variante A/B), grava o
+// access_log e publica o evento para quem acompanha o link ao vivo
+func recordHit(h accessHit) {
+	suspicious := sql.NullString{String: h.Suspicious, Valid: h.Suspicious != ""}
+	if h.Suspicious != "" && h.ExcludeSuspicious {
+		_, err := database.DB.Exec(`
+			INSERT INTO access_logs (link_id, ip_hash, user_agent, country, region, city, variant_id, client_class, status, suspicious_reason, asn)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, 'suspicious', ?, ?)`,
+			h.LinkID, h.FingerprintHash, h.UserAgent, h.Country, h.Region, h.City, h.VariantID, h.ClientClass, suspicious, h.ASN)
+		if err != nil {
+			log.Printf("[DB ERR] Falha ao registrar hit suspeito do link %s: %v", h.LinkID, err)
+		}
+		return
+	}
+
+	// RETURNING devolve o total exato deste hit, mesmo com acessos concorrentes
+	var totalViews, maxViews int
+	var err error
+	if h.Unique {
+		err = database.DB.QueryRow("UPDATE links SET total_views = total_views + 1, unique_views = unique_views + 1 WHERE id = ? RETURNING total_views, max_views", h.LinkID).Scan(&totalViews, &maxViews)
+	} else {
+		err = database.DB.QueryRow("UPDATE links SET total_views = total_views + 1 WHERE id = ? RETURNING total_views, max_views", h.LinkID).Scan(&totalViews, &maxViews)
+	}
+	if err == nil {
+		services.NotifyLinkView(h.LinkID, totalViews, maxViews)
+		services.CheckViewAlerts(h.LinkID, totalViews, maxViews)
+	}
+	if h.VariantID.Valid {
+		if h.Unique {
+			database.DB.Exec("UPDATE link_variants SET total_views = total_views + 1, unique_views = unique_views + 1 WHERE id = ?", h.VariantID.Int64)
+		} else {
+			database.DB.Exec("UPDATE link_variants SET total_views = total_views + 1 WHERE id = ?", h.VariantID.Int64)
+		}
+	}
+
+	// Visita de retorno: única, mas o ip_hash já tinha acessado o link antes (consultado
+	// antes do INSERT para não enxergar o próprio hit)
+	returning := false
+	if h.Unique {
+		database.DB.QueryRow("SELECT EXISTS (SELECT 1 FROM access_logs WHERE link_id = ? AND ip_hash = ? AND status = 'served')",
+			h.LinkID, h.FingerprintHash).Scan(&returning)
+	}
+	// Visitante novo no país ou na classe de cliente, para as contagens do k-anonimato
+	// que sobrevivem à retenção dos logs (stats_visitors)
+	seenCountry, seenClient := false, false
+	database.DB.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM access_logs WHERE link_id = ? AND ip_hash = ? AND status = 'served' AND COALESCE(country, '') = ?),
+			EXISTS (SELECT 1 FROM access_logs WHERE link_id = ? AND ip_hash = ? AND status = 'served' AND COALESCE(client_class, '') = ?)`,
+		h.LinkID, h.FingerprintHash, h.Country, h.LinkID, h.FingerprintHash, h.ClientClass).Scan(&seenCountry, &seenClient)
+
+	accessedAt := time.Now().UTC()
+	// Gravação e publicação ao vivo juntas, para os streams receberem os hits na ordem dos IDs
+	inserted := liveHits.insertAndPublish(h.LinkID, func() (liveEvent, bool) {
+		res, err := database.DB.Exec(`
+			INSERT INTO access_logs (link_id, ip_hash, user_agent, country, region, city, variant_id, client_class, is_unique, accessed_at, suspicious_reason, asn)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			h.LinkID, h.FingerprintHash, h.UserAgent, h.Country, h.Region, h.City, h.VariantID, h.ClientClass, h.Unique,
+			accessedAt.Format("2006-01-02 15:04:05"), suspicious, h.ASN)
+		if err != nil {
+			log.Printf("[DB ERR] Falha ao registrar acesso do link %s: %v", h.LinkID, err)
+			return liveEvent{}, false
+		}
+		logID, _ := res.LastInsertId()
+		return liveEvent{
+			ID:          logID,
+			Timestamp:   accessedAt,
+			Country:     h.Country,
+			ClientClass: h.ClientClass,
+			Unique:      h.Unique,
+		}, true
+	})
+	if !inserted {
+		return
+	}
+
+	services.RecordRollup(h.LinkID, accessedAt, h.Country, h.ClientClass, h.Unique, returning)
+	if !seenCountry {
+		services.RecordVisitor(h.LinkID, services.VisitorsByCountry, h.Country)
+	}
+	if !seenClient {
+		services.RecordVisitor(h.LinkID, services.VisitorsByClient, h.ClientClass)
+	}
+}
+
+// pixelLink reúne os campos do link que decidem se um acesso ao pixel /i/ ou a um
+// embed (badge, sparkline) é admitido e contado
+type pixelLink struct {
+	ID            string
+	OriginalURL   sql.NullString
+	FilePath      sql.NullString
+	VariantMode   sql.NullString
+	MaxViews      int
+	TotalViews    int
+	UniqueViews   int
+	ExpiresAt     sql.NullTime
+	NotBefore     sql.NullTime
+	PaymentStatus string
+	IsPrivate     bool
+	ReadTracking  bool
+	FraudExclude  bool
+	Rules         deliveryRules
+}
+
+func loadPixelLink(id string) (pixelLink, error) {
+	l := pixelLink{ID: id}
+	err := database.DB.QueryRow(`
+		SELECT original_url, file_path, variant_mode, max_views, total_views, unique_views, expires_at, not_before, payment_status,
+			COALESCE(is_private, 0), COALESCE(read_tracking, 0), COALESCE(fraud_exclude, 0),
+			COALESCE(allowed_countries, ''), COALESCE(blocked_countries, ''), COALESCE(delivery_hours, ''), COALESCE(delivery_days, ''), COALESCE(delivery_tz, '')
+		FROM links WHERE id = ?`, id).
+		Scan(&l.OriginalURL, &l.FilePath, &l.VariantMode, &l.MaxViews, &l.TotalViews, &l.UniqueViews, &l.ExpiresAt, &l.NotBefore, &l.PaymentStatus,
+			&l.IsPrivate, &l.ReadTracking, &l.FraudExclude,
+			&l.Rules.AllowedCountries, &l.Rules.BlockedCountries, &l.Rules.Hours, &l.Rules.Days, &l.Rules.TZ)
+	return l, err
+}
+
+// gate devolve o X-Crom-Status quando o link ainda não (ou não mais) serve o conteúdo real
+func (l pixelLink) gate(now time.Time) string {
+	switch {
+	case l.PaymentStatus != "approved":
+		return "Payment-Pending"
+	case l.NotBefore.Valid && now.Before(l.NotBefore.Time):
+		return "Not-Yet-Active"
+	case l.ExpiresAt.Valid && now.After(l.ExpiresAt.Time):
+		return "Expired-Time"
+	case l.MaxViews > 0 && l.TotalViews >= l.MaxViews:
+		return "Limit-Reached"
+	}
+	return ""
+}
+
+// pixelVisitor identifica o leitor só pelo hash (IP + UA) e pelo UA truncado
+type pixelVisitor struct {
+	IP              string
+	UserAgent       string
+	FingerprintHash string
+	Country         string
+	Region          string
+	City            string
+	ClientClass     string
+}
+
+// admitVisitor identifica o leitor e aplica as regras de entrega do link. Fora da cerca
+// geográfica ou da janela de horário, registra a tentativa sem consumir views e devolve o X-Crom-Status
+func admitVisitor(r *http.Request, l pixelLink) (pixelVisitor, string) {
+	v := pixelVisitor{IP: utils.ClientIP(r)}
+
+	// GeoIP lookup — só se GEO_TRACKING_ENABLED estiver ativo (ou o link tiver cerca geográfica)
+	geoEnabled := strings.ToLower(os.Getenv("GEO_TRACKING_ENABLED")) != "false"
+	if geoEnabled || l.Rules.hasGeoRules() {
+		v.Country, v.Region, v.City = utils.LookupGeoIPDetail(v.IP)
+	}
+
+	v.UserAgent = r.UserAgent()
+	// LGPD: truncar User-Agent para evitar fingerprinting excessivo
+	if len(v.UserAgent) > 120 {
+		v.UserAgent = v.UserAgent[:120]
+	}
+	v.FingerprintHash = utils.ComposeFingerprintHash(v.IP, v.UserAgent)
+	v.ClientClass = utils.ClassifyClient(v.UserAgent)
+
+	blocked := l.Rules.evaluate(v.Country, time.Now())
+	if !geoEnabled {
+		v.Country, v.Region, v.City = "", "", ""
+	}
+	if blocked != "" {
+		logStatus := "geo_blocked"
+		if blocked == "Outside-Window" {
+			logStatus = "time_blocked"
+		}
+		go database.DB.Exec("INSERT INTO access_logs (link_id, ip_hash, user_agent, country, region, city, status, client_class) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+			l.ID, v.FingerprintHash, v.UserAgent, v.Country, v.Region, v.City, logStatus, v.ClientClass)
+	}
+	return v, blocked
+}
+
+// pixelHit é o desfecho de um acesso admitido
+type pixelHit struct {
+	Unfurl     bool // robô de pré-visualização: recebe a imagem, mas não conta como abertura
+	Unique     bool
+	Suspicious string
+	Counted    bool // consumiu uma view (hits suspeitos com fraud_exclude não consomem)
+}
+
+// recordPixelHit separa os robôs de pré-visualização, passa o hit pelo detector de
+// inflação e o enfileira para contabilização
+func recordPixelHit(l pixelLink, v pixelVisitor, variantID sql.NullInt64) pixelHit {
+	if v.ClientClass == utils.ClientUnfurler {
+		go logUnfurl(l.ID, v.FingerprintHash, v.UserAgent, v.Country, v.Region, v.City)
+		return pixelHit{Unfurl: true}
+	}
+
+	hit := pixelHit{Unique: utils.IsUniqueAccess(l.ID + "::" + v.FingerprintHash)}
+
+	// Detector de inflação: marca o hit e coloca o link em revisão
+	asn := utils.LookupASN(v.IP)
+	hit.Suspicious = services.CheckFraud(l.ID, v.FingerprintHash, asn, v.UserAgent, utils.IsVerifiedEmailProxy(v.UserAgent, v.IP, asn))
+	if hit.Suspicious != "" {
+		go services.FlagLinkForReview(l.ID, hit.Suspicious)
+	}
+	hit.Counted = hit.Suspicious == "" || !l.FraudExclude
+
+	enqueueHit(accessHit{
+		LinkID:            l.ID,
+		FingerprintHash:   v.FingerprintHash,
+		UserAgent:         v.UserAgent,
+		Country:           v.Country,
+		Region:            v.Region,
+		City:              v.City,
+		ClientClass:       v.ClientClass,
+		Unique:            hit.Unique,
+		VariantID:         variantID,
+		ASN:               asn,
+		Suspicious:        hit.Suspicious,
+		ExcludeSuspicious: l.FraudExclude,
+	})
+	return hit
+}
+
 func ImageHandler(w http.ResponseWriter, r *http.Request) {
 	id := r.URL.Path[len("/i/"):]
 	if id == "" {
@@ -22,79 +271,74 @@ func ImageHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate, max-age=0")
 	w.Header().Set("Pragma", "no-cache")
 
-	var originalURL sql.NullString
-	var maxViews, totalViews int
-	var expiresAt sql.NullTime
-	var paymentStatus string
-	var filePath sql.NullString
-
-	err := database.DB.QueryRow("SELECT original_url, max_views, total_views, expires_at, payment_status, file_path FROM links WHERE id = ?", id).
-		Scan(&originalURL, &maxViews, &totalViews, &expiresAt, &paymentStatus, &filePath)
+	// Desfecho do hit para o /metrics: o X-Crom-Status definido abaixo, ou Served
+	defer func() {
+		outcome := w.Header().Get("X-Crom-Status")
+		if outcome == "" {
+			outcome = "Served"
+		}
+		metrics.PixelHits.Inc(outcome)
+	}()
 
+	link, err := loadPixelLink(id)
 	if err != nil {
+		w.Header().Set("X-Crom-Status", "Not-Found")
 		w.Header().Set("Content-Type", "image/gif")
 		w.Write(utils.TransparentGif)
 		return
 	}
 
-	if paymentStatus != "approved" {
-		w.Header().Set("X-Crom-Status", "Payment-Pending")
+	if status := link.gate(time.Now()); status != "" {
+		if status == "Not-Yet-Active" {
+			serveFallback(w, r, status)
+			return
+		}
+		w.Header().Set("X-Crom-Status", status)
 		w.Header().Set("Content-Type", "image/gif")
 		w.Write(utils.TransparentGif)
 		return
 	}
 
-	if expiresAt.Valid && time.Now().After(expiresAt.Time) {
-		w.Header().Set("X-Crom-Status", "Expired-Time")
-		w.Header().Set("Content-Type", "image/gif")
-		w.Write(utils.TransparentGif)
-		return
-	}
-	if maxViews > 0 && totalViews >= maxViews {
-		w.Header().Set("X-Crom-Status", "Limit-Reached")
-		w.Header().Set("Content-Type", "image/gif")
-		w.Write(utils.TransparentGif)
+	// Regras de entrega: fora da cerca geográfica ou da janela de horário,
+	// serve a imagem substituta (a tentativa já foi registrada sem consumir views)
+	visitor, blocked := admitVisitor(r, link)
+	if blocked != "" {
+		serveFallback(w, r, blocked)
 		return
 	}
 
-	ip := r.RemoteAddr
-	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
-		ip = forwarded
+	// Teste A/B: escolhe uma das imagens cadastradas para este pixel
+	var variant *linkVariant
+	if variants, err := loadVariants(id); err == nil && len(variants) > 0 {
+		variant = pickVariant(variants, link.VariantMode.String, visitor.FingerprintHash)
 	}
-
-	// GeoIP lookup — só se GEO_TRACKING_ENABLED estiver ativo
-	var country, city string
-	if strings.ToLower(os.Getenv("GEO_TRACKING_ENABLED")) != "false" {
-		country, city = utils.LookupGeoIP(ip)
+	var variantID sql.NullInt64
+	if variant != nil {
+		variantID = sql.NullInt64{Int64: variant.ID, Valid: true}
 	}
 
-	ua := r.UserAgent()
-	// LGPD: truncar User-Agent para evitar fingerprinting excessivo
-	if len(ua) > 120 {
-		ua = ua[:120]
+	hit := recordPixelHit(link, visitor, variantID)
+
+	// Modo de medição de leitura: o pixel vira um GIF animado transmitido aos poucos
+	if !hit.Unfurl && link.ReadTracking && serveDripPixel(w, r, id, visitor.FingerprintHash) {
+		return
 	}
-	fingerprintHash := utils.ComposeFingerprintHash(ip, ua)
-	fingerprintKey := id + "::" + fingerprintHash
-	isUnique := utils.IsUniqueAccess(fingerprintKey)
 
-	go func(linkID, fHash, uaStr, ctry, cty string, uniq bool) {
-		if uniq {
-			database.DB.Exec("UPDATE links SET total_views = total_views + 1, unique_views = unique_views + 1 WHERE id = ?", linkID)
-		} else {
-			database.DB.Exec("UPDATE links SET total_views = total_views + 1 WHERE id = ?", linkID)
-		}
-		database.DB.Exec("INSERT INTO access_logs (link_id, ip_hash, user_agent, country, city) VALUES (?, ?, ?, ?, ?)",
-			linkID, fHash, uaStr, ctry, cty)
-	}(id, fingerprintHash, ua, country, city, isUnique)
+	if variant != nil && variant.FilePath != "" {
+		w.Header().Del("Content-Type")
+		w.Header().Set("X-Crom-Variant", variant.Label)
+		http.ServeFile(w, r, variant.FilePath)
+		return
+	}
 
-	if originalURL.Valid && originalURL.String != "" {
-		http.Redirect(w, r, originalURL.String, http.StatusFound)
+	if link.OriginalURL.Valid && link.OriginalURL.String != "" {
+		http.Redirect(w, r, link.OriginalURL.String, http.StatusFound)
 		return
 	}
 
-	if filePath.Valid && filePath.String != "" {
+	if link.FilePath.Valid && link.FilePath.String != "" {
 		w.Header().Del("Content-Type") 
-		http.ServeFile(w, r, filePath.String)
+		http.ServeFile(w, r, link.FilePath.String)
 		return
 	}
 
@@ -112,7 +356,12 @@ func PreviewHandler(w http.ResponseWriter, r *http.Request) {
 	var filePath sql.NullString
 	var isPrivate bool
 	var paymentStatus string
-	err := database.DB.QueryRow("SELECT file_path, is_private, payment_status FROM links WHERE id = ?", id).Scan(&filePath, &isPrivate, &paymentStatus)
+	var notBefore sql.NullTime
+	var imageWidth, imageHeight sql.NullInt64
+	meta := linkMeta{ID: id}
+	err := database.DB.QueryRow(`
+		SELECT file_path, is_private, payment_status, not_before, COALESCE(title, ''), COALESCE(description, ''), COALESCE(alt_text, ''), image_width, image_height
+		FROM links WHERE id = ?`, id).Scan(&filePath, &isPrivate, &paymentStatus, &notBefore, &meta.Title, &meta.Description, &meta.AltText, &imageWidth, &imageHeight)
 	
 	if err != nil || !filePath.Valid || filePath.String == "" {
 		http.NotFound(w, r)
@@ -129,5 +378,37 @@ func PreviewHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if notBefore.Valid && time.Now().Before(notBefore.Time) {
+		w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate, max-age=0")
+		serveFallback(w, r, "Not-Yet-Active")
+		return
+	}
+
+	ua := r.UserAgent()
+	if len(ua) > 120 {
+		ua = ua[:120]
+	}
+	if utils.IsUnfurler(ua) {
+		ip := utils.ClientIP(r)
+		var country, region, city string
+		if strings.ToLower(os.Getenv("GEO_TRACKING_ENABLED")) != "false" {
+			country, region, city = utils.LookupGeoIPDetail(ip)
+		}
+		go logUnfurl(id, utils.ComposeFingerprintHash(ip, ua), ua, country, region, city)
+	}
+
+	// A mesma URL atende navegadores/robôs (HTML) e tags <img> (bytes)
+	w.Header().Set("Vary", "Accept")
+	if wantsLandingPage(r) {
+		meta.Width, meta.Height = linkImageDimensions(id, filePath.String, imageWidth, imageHeight)
+		renderLandingPage(w, meta)
+		return
+	}
+
+	// Versões redimensionadas (?w=&h=) usadas pelo oEmbed com maxwidth/maxheight
+	if serveRendition(w, r, filePath.String) {
+		return
+	}
+
 	http.ServeFile(w, r, filePath.String)
 }
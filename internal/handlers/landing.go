@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"crom-vision/internal/database"
+	"crom-vision/internal/utils"
+)
+
+// Limites dos metadados de compartilhamento informados no checkout
+const (
+	maxTitleLen       = 120
+	maxDescriptionLen = 300
+	maxAltTextLen     = 300
+)
+
+// linkMeta são os metadados usados na página de compartilhamento /p/:id
+type linkMeta struct {
+	ID          string
+	Title       string
+	Description string
+	AltText     string
+	PageURL     string
+	ImageURL    string
+	OEmbedJSON  string
+	OEmbedXML   string
+	Width       int
+	Height      int
+}
+
+var landingTemplate = template.Must(template.New("landing").Parse(`<!DOCTYPE html>
+<html lang="pt-BR">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+{{if .Description}}<meta name="description" content="{{.Description}}">{{end}}
+<link rel="canonical" href="{{.PageURL}}">
+<link rel="alternate" type="application/json+oembed" href="{{.OEmbedJSON}}" title="{{.Title}}">
+<link rel="alternate" type="text/xml+oembed" href="{{.OEmbedXML}}" title="{{.Title}}">
+<meta property="og:type" content="website">
+<meta property="og:site_name" content="Crom-Vision">
+<meta property="og:title" content="{{.Title}}">
+{{if .Description}}<meta property="og:description" content="{{.Description}}">{{end}}
+<meta property="og:url" content="{{.PageURL}}">
+<meta property="og:image" content="{{.ImageURL}}">
+{{if .Width}}<meta property="og:image:width" content="{{.Width}}">
+<meta property="og:image:height" content="{{.Height}}">{{end}}
+{{if .AltText}}<meta property="og:image:alt" content="{{.AltText}}">{{end}}
+<meta name="twitter:card" content="summary_large_image">
+<meta name="twitter:title" content="{{.Title}}">
+{{if .Description}}<meta name="twitter:description" content="{{.Description}}">{{end}}
+<meta name="twitter:image" content="{{.ImageURL}}">
+{{if .AltText}}<meta name="twitter:image:alt" content="{{.AltText}}">{{end}}
+<style>body{margin:0;min-height:100vh;display:flex;flex-direction:column;align-items:center;justify-content:center;background:#0f172a;color:#e2e8f0;font-family:system-ui,sans-serif}img{max-width:100%;max-height:85vh}h1{font-size:1.1rem;font-weight:600}p{color:#94a3b8;max-width:40rem;text-align:center}</style>
+</head>
+<body>
+<img src="{{.ImageURL}}" alt="{{.AltText}}"{{if .Width}} width="{{.Width}}" height="{{.Height}}"{{end}}>
+<h1>{{.Title}}</h1>
+{{if .Description}}<p>{{.Description}}</p>{{end}}
+</body>
+</html>
+`))
+
+// wantsLandingPage decide entre a página HTML e os bytes da imagem.
+// ?view=page força a página; ?view=image força a imagem; sem parâmetro vale o Accept
+// (navegadores e robôs de pré-visualização pedem text/html, tags <img> pedem image/*).
+func wantsLandingPage(r *http.Request) bool {
+	switch r.URL.Query().Get("view") {
+	case "page":
+		return true
+	case "image", "raw":
+		return false
+	}
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// logUnfurl registra o acesso de um robô de pré-visualização sem consumir views
+func logUnfurl(linkID, fingerprintHash, ua, country, region, city string) {
+	_, err := database.DB.Exec("INSERT INTO access_logs (link_id, ip_hash, user_agent, country, region, city, status, client_class) VALUES (?, ?, ?, ?, ?, ?, 'unfurl', ?)",
+		linkID, fingerprintHash, ua, country, region, city, utils.ClientUnfurler)
+	if err != nil {
+		log.Printf("[DB ERR] Falha ao registrar unfurl do link %s: %v", linkID, err)
+	}
+}
+
+func publicBaseURL() string {
+	baseURL := os.Getenv("BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:8080"
+	}
+	return strings.TrimRight(baseURL, "/")
+}
+
+// renderLandingPage serve a página mínima com as tags Open Graph / Twitter Card
+func renderLandingPage(w http.ResponseWriter, meta linkMeta) {
+	baseURL := publicBaseURL()
+	if meta.Title == "" {
+		meta.Title = "Imagem compartilhada via Crom-Vision"
+	}
+	meta.PageURL = baseURL + "/p/" + meta.ID
+	meta.ImageURL = baseURL + "/p/" + meta.ID + "?view=image"
+	meta.OEmbedJSON = baseURL + "/oembed?format=json&url=" + url.QueryEscape(meta.PageURL)
+	meta.OEmbedXML = baseURL + "/oembed?format=xml&url=" + url.QueryEscape(meta.PageURL)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	landingTemplate.Execute(w, meta)
+}
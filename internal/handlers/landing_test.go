@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"crom-vision/internal/database"
+)
+
+func insertLandingLink(t *testing.T, id string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "img.png")
+	os.WriteFile(path, []byte("\x89PNG\r\n\x1a\nfake"), 0644)
+	insertTestLink(t, id, "", "approved", path, 0, 0, false)
+	database.DB.Exec(`UPDATE links SET title = 'Gráfico "Q3"', description = 'Resultados <trimestrais>', alt_text = 'Barras azuis' WHERE id = ?`, id)
+}
+
+func TestPreviewHandler_LandingPage(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	insertLandingLink(t, "og1")
+
+	req := httptest.NewRequest(http.MethodGet, "/p/og1", nil)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,*/*;q=0.8")
+	w := httptest.NewRecorder()
+	PreviewHandler(w, req)
+
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Fatalf("esperava HTML, obteve %s", ct)
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		`<meta property="og:image" content="http://test.local/p/og1?view=image">`,
+		`<meta name="twitter:card" content="summary_large_image">`,
+		`<meta property="og:image:alt" content="Barras azuis">`,
+		`Resultados &lt;trimestrais&gt;`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("página não contém %q", want)
+		}
+	}
+	if strings.Contains(body, `"Q3"">`) {
+		t.Error("título deveria ser escapado nos atributos")
+	}
+	if w.Header().Get("Vary") != "Accept" {
+		t.Error("resposta negociada deveria ter Vary: Accept")
+	}
+}
+
+func TestPreviewHandler_ImageClientsGetBytes(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	insertLandingLink(t, "og2")
+
+	for _, target := range []string{"/p/og2", "/p/og2?view=image"} {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("Accept", "image/avif,image/webp,*/*")
+		w := httptest.NewRecorder()
+		PreviewHandler(w, req)
+		if !strings.HasPrefix(w.Body.String(), "\x89PNG") {
+			t.Errorf("%s deveria servir os bytes da imagem", target)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	PreviewHandler(w, httptest.NewRequest(http.MethodGet, "/p/og2?view=page", nil))
+	if !strings.Contains(w.Body.String(), "og:title") {
+		t.Error("?view=page deveria forçar a página HTML")
+	}
+}
+
+func TestUnfurlerHitsAreTaggedNotCounted(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	insertLandingLink(t, "og3")
+
+	slack := "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)"
+	req := httptest.NewRequest(http.MethodGet, "/p/og3", nil)
+	req.Header.Set("User-Agent", slack)
+	req.Header.Set("Accept", "text/html")
+	PreviewHandler(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest(http.MethodGet, "/i/og3", nil)
+	req.Header.Set("User-Agent", slack)
+	w := httptest.NewRecorder()
+	ImageHandler(w, req)
+	if !strings.HasPrefix(w.Body.String(), "\x89PNG") {
+		t.Error("unfurler deveria receber a imagem normalmente")
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	var total, unfurls int
+	database.DB.QueryRow("SELECT total_views FROM links WHERE id = 'og3'").Scan(&total)
+	database.DB.QueryRow("SELECT COUNT(*) FROM access_logs WHERE link_id = 'og3' AND status = 'unfurl' AND client_class = 'unfurler'").Scan(&unfurls)
+	if total != 0 || unfurls != 2 {
+		t.Errorf("esperava 0 views e 2 unfurls, obteve %d e %d", total, unfurls)
+	}
+}
+
+func TestCheckoutHandler_RejectsLongTitle(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("tier", "7d")
+	writer.WriteField("title", strings.Repeat("a", maxTitleLen+1))
+	writer.Close()
+	req := httptest.NewRequest(http.MethodPost, "/api/checkout", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	CheckoutHandler(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("esperava 400, obteve %d", w.Code)
+	}
+}
@@ -4,11 +4,16 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"html"
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	"crom-vision/internal/database"
+	"crom-vision/internal/services"
+	"crom-vision/internal/utils"
 )
 
 // LGPDConsultarHandler permite ao titular consultar quais dados existem sobre ele.
@@ -69,12 +74,21 @@ func LGPDConsultarHandler(w http.ResponseWriter, r *http.Request) {
 		"links_encontrados": len(dados),
 		"total_logs_acesso": totalLogs,
 		"dados":            dados,
-		"mensagem":         "Para solicitar exclusão, use DELETE /api/lgpd/apagar com este mesmo email.",
+		"mensagem":         "Para solicitar exclusão, use DELETE /api/lgpd/apagar com este mesmo email e confirme pelo link enviado a ele.",
 	})
 }
 
+// lgpdMailer é substituível nos testes
+var lgpdMailer = services.SendEmail
+
+// lgpdTokenTTL é a validade do link de confirmação da exclusão
+const lgpdTokenTTL = time.Hour
+
 // LGPDApagarHandler permite ao titular solicitar exclusão de todos os seus dados.
 // DELETE /api/lgpd/apagar — body: {"email": "usuario@email.com"}
+// Com a sessão da conta desse e-mail, a exclusão é imediata. Sem ela, a resposta é 202 e um
+// link de confirmação vai para o próprio endereço: nada é apagado antes do clique, e quem
+// só conhece o e-mail não consegue apagar os dados de outra pessoa.
 func LGPDApagarHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
 		http.Error(w, "Method Not Allowed — Use DELETE", http.StatusMethodNotAllowed)
@@ -88,14 +102,102 @@ func LGPDApagarHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Email obrigatório", http.StatusBadRequest)
 		return
 	}
+	email, ok := normalizeEmail(req.Email)
+	if !ok {
+		http.Error(w, "E-mail inválido", http.StatusBadRequest)
+		return
+	}
 
-	// 1. Coletar file_paths para apagar arquivos físicos
-	rows, err := database.DB.Query(`SELECT id, file_path FROM links WHERE email = ?`, req.Email)
+	if _, sessionEmail, ok := sessionUser(r); ok && sessionEmail == email {
+		writeErasure(w, email)
+		return
+	}
+
+	if recentEmailTokens(email, tokenLGPD) >= maxLoginRequestsPerHour {
+		http.Error(w, "Muitos pedidos de exclusão para este e-mail. Tente novamente mais tarde.", http.StatusTooManyRequests)
+		return
+	}
+	token, err := issueEmailToken(email, tokenLGPD, "", lgpdTokenTTL)
 	if err != nil {
 		http.Error(w, "Erro interno", http.StatusInternalServerError)
 		return
 	}
 
+	baseURL := os.Getenv("BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:8080"
+	}
+	link := baseURL + "/api/lgpd/confirmar?token=" + token
+	go lgpdMailer(email, "Crom-Vision - Confirme a exclusão dos seus dados", fmt.Sprintf(`<h2>Exclusão de dados (LGPD)</h2>
+	<p>Recebemos um pedido para apagar permanentemente os links, imagens, logs de acesso e a conta associados a este e-mail.</p>
+	<p>O link abaixo vale por %d minutos e só pode ser usado uma vez.</p>
+	<p><a href="%s" style="display:inline-block;background:#dc2626;color:white;padding:12px 24px;border-radius:8px;text-decoration:none;font-weight:bold">Revisar e confirmar a exclusão →</a></p>
+	<p style="color:#71717a">Se você não fez este pedido, ignore este e-mail: nada será apagado.</p>`, int(lgpdTokenTTL.Minutes()), link))
+
+	// A resposta é a mesma com ou sem dados, para não revelar quais e-mails têm links
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":   "confirmation_sent",
+		"mensagem": "Enviamos um link de confirmação para o e-mail informado. Nenhum dado é apagado antes da confirmação.",
+	})
+}
+
+// LGPDConfirmarHandler conclui a exclusão pedida sem sessão.
+// GET  /api/lgpd/confirmar?token=xxx — página com o botão de confirmação; não apaga nada,
+// porque leitores de e-mail e antivírus costumam abrir os links sozinhos
+// POST /api/lgpd/confirmar (formulário token=xxx) — consome o token e apaga os dados
+func LGPDConfirmarHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		token := r.URL.Query().Get("token")
+		if token == "" {
+			http.Error(w, "Token missing", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, `<!DOCTYPE html>
+<html lang="pt-BR"><head><meta charset="UTF-8"><meta name="viewport" content="width=device-width, initial-scale=1.0"><title>Crom-Vision | Exclusão de dados</title></head>
+<body style="font-family:sans-serif;background:#09090b;color:#f4f4f5;display:flex;align-items:center;justify-content:center;min-height:100vh;margin:0">
+<form method="POST" action="/api/lgpd/confirmar" style="max-width:420px;padding:32px;border:1px solid #3f3f46;border-radius:16px">
+<h2>Apagar meus dados</h2>
+<p>Todos os links, imagens, logs de acesso e a conta deste e-mail serão removidos permanentemente.</p>
+<input type="hidden" name="token" value="%s">
+<button type="submit" style="background:#dc2626;color:white;border:0;padding:12px 24px;border-radius:8px;font-weight:bold;cursor:pointer">Confirmar exclusão</button>
+</form></body></html>`, html.EscapeString(token))
+	case http.MethodPost:
+		t, ok := consumeEmailToken(r.FormValue("token"), tokenLGPD)
+		if !ok {
+			http.Error(w, "Link de confirmação inválido, expirado ou já utilizado.", http.StatusBadRequest)
+			return
+		}
+		writeErasure(w, t.Email)
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// lgpdErasure resume o que foi removido para o titular
+type lgpdErasure struct {
+	Links   int64
+	Logs    int64
+	Files   int
+	Account bool
+}
+
+// eraseTitularData apaga tudo o que pertence ao titular: os links cadastrados com o e-mail
+// (sem diferenciar maiúsculas) e os links da conta dele pelo user_id, mesmo com outro e-mail
+// ou nenhum, com arquivos, logs, variantes, webhooks, compartilhamentos e rollups; depois a
+// inscrição no resumo periódico e a conta. O e-mail já chega normalizado (minúsculas).
+func eraseTitularData(email string) (lgpdErasure, error) {
+	var out lgpdErasure
+	owned := "LOWER(TRIM(email)) = ? OR user_id IN (SELECT id FROM users WHERE email = ?)"
+
+	// 1. Coletar file_paths para apagar arquivos físicos
+	rows, err := database.DB.Query("SELECT id, file_path FROM links WHERE "+owned, email, email)
+	if err != nil {
+		return out, err
+	}
 	var linkIDs []string
 	var filePaths []string
 	for rows.Next() {
@@ -109,55 +211,90 @@ func LGPDApagarHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	rows.Close()
 
-	if len(linkIDs) == 0 {
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"mensagem":     "Nenhum dado encontrado para este email.",
-			"links_removidos": 0,
-		})
-		return
+	// Imagens das variantes A/B também são arquivos físicos do titular
+	for _, lid := range linkIDs {
+		vRows, err := database.DB.Query(`
+			SELECT file_path FROM link_variants
+			WHERE link_id = ? AND file_path NOT IN (SELECT COALESCE(file_path, '') FROM links WHERE id = ?)`, lid, lid)
+		if err != nil {
+			continue
+		}
+		for vRows.Next() {
+			var fp string
+			if vRows.Scan(&fp) == nil && fp != "" {
+				filePaths = append(filePaths, fp)
+			}
+		}
+		vRows.Close()
 	}
 
 	// 2. Apagar arquivos físicos
+	storagePath := os.Getenv("STORAGE_PATH")
+	if storagePath == "" {
+		storagePath = "./storage"
+	}
 	for _, fp := range filePaths {
-		storagePath := os.Getenv("STORAGE_PATH")
-		if storagePath == "" {
-			storagePath = "./storage"
-		}
 		// Se file_path é só basename, reconstrói caminho
 		fullPath := fp
 		if len(fp) > 0 && fp[0] != '/' && fp[0] != '.' {
 			fullPath = storagePath + "/" + fp
 		}
 		os.Remove(fullPath)
+		utils.RemoveRenditions(fp)
 	}
+	out.Files = len(filePaths)
 
-	// 3. Apagar access_logs
-	var totalLogs int64
+	// 3. Apagar access_logs e o que pende de cada link
 	for _, lid := range linkIDs {
-		res, _ := database.DB.Exec("DELETE FROM access_logs WHERE link_id = ?", lid)
-		if res != nil {
+		if res, err := database.DB.Exec("DELETE FROM access_logs WHERE link_id = ?", lid); err == nil {
 			n, _ := res.RowsAffected()
-			totalLogs += n
+			out.Logs += n
 		}
+		database.DB.Exec("DELETE FROM link_variants WHERE link_id = ?", lid)
+		database.DB.Exec("DELETE FROM read_sessions WHERE link_id = ?", lid)
+		database.DB.Exec("DELETE FROM webhooks WHERE link_id = ?", lid)
+		database.DB.Exec("DELETE FROM webhook_deliveries WHERE link_id = ?", lid)
+		database.DB.Exec("DELETE FROM share_tokens WHERE link_id = ?", lid)
+		services.DeleteRollups(lid)
 	}
 
-	// 4. Apagar links
-	res, _ := database.DB.Exec("DELETE FROM links WHERE email = ?", req.Email)
-	linksRemoved, _ := res.RowsAffected()
+	// 4. Apagar links (antes da conta, que ainda identifica os do user_id), a inscrição no resumo e a conta
+	res, err := database.DB.Exec("DELETE FROM links WHERE "+owned, email, email)
+	if err != nil {
+		return out, err
+	}
+	out.Links, _ = res.RowsAffected()
+	services.UnsubscribeDigest(email)
+	out.Account = deleteAccountByEmail(email)
+	return out, nil
+}
 
-	// 5. Log de auditoria
+// writeErasure executa a exclusão e responde com o resumo (e o log de auditoria)
+func writeErasure(w http.ResponseWriter, email string) {
+	res, err := eraseTitularData(email)
+	if err != nil {
+		log.Printf("[LGPD ERR] Falha na exclusão: %v", err)
+		http.Error(w, "Erro interno", http.StatusInternalServerError)
+		return
+	}
+
+	// Log de auditoria
 	h := sha256.New()
-	h.Write([]byte(req.Email))
+	h.Write([]byte(email))
 	emailHash := hex.EncodeToString(h.Sum(nil))[:12]
-	log.Printf("[LGPD] Exclusão solicitada: email_hash=%s, links=%d, logs=%d, arquivos=%d",
-		emailHash, linksRemoved, totalLogs, len(filePaths))
+	log.Printf("[LGPD] Exclusão confirmada: email_hash=%s, links=%d, logs=%d, arquivos=%d, conta=%t",
+		emailHash, res.Links, res.Logs, res.Files, res.Account)
 
+	mensagem := "Todos os dados associados foram removidos permanentemente."
+	if res.Links == 0 && !res.Account {
+		mensagem = "Nenhum dado encontrado para este email."
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"mensagem":           "Todos os dados associados foram removidos permanentemente.",
-		"links_removidos":    linksRemoved,
-		"logs_removidos":     totalLogs,
-		"arquivos_removidos": len(filePaths),
+		"mensagem":           mensagem,
+		"links_removidos":    res.Links,
+		"logs_removidos":     res.Logs,
+		"arquivos_removidos": res.Files,
+		"conta_removida":     res.Account,
 	})
 }
@@ -7,7 +7,9 @@ import (
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"crom-vision/internal/database"
 )
@@ -128,8 +130,11 @@ func TestLGPDApagar_Success(t *testing.T) {
 	database.DB.Exec(`INSERT INTO links (id, email, file_path, payment_status) VALUES ('del1', 'apagar@test.com', ?, 'approved')`, imgPath)
 	database.DB.Exec(`INSERT INTO access_logs (link_id, ip_hash, user_agent, country, city) VALUES ('del1', 'h', 'ua', 'BR', 'SP')`)
 
+	// Com a sessão da conta do próprio e-mail a exclusão é imediata
+	cookie := loginAs(t, "apagar@test.com")
 	body := `{"email": "apagar@test.com"}`
 	req := httptest.NewRequest(http.MethodDelete, "/api/lgpd/apagar", bytes.NewBufferString(body))
+	req.AddCookie(cookie)
 	w := httptest.NewRecorder()
 	LGPDApagarHandler(w, req)
 
@@ -140,8 +145,8 @@ func TestLGPDApagar_Success(t *testing.T) {
 	var resp map[string]interface{}
 	json.Unmarshal(w.Body.Bytes(), &resp)
 
-	if resp["links_removidos"].(float64) != 1 {
-		t.Errorf("esperava 1 link removido, obteve %v", resp["links_removidos"])
+	if resp["links_removidos"].(float64) != 1 || resp["conta_removida"] != true {
+		t.Errorf("esperava 1 link e a conta removidos, obteve %v", resp)
 	}
 
 	// Verificar que foi realmente apagado do banco
@@ -161,8 +166,10 @@ func TestLGPDApagar_NoData(t *testing.T) {
 	cleanup := setupLGPDDB(t)
 	defer cleanup()
 
+	cookie := loginAs(t, "naoexiste@test.com")
 	body := `{"email": "naoexiste@test.com"}`
 	req := httptest.NewRequest(http.MethodDelete, "/api/lgpd/apagar", bytes.NewBufferString(body))
+	req.AddCookie(cookie)
 	w := httptest.NewRecorder()
 	LGPDApagarHandler(w, req)
 
@@ -177,6 +184,104 @@ func TestLGPDApagar_NoData(t *testing.T) {
 	}
 }
 
+func TestLGPDApagar_RequiresConfirmationAndRemovesAccount(t *testing.T) {
+	cleanup := setupLGPDDB(t)
+	defer cleanup()
+
+	cookie := loginAs(t, "titular@test.com")
+	var userID string
+	database.DB.QueryRow("SELECT id FROM users WHERE email = 'titular@test.com'").Scan(&userID)
+	// Um link cadastrado com o e-mail em maiúsculas e outro da conta com e-mail diferente
+	database.DB.Exec(`INSERT INTO links (id, email, payment_status) VALUES ('tit1', 'Titular@Test.com', 'approved')`)
+	database.DB.Exec(`INSERT INTO links (id, email, payment_status, user_id) VALUES ('tit2', 'outro@test.com', 'approved', ?)`, userID)
+	database.DB.Exec(`INSERT INTO links (id, email, payment_status) VALUES ('alheio', 'alheio@test.com', 'approved')`)
+	database.DB.Exec(`INSERT INTO access_logs (link_id, ip_hash) VALUES ('tit2', 'h')`)
+
+	mails := make(chan string, 1)
+	orig := lgpdMailer
+	lgpdMailer = func(to, subject, body string) { mails <- body }
+	defer func() { lgpdMailer = orig }()
+
+	countLinks := func() int {
+		var n int
+		database.DB.QueryRow("SELECT COUNT(*) FROM links WHERE id IN ('tit1', 'tit2')").Scan(&n)
+		return n
+	}
+
+	// Sem sessão, só quem conhece o e-mail não apaga nada: recebe 202 e o titular recebe o link
+	w := httptest.NewRecorder()
+	LGPDApagarHandler(w, httptest.NewRequest(http.MethodDelete, "/api/lgpd/apagar", bytes.NewBufferString(`{"email": "TITULAR@test.com"}`)))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("sem sessão: esperava 202, obteve %d — %s", w.Code, w.Body.String())
+	}
+	if countLinks() != 2 {
+		t.Fatal("nada deveria ser apagado antes da confirmação")
+	}
+	var body string
+	select {
+	case body = <-mails:
+	case <-time.After(2 * time.Second):
+		t.Fatal("e-mail de confirmação não foi enviado")
+	}
+	m := loginTokenRe.FindStringSubmatch(body)
+	if m == nil || !strings.Contains(body, "/api/lgpd/confirmar?token=") {
+		t.Fatalf("e-mail sem o link de confirmação: %s", body)
+	}
+
+	// A sessão de outra conta também não apaga os dados do titular
+	other := loginAs(t, "alheio@test.com")
+	req := httptest.NewRequest(http.MethodDelete, "/api/lgpd/apagar", bytes.NewBufferString(`{"email": "titular@test.com"}`))
+	req.AddCookie(other)
+	w = httptest.NewRecorder()
+	LGPDApagarHandler(w, req)
+	if w.Code != http.StatusAccepted || countLinks() != 2 {
+		t.Fatalf("sessão de outra conta: esperava 202 sem exclusão, obteve %d", w.Code)
+	}
+	<-mails
+
+	// Abrir o link (GET) só mostra a confirmação; o token de login não serve aqui
+	w = httptest.NewRecorder()
+	LGPDConfirmarHandler(w, httptest.NewRequest(http.MethodGet, "/api/lgpd/confirmar?token="+m[1], nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `method="POST"`) || countLinks() != 2 {
+		t.Fatalf("GET deveria só exibir a confirmação, obteve %d", w.Code)
+	}
+
+	confirm := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/lgpd/confirmar", strings.NewReader("token="+token))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		LGPDConfirmarHandler(w, req)
+		return w
+	}
+	w = confirm(m[1])
+	if w.Code != http.StatusOK {
+		t.Fatalf("confirmação: esperava 200, obteve %d — %s", w.Code, w.Body.String())
+	}
+	var resp map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp["links_removidos"].(float64) != 2 || resp["logs_removidos"].(float64) != 1 || resp["conta_removida"] != true {
+		t.Errorf("resumo inesperado: %v", resp)
+	}
+	var rest, users int
+	database.DB.QueryRow("SELECT COUNT(*) FROM links").Scan(&rest)
+	database.DB.QueryRow("SELECT COUNT(*) FROM users WHERE email = 'titular@test.com'").Scan(&users)
+	if rest != 1 || users != 0 {
+		t.Errorf("deveria restar só o link alheio e nenhuma conta do titular: links=%d contas=%d", rest, users)
+	}
+
+	// A sessão da conta apagada deixa de valer e o token é de uso único
+	req = httptest.NewRequest(http.MethodGet, "/api/me", nil)
+	req.AddCookie(cookie)
+	w = httptest.NewRecorder()
+	MeHandler(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("sessão da conta apagada: esperava 401, obteve %d", w.Code)
+	}
+	if w := confirm(m[1]); w.Code != http.StatusBadRequest {
+		t.Errorf("reuso do token: esperava 400, obteve %d", w.Code)
+	}
+}
+
 func TestLGPDApagar_MethodNotAllowed(t *testing.T) {
 	cleanup := setupLGPDDB(t)
 	defer cleanup()
@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"crom-vision/internal/database"
+	"crom-vision/internal/services"
+	"crom-vision/internal/utils"
+)
+
+// credentialFromQuery lê id e senha de requisições GET (query ou header X-Crom-Password)
+func credentialFromQuery(r *http.Request) (string, string) {
+	password := r.URL.Query().Get("password")
+	if password == "" {
+		password = r.Header.Get("X-Crom-Password")
+	}
+	return r.URL.Query().Get("id"), password
+}
+
+// LinkWebhooksHandler gerencia os webhooks de saída de um link.
+// GET    /api/link-webhooks?id=xxx&password=yyy — lista (sem o segredo)
+// POST   /api/link-webhooks {"id","password","url","events":[...],"sample_rate":0.1,"threshold":100} — cadastra e devolve o segredo
+// DELETE /api/link-webhooks {"id","password","webhook_id"} — remove
+func LinkWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		listLinkWebhooks(w, r)
+	case http.MethodPost:
+		createLinkWebhook(w, r)
+	case http.MethodDelete:
+		deleteLinkWebhook(w, r)
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func listLinkWebhooks(w http.ResponseWriter, r *http.Request) {
+	id, password := credentialFromQuery(r)
+	if id == "" {
+		http.Error(w, "ID missing", http.StatusBadRequest)
+		return
+	}
+	if !ownerAuthorized(r, id, password) {
+		http.Error(w, "Unauthorized (Invalid Password)", http.StatusUnauthorized)
+		return
+	}
+
+	rows, err := database.DB.Query("SELECT id, url, events, sample_rate, threshold, created_at FROM webhooks WHERE link_id = ? ORDER BY created_at ASC", id)
+	if err != nil {
+		http.Error(w, "DB Error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	hooks := []map[string]interface{}{}
+	for rows.Next() {
+		var whID, whURL, events string
+		var sampleRate float64
+		var threshold int
+		var createdAt time.Time
+		rows.Scan(&whID, &whURL, &events, &sampleRate, &threshold, &createdAt)
+		hooks = append(hooks, map[string]interface{}{
+			"webhook_id":  whID,
+			"url":         whURL,
+			"events":      strings.Split(events, ","),
+			"sample_rate": sampleRate,
+			"threshold":   threshold,
+			"created_at":  createdAt,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hooks)
+}
+
+func createLinkWebhook(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID         string   `json:"id"`
+		Password   string   `json:"password"`
+		URL        string   `json:"url"`
+		Events     []string `json:"events"`
+		SampleRate *float64 `json:"sample_rate"`
+		Threshold  int      `json:"threshold"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid Payload", http.StatusBadRequest)
+		return
+	}
+	if !ownerAuthorized(r, req.ID, req.Password) {
+		http.Error(w, "Unauthorized (Invalid Password)", http.StatusUnauthorized)
+		return
+	}
+
+	if err := services.ValidateWebhookURL(req.URL); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	valid := map[string]bool{}
+	for _, e := range services.WebhookEvents {
+		valid[e] = true
+	}
+	if len(req.Events) == 0 {
+		http.Error(w, "Informe ao menos um evento: "+strings.Join(services.WebhookEvents, ", "), http.StatusBadRequest)
+		return
+	}
+	for _, e := range req.Events {
+		if !valid[e] {
+			http.Error(w, "Evento desconhecido: "+e, http.StatusBadRequest)
+			return
+		}
+	}
+
+	sampleRate := 1.0
+	if req.SampleRate != nil {
+		sampleRate = *req.SampleRate
+	}
+	if sampleRate < 0 || sampleRate > 1 {
+		http.Error(w, "sample_rate deve estar entre 0 e 1", http.StatusBadRequest)
+		return
+	}
+
+	var count int
+	database.DB.QueryRow("SELECT COUNT(*) FROM webhooks WHERE link_id = ?", req.ID).Scan(&count)
+	if limit := utils.EnvInt("WEBHOOKS_PER_LINK", 5); limit > 0 && count >= limit {
+		http.Error(w, "Limite de webhooks por link atingido", http.StatusTooManyRequests)
+		return
+	}
+
+	whID := "wh_" + utils.GenerateRandomString(8)
+	secret := "whsec_" + utils.GenerateRandomString(24)
+	_, err := database.DB.Exec("INSERT INTO webhooks (id, link_id, url, secret, events, sample_rate, threshold) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		whID, req.ID, req.URL, secret, strings.Join(req.Events, ","), sampleRate, req.Threshold)
+	if err != nil {
+		http.Error(w, "Failed to create resource", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"webhook_id":  whID,
+		"secret":      secret,
+		"url":         req.URL,
+		"events":      req.Events,
+		"sample_rate": sampleRate,
+		"threshold":   req.Threshold,
+		"mensagem":    "Guarde o segredo: ele assina cada entrega em X-Crom-Signature e não será exibido novamente.",
+	})
+}
+
+func deleteLinkWebhook(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID        string `json:"id"`
+		Password  string `json:"password"`
+		WebhookID string `json:"webhook_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid Payload", http.StatusBadRequest)
+		return
+	}
+	if !ownerAuthorized(r, req.ID, req.Password) {
+		http.Error(w, "Unauthorized (Invalid Password)", http.StatusUnauthorized)
+		return
+	}
+
+	res, err := database.DB.Exec("DELETE FROM webhooks WHERE id = ? AND link_id = ?", req.WebhookID, req.ID)
+	if err != nil {
+		http.Error(w, "DB Error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+	// Entregas ainda pendentes deste webhook são canceladas
+	database.DB.Exec("UPDATE webhook_deliveries SET status = 'cancelled' WHERE webhook_id = ? AND status = 'pending'", req.WebhookID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "deleted", "webhook_id": req.WebhookID})
+}
+
+// LinkWebhookDeliveriesHandler consulta o log de entregas dos webhooks do link
+// GET /api/link-webhook-deliveries?id=xxx&password=yyy[&status=pending|delivered|failed][&limit=50]
+func LinkWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, password := credentialFromQuery(r)
+	if id == "" {
+		http.Error(w, "ID missing", http.StatusBadRequest)
+		return
+	}
+	if !ownerAuthorized(r, id, password) {
+		http.Error(w, "Unauthorized (Invalid Password)", http.StatusUnauthorized)
+		return
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 || limit > 500 {
+		limit = 50
+	}
+
+	query := `
+		SELECT id, webhook_id, event, url, status, attempts, response_code, last_error, created_at, next_attempt_at, delivered_at
+		FROM webhook_deliveries WHERE link_id = ?`
+	args := []interface{}{id}
+	if status := r.URL.Query().Get("status"); status != "" {
+		query += " AND status = ?"
+		args = append(args, status)
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := database.DB.Query(query, args...)
+	if err != nil {
+		http.Error(w, "DB Error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	deliveries := []map[string]interface{}{}
+	for rows.Next() {
+		var dID int64
+		var whID, event, whURL, status string
+		var attempts int
+		var code sql.NullInt64
+		var lastErr sql.NullString
+		var createdAt, nextAttempt time.Time
+		var deliveredAt sql.NullTime
+		rows.Scan(&dID, &whID, &event, &whURL, &status, &attempts, &code, &lastErr, &createdAt, &nextAttempt, &deliveredAt)
+
+		d := map[string]interface{}{
+			"delivery_id": dID,
+			"webhook_id":  whID,
+			"event":       event,
+			"url":         whURL,
+			"status":      status,
+			"attempts":    attempts,
+			"created_at":  createdAt,
+		}
+		if code.Valid {
+			d["response_code"] = code.Int64
+		}
+		if lastErr.Valid {
+			d["last_error"] = lastErr.String
+		}
+		if status == "pending" {
+			d["next_attempt_at"] = nextAttempt
+		}
+		if deliveredAt.Valid {
+			d["delivered_at"] = deliveredAt.Time
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"crom-vision/internal/database"
+)
+
+func TestLinkWebhooksHandler_CreateListDelete(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	insertLivePasswordLink(t, "wh1")
+
+	body := `{"id":"wh1","password":"123","url":"https://203.0.113.10/crom","events":["first_view","limit_reached"]}`
+	w := httptest.NewRecorder()
+	LinkWebhooksHandler(w, httptest.NewRequest(http.MethodPost, "/api/link-webhooks", strings.NewReader(body)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("esperava 201, obteve %d: %s", w.Code, w.Body.String())
+	}
+	var created map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &created)
+	whID, _ := created["webhook_id"].(string)
+	if !strings.HasPrefix(whID, "wh_") || !strings.HasPrefix(created["secret"].(string), "whsec_") {
+		t.Fatalf("resposta inesperada: %v", created)
+	}
+
+	w = httptest.NewRecorder()
+	LinkWebhooksHandler(w, httptest.NewRequest(http.MethodGet, "/api/link-webhooks?id=wh1&password=123", nil))
+	if strings.Contains(w.Body.String(), "whsec_") {
+		t.Error("listagem não deve expor o segredo")
+	}
+	var listed []map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &listed)
+	if len(listed) != 1 || listed[0]["webhook_id"] != whID {
+		t.Fatalf("listagem inesperada: %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	LinkWebhooksHandler(w, httptest.NewRequest(http.MethodDelete, "/api/link-webhooks",
+		strings.NewReader(`{"id":"wh1","password":"123","webhook_id":"`+whID+`"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("esperava 200 na remoção, obteve %d", w.Code)
+	}
+	var count int
+	database.DB.QueryRow("SELECT COUNT(*) FROM webhooks WHERE link_id = 'wh1'").Scan(&count)
+	if count != 0 {
+		t.Error("webhook deveria ter sido removido")
+	}
+}
+
+func TestLinkWebhooksHandler_Validation(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	insertLivePasswordLink(t, "wh2")
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"senha errada", `{"id":"wh2","password":"x","url":"https://203.0.113.10","events":["view"]}`, http.StatusUnauthorized},
+		{"url sem esquema", `{"id":"wh2","password":"123","url":"a.com/hook","events":["view"]}`, http.StatusBadRequest},
+		{"evento desconhecido", `{"id":"wh2","password":"123","url":"https://203.0.113.10","events":["clicked"]}`, http.StatusBadRequest},
+		{"sem eventos", `{"id":"wh2","password":"123","url":"https://203.0.113.10","events":[]}`, http.StatusBadRequest},
+		{"loopback", `{"id":"wh2","password":"123","url":"http://127.0.0.1:8080/hook","events":["view"]}`, http.StatusBadRequest},
+		{"localhost", `{"id":"wh2","password":"123","url":"http://localhost/hook","events":["view"]}`, http.StatusBadRequest},
+		{"metadata da nuvem", `{"id":"wh2","password":"123","url":"http://169.254.169.254/latest/meta-data","events":["view"]}`, http.StatusBadRequest},
+		{"rede privada", `{"id":"wh2","password":"123","url":"https://10.0.0.5/hook","events":["view"]}`, http.StatusBadRequest},
+		{"ipv6 loopback", `{"id":"wh2","password":"123","url":"http://[::1]/hook","events":["view"]}`, http.StatusBadRequest},
+		{"não especificado", `{"id":"wh2","password":"123","url":"http://0.0.0.0/hook","events":["view"]}`, http.StatusBadRequest},
+		{"sample_rate fora do intervalo", `{"id":"wh2","password":"123","url":"https://203.0.113.10","events":["view"],"sample_rate":2}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			LinkWebhooksHandler(w, httptest.NewRequest(http.MethodPost, "/api/link-webhooks", strings.NewReader(tt.body)))
+			if w.Code != tt.want {
+				t.Errorf("esperava %d, obteve %d", tt.want, w.Code)
+			}
+		})
+	}
+}
+
+func TestImageHandler_EnqueuesFirstViewWebhook(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	insertLivePasswordLink(t, "wh3")
+	database.DB.Exec("INSERT INTO webhooks (id, link_id, url, secret, events) VALUES ('wh_x', 'wh3', 'https://a.com', 's', 'first_view')")
+
+	ImageHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/i/wh3", nil))
+	// recordHit roda em goroutine
+	time.Sleep(100 * time.Millisecond)
+
+	var event, payload string
+	database.DB.QueryRow("SELECT event, payload FROM webhook_deliveries WHERE link_id = 'wh3'").Scan(&event, &payload)
+	if event != "first_view" || !strings.Contains(payload, `"total_views":1`) {
+		t.Errorf("entrega inesperada: %s %s", event, payload)
+	}
+}
+
+func TestWebhookMPHandler_PaymentApprovedEnqueuedOnce(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	insertTestLink(t, "wh4", "https://crom.run", "pending", "", 0, 0, false)
+	database.DB.Exec("INSERT INTO webhooks (id, link_id, url, secret, events) VALUES ('wh_y', 'wh4', 'https://a.com', 's', 'payment_approved')")
+
+	// IPN repetido (ou IPN + polling) confirmando o mesmo pagamento
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		WebhookMPHandler(w, httptest.NewRequest(http.MethodPost, "/api/webhook/mp", strings.NewReader(`{"link_id":"wh4","status":"approved"}`)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("esperava 200, obteve %d", w.Code)
+		}
+	}
+
+	var count int
+	database.DB.QueryRow("SELECT COUNT(*) FROM webhook_deliveries WHERE link_id = 'wh4' AND event = 'payment_approved'").Scan(&count)
+	if count != 1 {
+		t.Errorf("esperava 1 entrega de payment_approved, obteve %d", count)
+	}
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"crom-vision/internal/database"
+	"crom-vision/internal/utils"
+)
+
+// liveEvent é o payload de cada hit enviado pelo stream SSE
+type liveEvent struct {
+	ID          int64     `json:"id"`
+	Timestamp   time.Time `json:"timestamp"`
+	Country     string    `json:"country"`
+	ClientClass string    `json:"client_class"`
+	Unique      bool      `json:"unique"`
+}
+
+// liveHub distribui os hits recém-gravados para os streams abertos de cada link
+type liveHub struct {
+	mu   sync.RWMutex
+	subs map[string]map[chan liveEvent]struct{}
+	// order serializa a gravação do access_log e a publicação: o ID sai do INSERT
+	order sync.Mutex
+}
+
+var liveHits = &liveHub{subs: make(map[string]map[chan liveEvent]struct{})}
+
+func (h *liveHub) subscribe(linkID string) chan liveEvent {
+	ch := make(chan liveEvent, 32)
+	h.mu.Lock()
+	if h.subs[linkID] == nil {
+		h.subs[linkID] = make(map[chan liveEvent]struct{})
+	}
+	h.subs[linkID][ch] = struct{}{}
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *liveHub) unsubscribe(linkID string, ch chan liveEvent) {
+	h.mu.Lock()
+	delete(h.subs[linkID], ch)
+	if len(h.subs[linkID]) == 0 {
+		delete(h.subs, linkID)
+	}
+	h.mu.Unlock()
+}
+
+// publish nunca bloqueia a ingestão: um assinante lento perde o evento ao vivo
+// e o recupera pelo Last-Event-ID ao reconectar
+func (h *liveHub) publish(linkID string, ev liveEvent) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for ch := range h.subs[linkID] {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// insertAndPublish grava o hit e publica o evento sob o mesmo lock. Como o ID é o do
+// access_log, gravar e publicar juntos garante que os streams recebem os hits na ordem
+// dos IDs, e o filtro de repetidos do stream (ID <= último enviado) nunca descarta um
+// hit novo que chegou atrasado. insert devolve false quando o INSERT falhou.
+func (h *liveHub) insertAndPublish(linkID string, insert func() (liveEvent, bool)) bool {
+	h.order.Lock()
+	defer h.order.Unlock()
+	ev, ok := insert()
+	if ok {
+		h.publish(linkID, ev)
+	}
+	return ok
+}
+
+func writeSSE(w http.ResponseWriter, ev liveEvent) error {
+	payload, _ := json.Marshal(ev)
+	_, err := fmt.Fprintf(w, "id: %d\nevent: hit\ndata: %s\n\n", ev.ID, payload)
+	return err
+}
+
+// LinkStreamHandler envia cada novo hit do link via Server-Sent Events.
+// GET /api/link-stream?id=xxx&password=yyy (mesma Senha Mágica do relatório privado;
+// EventSource não envia headers customizados, por isso a senha vai na query ou em X-Crom-Password)
+// Reconexões com Last-Event-ID (header ou ?last_event_id=) recebem os hits perdidos.
+func LinkStreamHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "ID missing", http.StatusBadRequest)
+		return
+	}
+
+	password := r.URL.Query().Get("password")
+	if password == "" {
+		password = r.Header.Get("X-Crom-Password")
+	}
+	if !ownerAuthorized(r, id, password) {
+		http.Error(w, "Unauthorized (Invalid Password)", http.StatusUnauthorized)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming não suportado", http.StatusInternalServerError)
+		return
+	}
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+	resumeFrom, _ := strconv.ParseInt(lastID, 10, 64)
+
+	// Assina antes do replay para não perder hits gravados no meio do caminho
+	ch := liveHits.subscribe(id)
+	defer liveHits.unsubscribe(id, ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // NGINX não deve bufferizar o stream
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: 3000\n\n")
+
+	sent := resumeFrom
+	if resumeFrom > 0 {
+		rows, err := database.DB.Query(`
+			SELECT id, accessed_at, COALESCE(country, ''), COALESCE(client_class, ''), COALESCE(is_unique, 0)
+			FROM access_logs
+			WHERE link_id = ? AND id > ? AND status = 'served'
+			ORDER BY id ASC LIMIT 1000`, id, resumeFrom)
+		if err == nil {
+			for rows.Next() {
+				var ev liveEvent
+				if rows.Scan(&ev.ID, &ev.Timestamp, &ev.Country, &ev.ClientClass, &ev.Unique) == nil {
+					writeSSE(w, ev)
+					sent = ev.ID
+				}
+			}
+			rows.Close()
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.Duration(utils.EnvInt("SSE_HEARTBEAT_SECONDS", 15)) * time.Second
+	if heartbeat <= 0 {
+		heartbeat = 15 * time.Second
+	}
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			if _, err := fmt.Fprintf(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case ev := <-ch:
+			// Já enviado pelo replay do Last-Event-ID (os eventos ao vivo chegam em ordem de ID)
+			if ev.ID <= sent {
+				continue
+			}
+			if err := writeSSE(w, ev); err != nil {
+				return
+			}
+			sent = ev.ID
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"crom-vision/internal/database"
+)
+
+// readSSEEvent lê linhas do stream até completar um evento "hit"
+func readSSEEvent(reader *bufio.Reader) (string, liveEvent, error) {
+	var id string
+	var ev liveEvent
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return "", ev, err
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev)
+		case line == "" && id != "":
+			return id, ev, nil
+		}
+	}
+}
+
+func insertLivePasswordLink(t *testing.T, id string) {
+	t.Helper()
+	insertTestLink(t, id, "https://crom.run", "approved", "", 0, 0, false)
+	// sha256("123")
+	database.DB.Exec("UPDATE links SET password_hash = 'a665a45920422f9d417e4867efdc4fb8a04a1f3fff1fa07e998e86f7f7a27ae3' WHERE id = ?", id)
+}
+
+func TestLinkStreamHandler_Unauthorized(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	insertLivePasswordLink(t, "live1")
+
+	req := httptest.NewRequest(http.MethodGet, "/api/link-stream?id=live1&password=errada", nil)
+	w := httptest.NewRecorder()
+	LinkStreamHandler(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("esperava 401, obteve %d", w.Code)
+	}
+}
+
+func TestLinkStreamHandler_PushesNewHits(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	insertLivePasswordLink(t, "live2")
+
+	srv := httptest.NewServer(http.HandlerFunc(LinkStreamHandler))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "?id=live2&password=123")
+	if err != nil {
+		t.Fatalf("falha ao abrir stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type inesperado: %s", ct)
+	}
+
+	// Aguarda o assinante registrar antes de gerar o hit
+	time.Sleep(100 * time.Millisecond)
+	req := httptest.NewRequest(http.MethodGet, "/i/live2", nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0) Mobile")
+	ImageHandler(httptest.NewRecorder(), req)
+
+	done := make(chan liveEvent, 1)
+	go func() {
+		if _, ev, err := readSSEEvent(bufio.NewReader(resp.Body)); err == nil {
+			done <- ev
+		}
+	}()
+
+	select {
+	case ev := <-done:
+		if ev.ClientClass != "mobile" || !ev.Unique || ev.Timestamp.IsZero() {
+			t.Errorf("evento inesperado: %+v", ev)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("nenhum evento recebido pelo stream")
+	}
+}
+
+func TestLinkStreamHandler_ResumeWithLastEventID(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	insertLivePasswordLink(t, "live3")
+
+	for _, c := range []string{"BR", "US", "PT"} {
+		database.DB.Exec("INSERT INTO access_logs (link_id, ip_hash, country, client_class) VALUES ('live3', 'h', ?, 'desktop')", c)
+	}
+	var firstID int64
+	database.DB.QueryRow("SELECT MIN(id) FROM access_logs WHERE link_id = 'live3'").Scan(&firstID)
+
+	srv := httptest.NewServer(http.HandlerFunc(LinkStreamHandler))
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"?id=live3&password=123", nil)
+	req.Header.Set("Last-Event-ID", strconv.FormatInt(firstID, 10))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("falha ao abrir stream: %v", err)
+	}
+	defer resp.Body.Close()
+
+	reader := bufio.NewReader(resp.Body)
+	_, ev1, err1 := readSSEEvent(reader)
+	_, ev2, err2 := readSSEEvent(reader)
+	if err1 != nil || err2 != nil {
+		t.Fatalf("stream encerrado antes dos eventos: %v %v", err1, err2)
+	}
+	if ev1.Country != "US" || ev2.Country != "PT" {
+		t.Errorf("replay deveria enviar apenas os hits após o Last-Event-ID, obteve %s e %s", ev1.Country, ev2.Country)
+	}
+}
+
+func TestLiveHub_ConcurrentPublishKeepsIDOrder(t *testing.T) {
+	hub := &liveHub{subs: make(map[string]map[chan liveEvent]struct{})}
+	ch := hub.subscribe("ord")
+	defer hub.unsubscribe("ord", ch)
+
+	// Cada "INSERT" recebe o próximo ID e demora um tempo variável antes de devolver,
+	// como goroutines de recordHit concorrentes
+	const hits = 30
+	var mu sync.Mutex
+	var next int64
+	var wg sync.WaitGroup
+	for i := 0; i < hits; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			hub.insertAndPublish("ord", func() (liveEvent, bool) {
+				mu.Lock()
+				next++
+				id := next
+				mu.Unlock()
+				time.Sleep(time.Duration((hits-i)%4) * time.Millisecond)
+				return liveEvent{ID: id}, true
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	var last int64
+	for i := 0; i < hits; i++ {
+		select {
+		case ev := <-ch:
+			if ev.ID <= last {
+				t.Fatalf("evento %d chegou depois do %d: o stream o descartaria", ev.ID, last)
+			}
+			last = ev.ID
+		default:
+			t.Fatalf("esperava %d eventos, recebeu %d", hits, i)
+		}
+	}
+}
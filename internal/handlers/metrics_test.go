@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"crom-vision/internal/metrics"
+)
+
+func TestMetricsHandler_Token(t *testing.T) {
+	os.Setenv("METRICS_TOKEN", "segredo")
+	defer os.Unsetenv("METRICS_TOKEN")
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	w := httptest.NewRecorder()
+	MetricsHandler(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("sem token deveria dar 401, obteve %d", w.Code)
+	}
+
+	req.Header.Set("Authorization", "Bearer segredo")
+	w = httptest.NewRecorder()
+	MetricsHandler(w, req)
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("com token deveria expor as métricas: %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	for _, name := range []string{"crom_http_requests_total", "crom_ingest_queue_depth", "crom_storage_bytes", "crom_cleanup_last_run_timestamp_seconds"} {
+		if !strings.Contains(w.Body.String(), "# TYPE "+name+" ") {
+			t.Errorf("métrica %s ausente", name)
+		}
+	}
+}
+
+func TestImageHandler_CountsPixelOutcome(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	insertTestLink(t, "mt1", "", "pending", "", 0, 0, false)
+
+	before := metrics.PixelHits.Value("Payment-Pending")
+	beforeMissing := metrics.PixelHits.Value("Not-Found")
+	ImageHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/i/mt1", nil))
+	ImageHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/i/nao-existe", nil))
+
+	if metrics.PixelHits.Value("Payment-Pending")-before != 1 || metrics.PixelHits.Value("Not-Found")-beforeMissing != 1 {
+		t.Error("desfechos do pixel deveriam ser contados pelo X-Crom-Status")
+	}
+}
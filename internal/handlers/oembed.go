@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"crom-vision/internal/database"
+	"crom-vision/internal/utils"
+)
+
+// oembedResponse segue a especificação oEmbed 1.0 para o tipo "photo"
+type oembedResponse struct {
+	XMLName      xml.Name `json:"-" xml:"oembed"`
+	Version      string   `json:"version" xml:"version"`
+	Type         string   `json:"type" xml:"type"`
+	URL          string   `json:"url" xml:"url"`
+	Width        int      `json:"width" xml:"width"`
+	Height       int      `json:"height" xml:"height"`
+	Title        string   `json:"title,omitempty" xml:"title,omitempty"`
+	ProviderName string   `json:"provider_name" xml:"provider_name"`
+	ProviderURL  string   `json:"provider_url" xml:"provider_url"`
+	CacheAge     int      `json:"cache_age" xml:"cache_age"`
+}
+
+// linkImageDimensions devolve as dimensões gravadas no upload; links antigos
+// sem o dado são medidos a partir do arquivo e atualizados na hora
+func linkImageDimensions(id, filePath string, width, height sql.NullInt64) (int, int) {
+	if width.Valid && height.Valid && width.Int64 > 0 && height.Int64 > 0 {
+		return int(width.Int64), int(height.Int64)
+	}
+	if filePath == "" {
+		return 0, 0
+	}
+	w, h, err := utils.ImageDimensions(filePath)
+	if err != nil {
+		return 0, 0
+	}
+	database.DB.Exec("UPDATE links SET image_width = ?, image_height = ? WHERE id = ?", w, h, id)
+	return w, h
+}
+
+// parseAssetURL extrai o tipo de rota (/p/ ou /i/) e o ID de uma URL deste servidor
+func parseAssetURL(raw string) (string, string, bool) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || u.Host == "" {
+		return "", "", false
+	}
+	base, err := url.Parse(publicBaseURL())
+	if err != nil || !strings.EqualFold(u.Host, base.Host) {
+		return "", "", false
+	}
+	for _, prefix := range []string{"/p/", "/i/"} {
+		if strings.HasPrefix(u.Path, prefix) {
+			id := strings.Trim(u.Path[len(prefix):], "/")
+			if id != "" && !strings.Contains(id, "/") {
+				return prefix, id, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// OEmbedHandler implementa o provedor oEmbed para as imagens acompanhadas.
+// GET /oembed?url=<BASE_URL>/p/:id|/i/:id[&format=json|xml][&maxwidth=N][&maxheight=N]
+// URLs /p/ apontam para a versão redimensionada (/p/:id?w=&h=); URLs /i/ mantêm o
+// pixel rastreado e apenas reduzem width/height para o consumidor escalar a imagem.
+func OEmbedHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	format := q.Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "xml" {
+		http.Error(w, "Not Implemented (format)", http.StatusNotImplemented)
+		return
+	}
+
+	route, id, ok := parseAssetURL(q.Get("url"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	var filePath, title sql.NullString
+	var width, height sql.NullInt64
+	var isPrivate bool
+	var paymentStatus string
+	err := database.DB.QueryRow(`
+		SELECT file_path, title, image_width, image_height, is_private, payment_status
+		FROM links WHERE id = ?`, id).Scan(&filePath, &title, &width, &height, &isPrivate, &paymentStatus)
+	if err != nil || paymentStatus != "approved" {
+		http.NotFound(w, r)
+		return
+	}
+	if isPrivate {
+		http.Error(w, "Private Asset", http.StatusUnauthorized)
+		return
+	}
+
+	imgW, imgH := linkImageDimensions(id, filePath.String, width, height)
+	if imgW == 0 || imgH == 0 {
+		// Sem imagem própria (ex: só redirect) não há como descrever uma "photo"
+		http.NotFound(w, r)
+		return
+	}
+
+	maxW, _ := strconv.Atoi(q.Get("maxwidth"))
+	maxH, _ := strconv.Atoi(q.Get("maxheight"))
+	if route == "/p/" {
+		// A versão redimensionada só existe nos tamanhos de utils.RenditionSizes
+		maxW, maxH = utils.SnapRenditionSide(maxW), utils.SnapRenditionSide(maxH)
+	}
+	fitW, fitH := utils.FitDimensions(imgW, imgH, maxW, maxH)
+
+	baseURL := publicBaseURL()
+	resp := oembedResponse{
+		Version:      "1.0",
+		Type:         "photo",
+		Width:        fitW,
+		Height:       fitH,
+		Title:        title.String,
+		ProviderName: "Crom-Vision",
+		ProviderURL:  baseURL,
+		CacheAge:     3600,
+	}
+	if route == "/i/" {
+		resp.URL = baseURL + "/i/" + id
+	} else if fitW != imgW || fitH != imgH {
+		resp.URL = renditionURL(baseURL, id, maxW, maxH)
+	} else {
+		resp.URL = baseURL + "/p/" + id + "?view=image"
+	}
+
+	if format == "xml" {
+		w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+		w.Write([]byte(xml.Header))
+		xml.NewEncoder(w).Encode(resp)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// renditionURL monta /p/:id?w=&h= só com os eixos limitados
+func renditionURL(baseURL, id string, maxW, maxH int) string {
+	params := url.Values{}
+	if maxW > 0 {
+		params.Set("w", strconv.Itoa(maxW))
+	}
+	if maxH > 0 {
+		params.Set("h", strconv.Itoa(maxH))
+	}
+	return baseURL + "/p/" + id + "?" + params.Encode()
+}
+
+// serveRendition atende /p/:id?w=&h= com uma versão reduzida da imagem. Os lados são
+// arredondados para utils.RenditionSizes e a versão fica em cache em disco; origens acima
+// de RENDITION_MAX_SOURCE_PIXELS nem são decodificadas.
+// Retorna false quando não há parâmetros de tamanho ou a imagem não pode ser redimensionada.
+func serveRendition(w http.ResponseWriter, r *http.Request, filePath string) bool {
+	maxW, _ := strconv.Atoi(r.URL.Query().Get("w"))
+	maxH, _ := strconv.Atoi(r.URL.Query().Get("h"))
+	maxW, maxH = utils.SnapRenditionSide(maxW), utils.SnapRenditionSide(maxH)
+	if maxW <= 0 && maxH <= 0 {
+		return false
+	}
+
+	maxPixels := utils.EnvInt("RENDITION_MAX_SOURCE_PIXELS", utils.DefaultRenditionSourcePixels)
+	data, contentType, err := utils.CachedRendition(filePath, maxW, maxH, maxPixels)
+	if err != nil {
+		// Inclui utils.ErrImageTooLarge: a origem segue servida como arquivo, sem decodificar
+		return false
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Write(data)
+	return true
+}
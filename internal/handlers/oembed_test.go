@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"crom-vision/internal/database"
+	"crom-vision/internal/utils"
+)
+
+func insertOEmbedLink(t *testing.T, id string, private bool) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "img.png")
+	f, _ := os.Create(path)
+	png.Encode(f, image.NewNRGBA(image.Rect(0, 0, 800, 600)))
+	f.Close()
+	insertTestLink(t, id, "", "approved", path, 0, 0, private)
+	database.DB.Exec("UPDATE links SET title = 'Meu gráfico' WHERE id = ?", id)
+}
+
+func oembedRequest(target string, params url.Values) *httptest.ResponseRecorder {
+	params.Set("url", target)
+	w := httptest.NewRecorder()
+	OEmbedHandler(w, httptest.NewRequest(http.MethodGet, "/oembed?"+params.Encode(), nil))
+	return w
+}
+
+func TestOEmbedHandler_JSON(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	insertOEmbedLink(t, "oe1", false)
+
+	w := oembedRequest("http://test.local/p/oe1", url.Values{})
+	if w.Code != http.StatusOK {
+		t.Fatalf("esperava 200, obteve %d: %s", w.Code, w.Body.String())
+	}
+	var resp oembedResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.Type != "photo" || resp.Version != "1.0" || resp.Width != 800 || resp.Height != 600 || resp.Title != "Meu gráfico" {
+		t.Errorf("resposta inesperada: %+v", resp)
+	}
+
+	// Dimensões medidas na primeira consulta ficam gravadas
+	var width int
+	database.DB.QueryRow("SELECT image_width FROM links WHERE id = 'oe1'").Scan(&width)
+	if width != 800 {
+		t.Errorf("image_width deveria ser preenchido, obteve %d", width)
+	}
+}
+
+func TestOEmbedHandler_MaxWidthPointsToRendition(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	insertOEmbedLink(t, "oe2", false)
+
+	w := oembedRequest("http://test.local/p/oe2", url.Values{"format": {"xml"}, "maxwidth": {"400"}})
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/xml") {
+		t.Fatalf("Content-Type inesperado: %s", w.Header().Get("Content-Type"))
+	}
+	var resp oembedResponse
+	if err := xml.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("XML inválido: %v", err)
+	}
+	if resp.Width != 400 || resp.Height != 300 || resp.URL != "http://test.local/p/oe2?w=400" {
+		t.Errorf("resposta inesperada: %+v", resp)
+	}
+
+	rec := httptest.NewRecorder()
+	PreviewHandler(rec, httptest.NewRequest(http.MethodGet, "/p/oe2?w=400", nil))
+	img, err := png.Decode(rec.Body)
+	if err != nil || img.Bounds().Dx() != 400 {
+		t.Errorf("rendition deveria ter 400px de largura: %v", err)
+	}
+
+	// Lados fora de utils.RenditionSizes caem no tamanho aceito logo abaixo
+	w = oembedRequest("http://test.local/p/oe2", url.Values{"maxwidth": {"450"}})
+	var snapped oembedResponse
+	json.Unmarshal(w.Body.Bytes(), &snapped)
+	if snapped.Width != 400 || snapped.URL != "http://test.local/p/oe2?w=400" {
+		t.Errorf("maxwidth=450 deveria apontar para a versão de 400px: %+v", snapped)
+	}
+	rec = httptest.NewRecorder()
+	PreviewHandler(rec, httptest.NewRequest(http.MethodGet, "/p/oe2?w=450&h=9999", nil))
+	if img, err := png.Decode(rec.Body); err != nil || img.Bounds().Dx() != 400 {
+		t.Errorf("?w=450 deveria servir a versão de 400px: %v", err)
+	}
+}
+
+func TestPreviewHandler_RenditionPixelBudget(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	insertOEmbedLink(t, "oe5", false)
+	t.Setenv("RENDITION_MAX_SOURCE_PIXELS", "1000")
+
+	// Acima do orçamento a origem não é decodificada: serve o arquivo original
+	rec := httptest.NewRecorder()
+	PreviewHandler(rec, httptest.NewRequest(http.MethodGet, "/p/oe5?w=64", nil))
+	img, err := png.Decode(rec.Body)
+	if err != nil || img.Bounds().Dx() != 800 {
+		t.Errorf("esperava o original de 800px, obteve %v", err)
+	}
+	if cached, _ := filepath.Glob(filepath.Join(utils.RenditionCacheDir(), "*")); len(cached) != 0 {
+		t.Errorf("nenhuma versão deveria ter sido gerada: %v", cached)
+	}
+}
+
+func TestOEmbedHandler_PixelKeepsTrackedURL(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	insertOEmbedLink(t, "oe3", false)
+
+	var resp oembedResponse
+	json.Unmarshal(oembedRequest("http://test.local/i/oe3", url.Values{"maxheight": {"150"}}).Body.Bytes(), &resp)
+	if resp.URL != "http://test.local/i/oe3" || resp.Width != 200 || resp.Height != 150 {
+		t.Errorf("resposta inesperada: %+v", resp)
+	}
+}
+
+func TestOEmbedHandler_Errors(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	insertOEmbedLink(t, "oe4", true)
+
+	tests := []struct {
+		name   string
+		target string
+		params url.Values
+		want   int
+	}{
+		{"outro domínio", "http://evil.example/p/oe4", url.Values{}, http.StatusNotFound},
+		{"link inexistente", "http://test.local/p/nada", url.Values{}, http.StatusNotFound},
+		{"link privado", "http://test.local/p/oe4", url.Values{}, http.StatusUnauthorized},
+		{"formato não suportado", "http://test.local/p/oe4", url.Values{"format": {"yaml"}}, http.StatusNotImplemented},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := oembedRequest(tt.target, tt.params); w.Code != tt.want {
+				t.Errorf("esperava %d, obteve %d", tt.want, w.Code)
+			}
+		})
+	}
+}
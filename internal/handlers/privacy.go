@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"crom-vision/internal/database"
+	"crom-vision/internal/services"
+	"crom-vision/internal/utils"
+)
+
+// otherLabel é o grupo que recebe as linhas pequenas demais dos detalhamentos
+const otherLabel = "Other"
+
+// kAnonymity protege os detalhamentos (país, região, cidade, cliente) contra a
+// identificação de um leitor isolado: grupos com menos de MinGroup visitantes são
+// somados em "Other" e, em painéis públicos, as contagens são arredondadas para
+// múltiplos de RoundTo.
+type kAnonymity struct {
+	MinGroup int `json:"min_group"`
+	RoundTo  int `json:"round_to"`
+}
+
+// loadKAnonymity lê K_ANON_MIN_GROUP e K_ANON_ROUND_TO; o arredondamento só vale para
+// quem não é o dono do link (public = requisição sem a Senha Mágica nem a sessão do dono)
+func loadKAnonymity(public bool) kAnonymity {
+	k := kAnonymity{MinGroup: utils.EnvInt("K_ANON_MIN_GROUP", 5)}
+	if public {
+		k.RoundTo = utils.EnvInt("K_ANON_ROUND_TO", 0)
+	}
+	if k.MinGroup < 0 {
+		k.MinGroup = 0
+	}
+	if k.RoundTo < 0 {
+		k.RoundTo = 0
+	}
+	return k
+}
+
+// Agrupamentos dos detalhamentos, os mesmos nos rollups e nos logs brutos
+const (
+	countryGroup = "COALESCE(NULLIF(country, ''), 'Unknown')"
+	clientGroup  = "COALESCE(NULLIF(client_class, ''), 'unknown')"
+)
+
+// distinctVisitors conta os ip_hash distintos de cada grupo nos logs brutos a partir de from
+// (e antes de to, quando informado), nunca antes do início da retenção. É a base do
+// k-anonimato: os uniques dos rollups contam um visitante por hora ou por dia, e um leitor
+// que volta várias vezes passaria pelo limite sozinho. Para o histórico além da retenção,
+// veja groupVisitors.
+func distinctVisitors(linkID, group string, from, to time.Time) (map[string]int, error) {
+	if start := logRetentionStart(time.Now()); from.Before(start) {
+		from = start
+	}
+	query := "SELECT " + group + ", COUNT(DISTINCT ip_hash) FROM access_logs WHERE link_id = ? AND status = 'served' AND accessed_at >= ?"
+	args := []interface{}{linkID, from.UTC().Format(sqliteTimeFormat)}
+	if !to.IsZero() {
+		query += " AND accessed_at < ?"
+		args = append(args, to.UTC().Format(sqliteTimeFormat))
+	}
+	rows, err := database.DB.Query(query+" GROUP BY "+group, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	visitors := map[string]int{}
+	for rows.Next() {
+		var g string
+		var n int
+		if err := rows.Scan(&g, &n); err != nil {
+			return nil, err
+		}
+		visitors[g] = n
+	}
+	return visitors, rows.Err()
+}
+
+// unknownLabels dá o rótulo do valor vazio de cada dimensão de stats_visitors, o mesmo
+// de countryGroup e clientGroup
+var unknownLabels = map[string]string{
+	services.VisitorsByCountry: "Unknown",
+	services.VisitorsByClient:  "unknown",
+}
+
+// groupVisitors devolve os visitantes distintos de cada grupo que decidem o k-anonimato.
+// Dentro da retenção vale distinctVisitors; quando o período começa antes dela (from zero
+// = histórico inteiro), entra também a contagem acumulada de stats_visitors, que sobrevive
+// à limpeza dos logs, e cada grupo fica com o maior dos dois valores. A contagem acumulada
+// é do histórico inteiro, então em recortes menores quem chama a limita pelas views do grupo.
+func groupVisitors(linkID, dimension, group string, from, to time.Time) (map[string]int, error) {
+	visitors, err := distinctVisitors(linkID, group, from, to)
+	if err != nil || !from.Before(logRetentionStart(time.Now())) {
+		return visitors, err
+	}
+	rows, err := database.DB.Query(`
+		SELECT COALESCE(NULLIF(value, ''), ?) AS g, SUM(visitors) FROM stats_visitors
+		WHERE link_id = ? AND dimension = ? GROUP BY g`, unknownLabels[dimension], linkID, dimension)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var g string
+		var n int
+		if err := rows.Scan(&g, &n); err != nil {
+			return nil, err
+		}
+		if n > visitors[g] {
+			visitors[g] = n
+		}
+	}
+	return visitors, rows.Err()
+}
+
+// isPublicRequest indica se a consulta chegou sem a credencial do dono do link (senha ou sessão)
+func isPublicRequest(r *http.Request) bool {
+	id, password := credentialFromQuery(r)
+	return !ownerAuthorized(r, id, password)
+}
+
+// hides indica se um grupo com esse número de visitantes deve ir para "Other";
+// com MinGroup 0 ou 1 o k-anonimato está desligado e nenhum grupo é escondido
+func (k kAnonymity) hides(visitors int) bool {
+	return k.MinGroup > 1 && visitors < k.MinGroup
+}
+
+// round arredonda para o múltiplo mais próximo de RoundTo; contagens positivas
+// nunca viram zero, para o grupo não sumir do gráfico
+func (k kAnonymity) round(n int) int {
+	if k.RoundTo <= 1 || n <= 0 {
+		return n
+	}
+	r := (n + k.RoundTo/2) / k.RoundTo * k.RoundTo
+	if r == 0 {
+		r = k.RoundTo
+	}
+	return r
+}
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"crom-vision/internal/database"
+	"crom-vision/internal/services"
+)
+
+func TestKAnonymityRound(t *testing.T) {
+	k := kAnonymity{RoundTo: 5}
+	for in, want := range map[int]int{0: 0, 1: 5, 2: 5, 3: 5, 7: 5, 8: 10, 12: 10} {
+		if got := k.round(in); got != want {
+			t.Errorf("round(%d): esperava %d, obteve %d", in, want, got)
+		}
+	}
+	if got := (kAnonymity{}).round(7); got != 7 {
+		t.Errorf("sem RoundTo a contagem deveria ser exata, obteve %d", got)
+	}
+}
+
+func TestLinkGeoHandler_KAnonymity(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	t.Setenv("K_ANON_MIN_GROUP", "3")
+	t.Setenv("K_ANON_ROUND_TO", "5")
+	insertLivePasswordLink(t, "kgeo")
+
+	// 6 visitantes em São Paulo, 2 em Nova York e 1 em Berlim
+	cities := []struct {
+		country, region, city string
+		visitors              int
+	}{
+		{"BR", "São Paulo", "São Paulo", 6},
+		{"US", "New York", "New York", 2},
+		{"DE", "Berlin", "Berlin", 1},
+	}
+	for _, c := range cities {
+		for i := 0; i < c.visitors; i++ {
+			database.DB.Exec("INSERT INTO access_logs (link_id, ip_hash, country, region, city, status) VALUES ('kgeo', ?, ?, ?, ?, 'served')",
+				fmt.Sprintf("%s-%d", c.city, i), c.country, c.region, c.city)
+		}
+	}
+
+	get := func(query string) []geoEntry {
+		w := httptest.NewRecorder()
+		LinkGeoHandler(w, httptest.NewRequest(http.MethodGet, "/api/link-geo?id=kgeo&level=city&format=json"+query, nil))
+		var resp geoJSONResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp.Items
+	}
+
+	// Quem recebe um share token vê as contagens arredondadas
+	_, token := createShare(t, `{"id":"kgeo","password":"123","scopes":["geo"]}`)
+	public := get("&share=" + token)
+	want := []geoEntry{
+		{Country: "BR", Region: "São Paulo", City: "São Paulo", Views: 5},
+		{Country: otherLabel, Region: otherLabel, City: otherLabel, Views: 5},
+	}
+	if len(public) != len(want) || public[0] != want[0] || public[1] != want[1] {
+		t.Errorf("painel público: esperava %+v, obteve %+v", want, public)
+	}
+
+	// O dono vê as contagens exatas, mas os grupos pequenos continuam em "Other"
+	owner := get("&password=123")
+	if len(owner) != 2 || owner[0].Views != 6 || owner[1].City != otherLabel || owner[1].Views != 3 {
+		t.Errorf("dono: esperava São Paulo 6 e Other 3, obteve %+v", owner)
+	}
+}
+
+func TestLinkClientsHandler_KAnonymity(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	t.Setenv("K_ANON_MIN_GROUP", "2")
+	insertLivePasswordLink(t, "kcli")
+
+	at := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 4; i++ {
+		services.RecordRollup("kcli", at, "BR", "desktop", true, false)
+	}
+	services.RecordRollup("kcli", at, "BR", "mobile", true, false)
+	services.RecordRollup("kcli", at, "BR", "mobile", false, false)
+	// O limite olha os visitantes distintos dos logs retidos: 4 no desktop, 1 no mobile
+	for i := 0; i < 4; i++ {
+		database.DB.Exec("INSERT INTO access_logs (link_id, ip_hash, client_class, status) VALUES ('kcli', ?, 'desktop', 'served')", fmt.Sprintf("d-%d", i))
+	}
+	database.DB.Exec("INSERT INTO access_logs (link_id, ip_hash, client_class, status) VALUES ('kcli', 'm-0', 'mobile', 'served')")
+
+	w := httptest.NewRecorder()
+	LinkClientsHandler(w, httptest.NewRequest(http.MethodGet, "/api/link-clients?id=kcli&password=123", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("esperava 200, obteve %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Items []clientEntry `json:"items"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+
+	want := []clientEntry{
+		{Client: "desktop", Views: 4, Unique: 4},
+		{Client: otherLabel, Views: 2, Unique: 1},
+	}
+	if len(resp.Items) != len(want) || resp.Items[0] != want[0] || resp.Items[1] != want[1] {
+		t.Errorf("esperava %+v, obteve %+v", want, resp.Items)
+	}
+}
+
+func TestLinkGeoHandler_KAnonymityCountsDistinctVisitors(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	t.Setenv("K_ANON_MIN_GROUP", "3")
+	insertLivePasswordLink(t, "kret")
+
+	// Um único leitor no Brasil que volta a cada hora soma 6 uniques no rollup diário
+	now := time.Now().UTC()
+	for h := 0; h < 6; h++ {
+		at := now.Add(-time.Duration(h) * time.Hour)
+		services.RecordRollup("kret", at, "BR", "desktop", true, h > 0)
+		database.DB.Exec("INSERT INTO access_logs (link_id, ip_hash, country, status, accessed_at) VALUES ('kret', 'sozinho', 'BR', 'served', ?)",
+			at.Format(sqliteTimeFormat))
+	}
+	// Nos EUA só há o rollup, sem logs nem stats_visitors: não há prova de visitantes distintos
+	for i := 0; i < 5; i++ {
+		services.RecordRollup("kret", now.AddDate(-1, 0, 0), "US", "desktop", true, false)
+	}
+	// Na Alemanha, 3 visitantes distintos nos logs retidos
+	for i := 0; i < 3; i++ {
+		services.RecordRollup("kret", now, "DE", "desktop", true, false)
+		database.DB.Exec("INSERT INTO access_logs (link_id, ip_hash, country, status) VALUES ('kret', ?, 'DE', 'served')", fmt.Sprintf("de-%d", i))
+	}
+
+	w := httptest.NewRecorder()
+	LinkGeoHandler(w, httptest.NewRequest(http.MethodGet, "/api/link-geo?id=kret&level=country&format=json&password=123", nil))
+	var resp geoJSONResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+
+	want := []geoEntry{
+		{Country: "DE", Views: 3},
+		{Country: otherLabel, Views: 11},
+	}
+	if len(resp.Items) != len(want) || resp.Items[0] != want[0] || resp.Items[1] != want[1] {
+		t.Errorf("esperava %+v, obteve %+v", want, resp.Items)
+	}
+}
+
+func TestLinkGeoHandler_KAnonymitySurvivesLogRetention(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	t.Setenv("K_ANON_MIN_GROUP", "3")
+	insertLivePasswordLink(t, "kold")
+
+	// 4 leitores no Brasil (um deles volta 3 vezes) e 1 nos EUA
+	hit := func(fp, country string, unique bool) {
+		recordHit(accessHit{LinkID: "kold", FingerprintHash: fp, Country: country, ClientClass: "desktop", Unique: unique})
+	}
+	for i := 0; i < 4; i++ {
+		hit(fmt.Sprintf("br-%d", i), "BR", true)
+	}
+	for i := 0; i < 3; i++ {
+		hit("br-0", "BR", false)
+	}
+	hit("us-0", "US", true)
+	hit("us-0", "US", false)
+
+	// A limpeza de LOG_RETENTION_DAYS apagou todos os logs brutos; só restam os rollups
+	database.DB.Exec("DELETE FROM access_logs WHERE link_id = 'kold'")
+
+	w := httptest.NewRecorder()
+	LinkGeoHandler(w, httptest.NewRequest(http.MethodGet, "/api/link-geo?id=kold&level=country&format=json&password=123", nil))
+	var resp geoJSONResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	want := []geoEntry{
+		{Country: "BR", Views: 7},
+		{Country: otherLabel, Views: 2},
+	}
+	if len(resp.Items) != len(want) || resp.Items[0] != want[0] || resp.Items[1] != want[1] {
+		t.Errorf("esperava %+v, obteve %+v", want, resp.Items)
+	}
+
+	w = httptest.NewRecorder()
+	LinkClientsHandler(w, httptest.NewRequest(http.MethodGet, "/api/link-clients?id=kold&password=123", nil))
+	var clients struct {
+		Items []clientEntry `json:"items"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &clients)
+	if len(clients.Items) != 1 || clients.Items[0].Client != "desktop" || clients.Items[0].Views != 9 {
+		t.Errorf("os 5 leitores desktop deveriam continuar visíveis depois da retenção, obteve %+v", clients.Items)
+	}
+}
@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"crom-vision/internal/database"
+	"crom-vision/internal/utils"
+)
+
+// Escopos de um share token: cada um libera um endpoint de leitura das estatísticas
+const (
+	scopeStats    = "stats"     // GET /api/link-stats
+	scopeGeo      = "geo"       // GET /api/link-geo
+	scopeClients  = "clients"   // GET /api/link-clients
+	scopeHeatmap  = "heatmap"   // GET /api/heatmap?id=
+	scopeSummary  = "summary"   // POST /api/private-stats
+	scopeReadTime = "read_time" // GET /api/link-read-time
+	scopeVariants = "variants"  // GET /api/link-variants
+)
+
+// shareScopes são os escopos aceitos (e o padrão quando o dono não informa nenhum)
+var shareScopes = []string{scopeStats, scopeGeo, scopeClients, scopeHeatmap, scopeSummary, scopeReadTime, scopeVariants}
+
+// maxShareTokensPerLink limita os tokens ativos de um link
+const maxShareTokensPerLink = 20
+
+// shareTokenFromRequest lê o token de compartilhamento (query ?share= ou header X-Crom-Share)
+func shareTokenFromRequest(r *http.Request) string {
+	if token := r.URL.Query().Get("share"); token != "" {
+		return token
+	}
+	return r.Header.Get("X-Crom-Share")
+}
+
+// shareTokenAllows valida o token (não revogado, não expirado, do próprio link) e o escopo pedido
+func shareTokenAllows(linkID, token, scope string) bool {
+	if linkID == "" || token == "" {
+		return false
+	}
+	var shareID, scopes string
+	err := database.DB.QueryRow(`
+		SELECT id, scopes FROM share_tokens
+		WHERE token_hash = ? AND link_id = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)`,
+		hashPassword(token), linkID, time.Now().UTC().Format(sqliteTimeFormat)).Scan(&shareID, &scopes)
+	if err != nil {
+		return false
+	}
+	for _, s := range strings.Split(scopes, ",") {
+		if s == scope {
+			database.DB.Exec("UPDATE share_tokens SET last_used_at = ? WHERE id = ?", time.Now().UTC().Format(sqliteTimeFormat), shareID)
+			return true
+		}
+	}
+	return false
+}
+
+// authorizeStats libera a leitura das estatísticas do link só para o dono (Senha Mágica ou
+// sessão) ou para um share token com o escopo pedido. Ser da vitrine pública (is_private = 0)
+// não abre os detalhamentos; a vitrine usa o agregado de /api/public-stats.
+// Escreve o erro e devolve false quando o acesso é negado.
+func authorizeStats(w http.ResponseWriter, r *http.Request, linkID, scope string) bool {
+	if _, password := credentialFromQuery(r); ownerAuthorized(r, linkID, password) {
+		return true
+	}
+	if token := shareTokenFromRequest(r); token != "" {
+		if shareTokenAllows(linkID, token, scope) {
+			return true
+		}
+		http.Error(w, "Forbidden (share token inválido, expirado ou sem o escopo "+scope+")", http.StatusForbidden)
+		return false
+	}
+	http.Error(w, "Unauthorized (password, session or share token required)", http.StatusUnauthorized)
+	return false
+}
+
+// LinkSharesHandler gerencia os links de compartilhamento somente leitura das estatísticas.
+// GET    /api/link-shares?id=xxx&password=yyy — lista (sem o token)
+// POST   /api/link-shares {"id","password","label","scopes":["stats","geo"],"expires_in_days":30} — cria e devolve o token
+// DELETE /api/link-shares {"id","password","share_id"} — revoga
+func LinkSharesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		listLinkShares(w, r)
+	case http.MethodPost:
+		createLinkShare(w, r)
+	case http.MethodDelete:
+		revokeLinkShare(w, r)
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func listLinkShares(w http.ResponseWriter, r *http.Request) {
+	id, password := credentialFromQuery(r)
+	if id == "" {
+		http.Error(w, "ID missing", http.StatusBadRequest)
+		return
+	}
+	if !ownerAuthorized(r, id, password) {
+		http.Error(w, "Unauthorized (Invalid Password)", http.StatusUnauthorized)
+		return
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT id, COALESCE(label, ''), scopes, expires_at, revoked_at, last_used_at, created_at
+		FROM share_tokens WHERE link_id = ? ORDER BY created_at ASC, id ASC`, id)
+	if err != nil {
+		http.Error(w, "DB Error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	timeOrNil := func(t sql.NullTime) interface{} {
+		if t.Valid {
+			return t.Time.Format(time.RFC3339)
+		}
+		return nil
+	}
+	shares := []map[string]interface{}{}
+	for rows.Next() {
+		var shareID, label, scopes string
+		var expiresAt, revokedAt, lastUsedAt sql.NullTime
+		var createdAt time.Time
+		if rows.Scan(&shareID, &label, &scopes, &expiresAt, &revokedAt, &lastUsedAt, &createdAt) != nil {
+			continue
+		}
+		shares = append(shares, map[string]interface{}{
+			"share_id":     shareID,
+			"label":        label,
+			"scopes":       strings.Split(scopes, ","),
+			"active":       !revokedAt.Valid && (!expiresAt.Valid || expiresAt.Time.After(now)),
+			"expires_at":   timeOrNil(expiresAt),
+			"revoked_at":   timeOrNil(revokedAt),
+			"last_used_at": timeOrNil(lastUsedAt),
+			"created_at":   createdAt.Format(time.RFC3339),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(shares)
+}
+
+func createLinkShare(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID            string   `json:"id"`
+		Password      string   `json:"password"`
+		Label         string   `json:"label"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid Payload", http.StatusBadRequest)
+		return
+	}
+	if !ownerAuthorized(r, req.ID, req.Password) {
+		http.Error(w, "Unauthorized (Invalid Password)", http.StatusUnauthorized)
+		return
+	}
+
+	scopes := req.Scopes
+	if len(scopes) == 0 {
+		scopes = shareScopes
+	}
+	valid := map[string]bool{}
+	for _, s := range shareScopes {
+		valid[s] = true
+	}
+	for _, s := range scopes {
+		if !valid[s] {
+			http.Error(w, "Escopo desconhecido: "+s+" (use "+strings.Join(shareScopes, ", ")+")", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.ExpiresInDays < 0 {
+		http.Error(w, "expires_in_days deve ser positivo", http.StatusBadRequest)
+		return
+	}
+	label := strings.TrimSpace(req.Label)
+	if len([]rune(label)) > maxTitleLen {
+		http.Error(w, "label longo demais", http.StatusBadRequest)
+		return
+	}
+
+	var active int
+	database.DB.QueryRow("SELECT COUNT(*) FROM share_tokens WHERE link_id = ? AND revoked_at IS NULL", req.ID).Scan(&active)
+	if active >= maxShareTokensPerLink {
+		http.Error(w, "Limite de compartilhamentos ativos por link atingido", http.StatusTooManyRequests)
+		return
+	}
+
+	// Sem prazo, o token vale até ser revogado (ou até o link expirar e ser apagado)
+	var expiresAt sql.NullString
+	if req.ExpiresInDays > 0 {
+		expiresAt = sql.NullString{String: time.Now().UTC().AddDate(0, 0, req.ExpiresInDays).Format(sqliteTimeFormat), Valid: true}
+	}
+
+	shareID := "sh_" + utils.GenerateRandomString(8)
+	token := "shr_" + utils.GenerateRandomString(24)
+	_, err := database.DB.Exec("INSERT INTO share_tokens (id, link_id, token_hash, label, scopes, expires_at) VALUES (?, ?, ?, ?, ?, ?)",
+		shareID, req.ID, hashPassword(token), label, strings.Join(scopes, ","), expiresAt)
+	if err != nil {
+		http.Error(w, "Failed to create resource", http.StatusInternalServerError)
+		return
+	}
+
+	baseURL := os.Getenv("BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:8080"
+	}
+	var expires interface{}
+	if expiresAt.Valid {
+		t, _ := time.Parse(sqliteTimeFormat, expiresAt.String)
+		expires = t.Format(time.RFC3339)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"share_id":   shareID,
+		"token":      token,
+		"share_url":  baseURL + "/private.html?id=" + url.QueryEscape(req.ID) + "&share=" + url.QueryEscape(token),
+		"label":      label,
+		"scopes":     scopes,
+		"expires_at": expires,
+		"mensagem":   "Guarde o token: ele dá acesso somente leitura às estatísticas e não será exibido novamente.",
+	})
+}
+
+func revokeLinkShare(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID       string `json:"id"`
+		Password string `json:"password"`
+		ShareID  string `json:"share_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid Payload", http.StatusBadRequest)
+		return
+	}
+	if !ownerAuthorized(r, req.ID, req.Password) {
+		http.Error(w, "Unauthorized (Invalid Password)", http.StatusUnauthorized)
+		return
+	}
+
+	res, err := database.DB.Exec("UPDATE share_tokens SET revoked_at = ? WHERE id = ? AND link_id = ? AND revoked_at IS NULL",
+		time.Now().UTC().Format(sqliteTimeFormat), req.ShareID, req.ID)
+	if err != nil {
+		http.Error(w, "DB Error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Share not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "revoked", "share_id": req.ShareID})
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"crom-vision/internal/database"
+)
+
+func createShare(t *testing.T, body string) (string, string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	LinkSharesHandler(w, httptest.NewRequest(http.MethodPost, "/api/link-shares", strings.NewReader(body)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("esperava 201 ao criar o compartilhamento, obteve %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		ShareID  string `json:"share_id"`
+		Token    string `json:"token"`
+		ShareURL string `json:"share_url"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.Token == "" || !strings.Contains(resp.ShareURL, "share="+resp.Token) {
+		t.Fatalf("resposta sem token ou share_url: %+v", resp)
+	}
+	return resp.ShareID, resp.Token
+}
+
+func statsCode(query string, header map[string]string) int {
+	req := httptest.NewRequest(http.MethodGet, "/api/link-stats?"+query, nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	LinkStatsHandler(w, req)
+	return w.Code
+}
+
+func TestLinkStatsHandler_RequiresCredential(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	insertLivePasswordLink(t, "shp1")
+	database.DB.Exec("UPDATE links SET is_private = 1 WHERE id = 'shp1'")
+	insertTestLink(t, "shpub", "https://crom.run", "approved", "", 0, 0, false)
+
+	if code := statsCode("id=shp1", nil); code != http.StatusUnauthorized {
+		t.Errorf("link privado sem credencial: esperava 401, obteve %d", code)
+	}
+	if code := statsCode("id=shp1", map[string]string{"X-Crom-Password": "123"}); code != http.StatusOK {
+		t.Errorf("dono com a senha: esperava 200, obteve %d", code)
+	}
+	// Estar na vitrine pública não abre as estatísticas detalhadas
+	if code := statsCode("id=shpub", nil); code != http.StatusUnauthorized {
+		t.Errorf("link da vitrine pública sem credencial: esperava 401, obteve %d", code)
+	}
+	if code := statsCode("id=inexistente", nil); code != http.StatusUnauthorized {
+		t.Errorf("link inexistente: esperava 401, obteve %d", code)
+	}
+}
+
+func TestLinkShares_ScopesRevokeAndExpiry(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	insertLivePasswordLink(t, "sh1")
+	database.DB.Exec("UPDATE links SET is_private = 1 WHERE id = 'sh1'")
+
+	shareID, token := createShare(t, `{"id":"sh1","password":"123","label":"Cliente X","scopes":["stats"]}`)
+
+	if code := statsCode("id=sh1&share="+token, nil); code != http.StatusOK {
+		t.Errorf("token com escopo stats: esperava 200, obteve %d", code)
+	}
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/link-geo?id=sh1", nil)
+	req.Header.Set("X-Crom-Share", token)
+	LinkGeoHandler(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("token sem escopo geo: esperava 403, obteve %d", w.Code)
+	}
+
+	// O token de um link não abre outro
+	insertLivePasswordLink(t, "sh2")
+	database.DB.Exec("UPDATE links SET is_private = 1 WHERE id = 'sh2'")
+	if code := statsCode("id=sh2&share="+token, nil); code != http.StatusForbidden {
+		t.Errorf("token de outro link: esperava 403, obteve %d", code)
+	}
+
+	// Listagem não devolve o token
+	w = httptest.NewRecorder()
+	LinkSharesHandler(w, httptest.NewRequest(http.MethodGet, "/api/link-shares?id=sh1&password=123", nil))
+	if strings.Contains(w.Body.String(), token) || !strings.Contains(w.Body.String(), `"active":true`) {
+		t.Errorf("listagem inesperada: %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	LinkSharesHandler(w, httptest.NewRequest(http.MethodDelete, "/api/link-shares",
+		strings.NewReader(`{"id":"sh1","password":"123","share_id":"`+shareID+`"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("revogação: esperava 200, obteve %d: %s", w.Code, w.Body.String())
+	}
+	if code := statsCode("id=sh1&share="+token, nil); code != http.StatusForbidden {
+		t.Errorf("token revogado: esperava 403, obteve %d", code)
+	}
+
+	_, expired := createShare(t, `{"id":"sh1","password":"123","expires_in_days":7}`)
+	database.DB.Exec("UPDATE share_tokens SET expires_at = '2000-01-01 00:00:00' WHERE revoked_at IS NULL")
+	if code := statsCode("id=sh1&share="+expired, nil); code != http.StatusForbidden {
+		t.Errorf("token expirado: esperava 403, obteve %d", code)
+	}
+}
+
+func TestPrivateStatsHandler_ShareToken(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	insertLivePasswordLink(t, "sh3")
+
+	_, summary := createShare(t, `{"id":"sh3","password":"123","scopes":["summary"]}`)
+	_, statsOnly := createShare(t, `{"id":"sh3","password":"123","scopes":["stats"]}`)
+
+	post := func(token string) int {
+		w := httptest.NewRecorder()
+		PrivateStatsHandler(w, httptest.NewRequest(http.MethodPost, "/api/private-stats",
+			strings.NewReader(`{"id":"sh3","share_token":"`+token+`"}`)))
+		return w.Code
+	}
+	if code := post(summary); code != http.StatusOK {
+		t.Errorf("token com escopo summary: esperava 200, obteve %d", code)
+	}
+	if code := post(statsOnly); code != http.StatusUnauthorized {
+		t.Errorf("token sem escopo summary: esperava 401, obteve %d", code)
+	}
+
+	w := httptest.NewRecorder()
+	LinkSharesHandler(w, httptest.NewRequest(http.MethodPost, "/api/link-shares",
+		strings.NewReader(`{"id":"sh3","password":"123","scopes":["export"]}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("escopo desconhecido: esperava 400, obteve %d", w.Code)
+	}
+}
+
+func TestReadTimeAndVariants_RequireCredential(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	insertLivePasswordLink(t, "shrv")
+	database.DB.Exec("UPDATE links SET is_private = 1 WHERE id = 'shrv'")
+	insertTestLink(t, "shrvpub", "https://crom.run", "approved", "", 0, 0, false)
+	_, token := createShare(t, `{"id":"shrv","password":"123","scopes":["read_time"]}`)
+
+	handlers := map[string]http.HandlerFunc{
+		"/api/link-read-time": LinkReadTimeHandler,
+		"/api/link-variants":  LinkVariantsHandler,
+	}
+	code := func(path, query string) int {
+		w := httptest.NewRecorder()
+		handlers[path](w, httptest.NewRequest(http.MethodGet, path+"?"+query, nil))
+		return w.Code
+	}
+
+	for path := range handlers {
+		if c := code(path, "id=shrv"); c != http.StatusUnauthorized {
+			t.Errorf("%s de link privado sem credencial: esperava 401, obteve %d", path, c)
+		}
+		if c := code(path, "id=shrv&password=123"); c != http.StatusOK {
+			t.Errorf("%s com a senha do dono: esperava 200, obteve %d", path, c)
+		}
+		if c := code(path, "id=shrvpub"); c != http.StatusUnauthorized {
+			t.Errorf("%s de link público sem credencial: esperava 401, obteve %d", path, c)
+		}
+	}
+	if c := code("/api/link-read-time", "id=shrv&share="+token); c != http.StatusOK {
+		t.Errorf("token com escopo read_time: esperava 200, obteve %d", c)
+	}
+	if c := code("/api/link-variants", "id=shrv&share="+token); c != http.StatusForbidden {
+		t.Errorf("token sem escopo variants: esperava 403, obteve %d", c)
+	}
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"crom-vision/internal/database"
+)
+
+// PublicLinkStatsHandler devolve o agregado que a vitrine mostra de um link público: a série
+// de views do período e o mapa de países com o k-anonimato dos painéis públicos. Sem únicos,
+// retornos, resumo de visitantes nem detalhamentos; esses exigem a credencial do dono ou um
+// share token (/api/link-stats, /api/link-geo).
+// GET /api/public-stats?id=xxx[&period=10m|1h|24h|7d|30d|90d|all][&tz=America/Sao_Paulo]
+func PublicLinkStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "ID missing", http.StatusBadRequest)
+		return
+	}
+
+	// Mesmo filtro de /api/public-links: só o que aparece na vitrine
+	var listed bool
+	database.DB.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM links WHERE id = ? AND is_private = 0 AND payment_status = 'approved'
+			AND (expires_at > CURRENT_TIMESTAMP OR expires_at IS NULL)
+			AND (not_before IS NULL OR not_before <= CURRENT_TIMESTAMP))`, id).Scan(&listed)
+	if !listed {
+		http.Error(w, "Link not found", http.StatusNotFound)
+		return
+	}
+
+	sr, err := parseStatsRange(r.URL.Query(), id, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	starts, err := sr.buckets()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(starts) > 0 {
+		sr.From = starts[0]
+	}
+	perMinute, err := loadVisitCounts(id, sr)
+	if err != nil {
+		http.Error(w, "DB Error", http.StatusInternalServerError)
+		return
+	}
+	labels := make([]string, len(starts))
+	for i, t := range starts {
+		labels[i] = sr.label(t)
+	}
+
+	// Mapa no formato do Google GeoChart, como /api/link-geo
+	geo := [][]interface{}{{"Country", "Views"}}
+	if strings.ToLower(os.Getenv("GEO_TRACKING_ENABLED")) != "false" {
+		entries, err := countryBreakdown(id, "")
+		if err != nil {
+			http.Error(w, "DB Error", http.StatusInternalServerError)
+			return
+		}
+		for _, e := range anonymizeGeo(entries, 1, defaultGeoLimit, loadKAnonymity(true)) {
+			geo = append(geo, []interface{}{e.Country, e.Views})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"labels":      labels,
+		"data":        sr.series(starts, perMinute.Total),
+		"geo":         geo,
+		"period":      sr.Period,
+		"granularity": sr.Granularity,
+		"tz":          sr.Loc.String(),
+	})
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPublicLinkStatsHandler(t *testing.T) {
+	cleanup := setupStatsDB(t)
+	defer cleanup()
+	seedLinks(t)
+	rebuildRollups(t)
+
+	w := httptest.NewRecorder()
+	PublicLinkStatsHandler(w, httptest.NewRequest(http.MethodGet, "/api/public-stats?id=pub1&period=24h", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("esperava 200, obteve %d: %s", w.Code, w.Body.String())
+	}
+	var resp map[string]json.RawMessage
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	var data []int
+	json.Unmarshal(resp["data"], &data)
+	total := 0
+	for _, n := range data {
+		total += n
+	}
+	if total != 3 {
+		t.Errorf("esperava 3 views na série, obteve %d", total)
+	}
+	var geo [][]interface{}
+	json.Unmarshal(resp["geo"], &geo)
+	if len(geo) != 3 || geo[0][0] != "Country" {
+		t.Errorf("esperava header + BR e US no mapa, obteve %v", geo)
+	}
+	// Só o agregado: nada de únicos, retornos ou resumo de visitantes
+	for _, key := range []string{"unique", "returning", "summary"} {
+		if _, ok := resp[key]; ok {
+			t.Errorf("a vitrine não deveria expor %q", key)
+		}
+	}
+
+	// Links fora da vitrine não têm agregado público
+	for _, id := range []string{"priv1", "inexistente"} {
+		w := httptest.NewRecorder()
+		PublicLinkStatsHandler(w, httptest.NewRequest(http.MethodGet, "/api/public-stats?id="+id, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s: esperava 404, obteve %d", id, w.Code)
+		}
+	}
+}
@@ -6,17 +6,34 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"net/http"
-	"os"
-	"strings"
+	"time"
 
 	"crom-vision/internal/database"
 )
 
+// hashPassword gera o SHA-256 da Senha Mágica, no mesmo formato gravado em links.password_hash
+func hashPassword(password string) string {
+	h := sha256.New()
+	h.Write([]byte(password))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// linkPasswordMatches confere a Senha Mágica de um link (credencial do relatório privado).
+// Links sem senha cadastrada nunca são liberados por aqui.
+func linkPasswordMatches(id, password string) bool {
+	var dbPassHash sql.NullString
+	if err := database.DB.QueryRow("SELECT password_hash FROM links WHERE id = ?", id).Scan(&dbPassHash); err != nil {
+		return false
+	}
+	return dbPassHash.Valid && dbPassHash.String != "" && hashPassword(password) == dbPassHash.String
+}
+
 func PublicLinksHandler(w http.ResponseWriter, r *http.Request) {
 	rows, err := database.DB.Query(`
 		SELECT id, original_url, max_views, total_views, unique_views, expires_at, file_path 
 		FROM links 
 		WHERE is_private = 0 AND payment_status = 'approved' AND (expires_at > CURRENT_TIMESTAMP OR expires_at IS NULL)
+			AND (not_before IS NULL OR not_before <= CURRENT_TIMESTAMP)
 	`)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -61,9 +78,11 @@ func PrivateStatsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// share_token (escopo summary) substitui a senha para quem recebeu um link de compartilhamento
 	var req struct {
-		ID       string `json:"id"`
-		Password string `json:"password"`
+		ID         string `json:"id"`
+		Password   string `json:"password"`
+		ShareToken string `json:"share_token"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid Payload", http.StatusBadRequest)
@@ -85,18 +104,23 @@ func PrivateStatsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h := sha256.New()
-	h.Write([]byte(req.Password))
-	inpHash := hex.EncodeToString(h.Sum(nil))
-
-	if inpHash != dbPassHash {
+	if hashPassword(req.Password) != dbPassHash && !sessionOwnsLink(r, req.ID) && !shareTokenAllows(req.ID, req.ShareToken, scopeSummary) {
 		http.Error(w, "Unauthorized (Invalid Password)", http.StatusUnauthorized)
 		return
 	}
 
+	// Tentativas barradas pelas regras de entrega (geo/horário) não consomem views
+	var blocked int
+	database.DB.QueryRow("SELECT COUNT(*) FROM access_logs WHERE link_id = ? AND status IN ('geo_blocked', 'time_blocked')", req.ID).Scan(&blocked)
+	// Robôs de pré-visualização (Slack, WhatsApp...) também ficam de fora das views
+	var unfurls int
+	database.DB.QueryRow("SELECT COUNT(*) FROM access_logs WHERE link_id = ? AND status = 'unfurl'", req.ID).Scan(&unfurls)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"id":             req.ID,
+		"blocked_views":  blocked,
+		"unfurl_views":   unfurls,
 		"original_url":   orig.String,
 		"max_views":      max,
 		"total_views":    tot,
@@ -106,124 +130,71 @@ func PrivateStatsHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// LinkStatsHandler devolve as séries de acessos do link (total, únicos e de retorno) com todos
+// os buckets do intervalo (inclusive vazios) e o resumo de visitantes do período.
+// GET /api/link-stats?id=xxx[&period=10m|1h|24h|7d|30d|90d|all | &from=...&to=...][&granularity=minute|10minute|hour|day|week|month][&tz=America/Sao_Paulo]
+// Exige a Senha Mágica (&password= ou X-Crom-Password), a sessão do dono ou um share token
+// com o escopo stats (&share= ou X-Crom-Share), inclusive para links da vitrine pública.
 func LinkStatsHandler(w http.ResponseWriter, r *http.Request) {
 	id := r.URL.Query().Get("id")
-	period := r.URL.Query().Get("period")
 	if id == "" {
 		http.Error(w, "ID missing", http.StatusBadRequest)
 		return
 	}
-	if period == "" {
-		period = "24h"
-	}
-
-	// Permissão livre? No momento Dashboard lateral é public em modo FREE ou mostra tudo publico por ID 
-	// (Num SaaS real seria bom pedir a senha mágica, por conveniência vitrine/dash está aberto)
-
-	var query string
-	var timeMod string
-
-	switch period {
-	case "10m":
-		// Dados dos últimos 10 minutos agrupados por minuto exato.
-		timeMod = "-10 minutes"
-		query = `
-			SELECT strftime('%H:%M', accessed_at) as bucket, COUNT(*) 
-			FROM access_logs 
-			WHERE link_id = ? AND accessed_at >= datetime('now', ?) 
-			GROUP BY bucket ORDER BY bucket ASC`
-	case "1h":
-		// Última 1 hora agrupada a cada 10 minutos - simplificado para minuto a minuto na visualização
-		timeMod = "-1 hour"
-		query = `
-			SELECT strftime('%H:%M', accessed_at, '-' || (CAST(strftime('%M', accessed_at) AS INTEGER) % 10) || ' minutes') as bucket, COUNT(*) 
-			FROM access_logs 
-			WHERE link_id = ? AND accessed_at >= datetime('now', ?) 
-			GROUP BY bucket ORDER BY bucket ASC`
-	case "24h":
-		// Últimas 24 horas agrupadas por hora cheia
-		timeMod = "-24 hours"
-		query = `
-			SELECT strftime('%Y-%m-%d %H:00', accessed_at) as bucket, COUNT(*) 
-			FROM access_logs 
-			WHERE link_id = ? AND accessed_at >= datetime('now', ?) 
-			GROUP BY bucket ORDER BY bucket ASC`
-	case "7d":
-		// Últimos 7 dias agrupados por dia
-		timeMod = "-7 days"
-		query = `
-			SELECT strftime('%Y-%m-%d', accessed_at) as bucket, COUNT(*) 
-			FROM access_logs 
-			WHERE link_id = ? AND accessed_at >= datetime('now', ?) 
-			GROUP BY bucket ORDER BY bucket ASC`
-	default:
-		http.Error(w, "Invalid period", http.StatusBadRequest)
+
+	if !authorizeStats(w, r, id, scopeStats) {
 		return
 	}
 
-	rows, err := database.DB.Query(query, id, timeMod)
+	sr, err := parseStatsRange(r.URL.Query(), id, time.Now())
 	if err != nil {
-		http.Error(w, "DB Error", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	defer rows.Close()
-
-	var labels []string
-	var data []int
-
-	for rows.Next() {
-		var bucket string
-		var count int
-		rows.Scan(&bucket, &count)
-		labels = append(labels, bucket)
-		data = append(data, count)
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"labels": labels,
-		"data":   data,
-		"period": period,
-	})
-}
-
-func LinkGeoHandler(w http.ResponseWriter, r *http.Request) {
-	id := r.URL.Query().Get("id")
-	if id == "" {
-		http.Error(w, "ID missing", http.StatusBadRequest)
+	starts, err := sr.buckets()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	// O primeiro bucket é contado por inteiro, mesmo que comece antes de "from"
+	if len(starts) > 0 {
+		sr.From = starts[0]
+	}
 
-	// Se geo tracking desativado, retorna vazio
-	if strings.ToLower(os.Getenv("GEO_TRACKING_ENABLED")) == "false" {
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode([][]interface{}{{"Country", "Views"}})
+	perMinute, err := loadVisitCounts(id, sr)
+	if err != nil {
+		http.Error(w, "DB Error", http.StatusInternalServerError)
 		return
 	}
-
-	rows, err := database.DB.Query(`
-		SELECT country, COUNT(*) 
-		FROM access_logs 
-		WHERE link_id = ? 
-		GROUP BY country ORDER BY COUNT(*) DESC`, id)
-	
+	summary, err := summarizeVisitors(id, sr)
 	if err != nil {
 		http.Error(w, "DB Error", http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
-
-	var data [][]interface{}
-	// First row represents the columns for Google GeoChart
-	data = append(data, []interface{}{"Country", "Views"})
 
-	for rows.Next() {
-		var country string
-		var count int
-		rows.Scan(&country, &count)
-		data = append(data, []interface{}{country, count})
+	labels := make([]string, len(starts))
+	for i, t := range starts {
+		labels[i] = sr.label(t)
+	}
+	dataSeries := sr.series(starts, perMinute.Total)
+	uniqueSeries := sr.series(starts, perMinute.Unique)
+	summary.Total, summary.Unique = 0, 0
+	for i := range dataSeries {
+		summary.Total += dataSeries[i]
+		summary.Unique += uniqueSeries[i]
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(data)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"labels":      labels,
+		"data":        dataSeries,
+		"unique":      uniqueSeries,
+		"returning":   sr.series(starts, perMinute.Returning),
+		"summary":     summary,
+		"period":      sr.Period,
+		"granularity": sr.Granularity,
+		"tz":          sr.Loc.String(),
+		"from":        sr.From.Format(time.RFC3339),
+		"to":          sr.To.Format(time.RFC3339),
+	})
 }
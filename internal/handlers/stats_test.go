@@ -8,8 +8,10 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"crom-vision/internal/database"
+	"crom-vision/internal/services"
 )
 
 func setupStatsDB(t *testing.T) func() {
@@ -18,19 +20,22 @@ func setupStatsDB(t *testing.T) func() {
 	os.Setenv("DATABASE_URL", filepath.Join(tmpDir, "stats.db")+"?_journal_mode=WAL")
 	os.Setenv("STORAGE_PATH", filepath.Join(tmpDir, "storage"))
 	os.Setenv("APP_SALT", "test_salt")
+	// Contagens exatas: o k-anonimato é coberto em privacy_test.go
+	os.Setenv("K_ANON_MIN_GROUP", "1")
 	database.InitDB()
 	return func() {
 		database.DB.Close()
 		os.Unsetenv("DATABASE_URL")
 		os.Unsetenv("STORAGE_PATH")
 		os.Unsetenv("APP_SALT")
+		os.Unsetenv("K_ANON_MIN_GROUP")
 	}
 }
 
 func seedLinks(t *testing.T) {
 	t.Helper()
-	database.DB.Exec(`INSERT INTO links (id, original_url, max_views, total_views, unique_views, expires_at, payment_status, is_private, file_path)
-		VALUES ('pub1', '', 0, 5, 3, datetime('now', '+7 days'), 'approved', 0, '/fake/img.png')`)
+	database.DB.Exec(`INSERT INTO links (id, original_url, max_views, total_views, unique_views, expires_at, payment_status, is_private, file_path, password_hash)
+		VALUES ('pub1', '', 0, 5, 3, datetime('now', '+7 days'), 'approved', 0, '/fake/img.png', 'a665a45920422f9d417e4867efdc4fb8a04a1f3fff1fa07e998e86f7f7a27ae3')`)
 	database.DB.Exec(`INSERT INTO links (id, original_url, max_views, total_views, unique_views, expires_at, payment_status, is_private)
 		VALUES ('priv1', '', 0, 10, 8, datetime('now', '+30 days'), 'approved', 1)`)
 	database.DB.Exec(`INSERT INTO links (id, payment_status, is_private, password_hash, expires_at)
@@ -41,6 +46,16 @@ func seedLinks(t *testing.T) {
 	database.DB.Exec(`INSERT INTO access_logs (link_id, ip_hash, user_agent, country, city) VALUES ('pub1', 'h3', 'Safari', 'BR', 'São Paulo')`)
 }
 
+// rebuildRollups refaz os rollups a partir dos access_logs inseridos direto no teste
+func rebuildRollups(t *testing.T) {
+	t.Helper()
+	database.DB.Exec("DELETE FROM stats_hourly")
+	database.DB.Exec("DELETE FROM stats_daily")
+	if err := services.BackfillRollups(); err != nil {
+		t.Fatalf("falha no backfill dos rollups: %v", err)
+	}
+}
+
 func TestPublicLinksHandler(t *testing.T) {
 	cleanup := setupStatsDB(t)
 	defer cleanup()
@@ -63,7 +78,7 @@ func TestPublicLinksHandler(t *testing.T) {
 	}
 
 	if len(links) > 0 && links[0]["id"] != "pub1" {
-		t.Errorf("esperava id=pub1, obteve %v", links[0]["id"])
+		t.Errorf("esperava id=pub1&password=123, obteve %v", links[0]["id"])
 	}
 }
 
@@ -99,8 +114,9 @@ func TestLinkStatsHandler_MissingID(t *testing.T) {
 func TestLinkStatsHandler_InvalidPeriod(t *testing.T) {
 	cleanup := setupStatsDB(t)
 	defer cleanup()
+	seedLinks(t)
 
-	req := httptest.NewRequest(http.MethodGet, "/api/link-stats?id=pub1&period=99h", nil)
+	req := httptest.NewRequest(http.MethodGet, "/api/link-stats?id=pub1&password=123&period=99h", nil)
 	w := httptest.NewRecorder()
 	LinkStatsHandler(w, req)
 
@@ -117,7 +133,7 @@ func TestLinkStatsHandler_AllPeriods(t *testing.T) {
 	periods := []string{"10m", "1h", "24h", "7d"}
 	for _, p := range periods {
 		t.Run("period="+p, func(t *testing.T) {
-			req := httptest.NewRequest(http.MethodGet, "/api/link-stats?id=pub1&period="+p, nil)
+			req := httptest.NewRequest(http.MethodGet, "/api/link-stats?id=pub1&password=123&period="+p, nil)
 			w := httptest.NewRecorder()
 			LinkStatsHandler(w, req)
 
@@ -139,7 +155,7 @@ func TestLinkStatsHandler_DefaultPeriod(t *testing.T) {
 	defer cleanup()
 	seedLinks(t)
 
-	req := httptest.NewRequest(http.MethodGet, "/api/link-stats?id=pub1", nil)
+	req := httptest.NewRequest(http.MethodGet, "/api/link-stats?id=pub1&password=123", nil)
 	w := httptest.NewRecorder()
 	LinkStatsHandler(w, req)
 
@@ -167,8 +183,9 @@ func TestLinkGeoHandler_WithData(t *testing.T) {
 	cleanup := setupStatsDB(t)
 	defer cleanup()
 	seedLinks(t)
+	rebuildRollups(t)
 
-	req := httptest.NewRequest(http.MethodGet, "/api/link-geo?id=pub1", nil)
+	req := httptest.NewRequest(http.MethodGet, "/api/link-geo?id=pub1&password=123", nil)
 	w := httptest.NewRecorder()
 	LinkGeoHandler(w, req)
 
@@ -255,3 +272,280 @@ func TestPrivateStatsHandler_Success(t *testing.T) {
 		t.Errorf("esperava id=pass1, obteve %v", resp["id"])
 	}
 }
+
+func TestPublicLinksHandler_HidesScheduled(t *testing.T) {
+	cleanup := setupStatsDB(t)
+	defer cleanup()
+	seedLinks(t)
+	database.DB.Exec(`INSERT INTO links (id, payment_status, is_private, expires_at, not_before)
+		VALUES ('sched1', 'approved', 0, datetime('now', '+7 days'), datetime('now', '+1 day'))`)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/public-links", nil)
+	w := httptest.NewRecorder()
+	PublicLinksHandler(w, req)
+
+	var links []map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &links)
+	for _, l := range links {
+		if l["id"] == "sched1" {
+			t.Error("link com not_before no futuro não deve aparecer na vitrine")
+		}
+	}
+}
+
+func TestLinkStatsHandler_ZeroFilledRange(t *testing.T) {
+	cleanup := setupStatsDB(t)
+	defer cleanup()
+	seedLinks(t)
+	database.DB.Exec("DELETE FROM access_logs WHERE link_id = 'pub1'")
+	for _, at := range []string{"2025-03-10 13:05:00", "2025-03-10 13:40:00", "2025-03-12 02:30:00"} {
+		database.DB.Exec("INSERT INTO access_logs (link_id, ip_hash, accessed_at) VALUES ('pub1', 'h', ?)", at)
+	}
+	rebuildRollups(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/link-stats?id=pub1&password=123&from=2025-03-10&to=2025-03-12&granularity=day&tz=America/Sao_Paulo", nil)
+	w := httptest.NewRecorder()
+	LinkStatsHandler(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("esperava 200, obteve %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		Labels []string `json:"labels"`
+		Data   []int    `json:"data"`
+		TZ     string   `json:"tz"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	// 02:30 UTC do dia 12 ainda é dia 11 em Brasília (UTC-3)
+	wantLabels := []string{"2025-03-10", "2025-03-11", "2025-03-12"}
+	wantData := []int{2, 1, 0}
+	if len(resp.Labels) != 3 || resp.TZ != "America/Sao_Paulo" {
+		t.Fatalf("resposta inesperada: %+v", resp)
+	}
+	for i := range wantLabels {
+		if resp.Labels[i] != wantLabels[i] || resp.Data[i] != wantData[i] {
+			t.Errorf("bucket %d: esperava %s=%d, obteve %s=%d", i, wantLabels[i], wantData[i], resp.Labels[i], resp.Data[i])
+		}
+	}
+}
+
+func TestLinkStatsHandler_LocalHours(t *testing.T) {
+	cleanup := setupStatsDB(t)
+	defer cleanup()
+	seedLinks(t)
+	database.DB.Exec("DELETE FROM access_logs WHERE link_id = 'pub1'")
+	database.DB.Exec("INSERT INTO access_logs (link_id, ip_hash, accessed_at) VALUES ('pub1', 'h', '2025-03-10 15:20:00')")
+	rebuildRollups(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/link-stats?id=pub1&password=123&from=2025-03-10T09:00&to=2025-03-10T15:00&tz=America/Sao_Paulo", nil)
+	w := httptest.NewRecorder()
+	LinkStatsHandler(w, req)
+
+	var resp struct {
+		Labels      []string `json:"labels"`
+		Data        []int    `json:"data"`
+		Granularity string   `json:"granularity"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.Granularity != "hour" || len(resp.Labels) != 6 {
+		t.Fatalf("esperava 6 buckets por hora, obteve %+v", resp)
+	}
+	if resp.Labels[3] != "2025-03-10 12:00" || resp.Data[3] != 1 {
+		t.Errorf("hit das 15:20 UTC deveria cair às 12:00 de Brasília: %v %v", resp.Labels, resp.Data)
+	}
+}
+
+func TestLinkStatsHandler_NewPresetsAndValidation(t *testing.T) {
+	cleanup := setupStatsDB(t)
+	defer cleanup()
+	seedLinks(t)
+
+	for _, p := range []string{"30d", "90d", "all"} {
+		w := httptest.NewRecorder()
+		LinkStatsHandler(w, httptest.NewRequest(http.MethodGet, "/api/link-stats?id=pub1&password=123&period="+p, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("period=%s: esperava 200, obteve %d", p, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	LinkStatsHandler(w, httptest.NewRequest(http.MethodGet, "/api/link-stats?id=pub1&password=123&period=24h", nil))
+	var resp struct {
+		Labels []string `json:"labels"`
+		Data   []int    `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if len(resp.Labels) < 24 || len(resp.Labels) != len(resp.Data) {
+		t.Errorf("24h deveria trazer todas as horas, inclusive vazias: %d labels", len(resp.Labels))
+	}
+
+	for _, q := range []string{
+		"period=90d&granularity=minute", // buckets demais
+		"tz=Marte/Olympus",
+		"from=2025-03-12&to=2025-03-10",
+		"from=ontem",
+		"period=24h&granularity=second",
+	} {
+		w := httptest.NewRecorder()
+		LinkStatsHandler(w, httptest.NewRequest(http.MethodGet, "/api/link-stats?id=pub1&password=123&"+q, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: esperava 400, obteve %d", q, w.Code)
+		}
+	}
+}
+
+func TestStatsRange_WeekAndMonthBuckets(t *testing.T) {
+	sr := statsRange{Granularity: granWeek, Loc: time.UTC}
+	// Quarta-feira → segunda-feira da mesma semana
+	if got := sr.truncate(time.Date(2025, 3, 12, 15, 0, 0, 0, time.UTC)); !got.Equal(time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("semana deveria começar na segunda, obteve %v", got)
+	}
+	sr.Granularity = granMonth
+	if got := sr.next(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)); !got.Equal(time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("próximo mês inesperado: %v", got)
+	}
+}
+
+func TestLinkStatsHandler_UniqueAndReturning(t *testing.T) {
+	cleanup := setupStatsDB(t)
+	defer cleanup()
+	// Logs de 2025 ainda dentro da retenção
+	t.Setenv("LOG_RETENTION_DAYS", "36500")
+	seedLinks(t)
+	database.DB.Exec("DELETE FROM access_logs WHERE link_id = 'pub1'")
+	hits := []struct{ ip, at string }{
+		{"a", "2025-03-09 10:00:00"}, // antes do intervalo: "a" volta como visitante de retorno
+		{"a", "2025-03-10 10:00:00"},
+		{"a", "2025-03-10 10:02:00"}, // F5 dentro da janela: conta no total, não como único
+		{"b", "2025-03-10 11:00:00"},
+		{"b", "2025-03-11 11:00:00"},
+	}
+	for _, h := range hits {
+		database.DB.Exec("INSERT INTO access_logs (link_id, ip_hash, accessed_at) VALUES ('pub1', ?, ?)", h.ip, h.at)
+	}
+	database.DB.Exec("INSERT INTO access_logs (link_id, ip_hash, accessed_at, status) VALUES ('pub1', 'c', '2025-03-10 12:00:00', 'geo_blocked')")
+	rebuildRollups(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/link-stats?id=pub1&password=123&from=2025-03-10&to=2025-03-11&granularity=day&tz=UTC", nil)
+	w := httptest.NewRecorder()
+	LinkStatsHandler(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("esperava 200, obteve %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		Data      []int `json:"data"`
+		Unique    []int `json:"unique"`
+		Returning []int `json:"returning"`
+		Summary   struct {
+			Total              int     `json:"total"`
+			Unique             int     `json:"unique"`
+			Visitors           int     `json:"visitors"`
+			NewVisitors        int     `json:"new_visitors"`
+			ReturningVisitors  int     `json:"returning_visitors"`
+			NewVsReturning     float64 `json:"new_vs_returning"`
+			ReturningPct       float64 `json:"returning_pct"`
+			AvgViewsPerVisitor float64 `json:"avg_views_per_visitor"`
+		} `json:"summary"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+
+	wantData, wantUnique, wantReturning := []int{3, 1}, []int{2, 1}, []int{1, 1}
+	if len(resp.Data) != 2 || len(resp.Unique) != 2 || len(resp.Returning) != 2 {
+		t.Fatalf("séries com tamanho inesperado: %s", w.Body.String())
+	}
+	for i := range wantData {
+		if resp.Data[i] != wantData[i] || resp.Unique[i] != wantUnique[i] || resp.Returning[i] != wantReturning[i] {
+			t.Errorf("bucket %d: esperava %d/%d/%d, obteve %d/%d/%d", i,
+				wantData[i], wantUnique[i], wantReturning[i], resp.Data[i], resp.Unique[i], resp.Returning[i])
+		}
+	}
+
+	s := resp.Summary
+	if s.Total != 4 || s.Unique != 3 || s.Visitors != 2 {
+		t.Errorf("totais inesperados: %+v", s)
+	}
+	if s.NewVisitors != 1 || s.ReturningVisitors != 1 || s.NewVsReturning != 1 || s.ReturningPct != 50 {
+		t.Errorf("novos vs. retorno inesperado: %+v", s)
+	}
+	if s.AvgViewsPerVisitor != 2 {
+		t.Errorf("esperava 2 views por visitante, obteve %v", s.AvgViewsPerVisitor)
+	}
+}
+
+func TestLinkStatsHandler_HistorySurvivesLogRetention(t *testing.T) {
+	cleanup := setupStatsDB(t)
+	defer cleanup()
+	seedLinks(t)
+	database.DB.Exec("DELETE FROM access_logs")
+	database.DB.Exec("DELETE FROM stats_hourly")
+	database.DB.Exec("DELETE FROM stats_daily")
+
+	at := time.Date(2025, 1, 15, 9, 30, 0, 0, time.UTC)
+	services.RecordRollup("pub1", at, "BR", "browser", true, false)
+	services.RecordRollup("pub1", at.Add(time.Minute), "BR", "browser", false, false)
+	services.RecordRollup("pub1", at.Add(24*time.Hour), "US", "email", true, true)
+	// Nenhum access_log: simula a limpeza de LOG_RETENTION_DAYS
+
+	req := httptest.NewRequest(http.MethodGet, "/api/link-stats?id=pub1&password=123&from=2025-01-15&to=2025-01-16&granularity=day&tz=UTC", nil)
+	w := httptest.NewRecorder()
+	LinkStatsHandler(w, req)
+	var resp struct {
+		Data      []int `json:"data"`
+		Unique    []int `json:"unique"`
+		Returning []int `json:"returning"`
+		Summary   struct {
+			Total           int    `json:"total"`
+			VisitorsSince   string `json:"visitors_since"`
+			VisitorsPartial bool   `json:"visitors_partial"`
+		} `json:"summary"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.Summary.Total != 3 {
+		t.Errorf("total do resumo deveria somar a série do rollup, obteve %d", resp.Summary.Total)
+	}
+	if len(resp.Returning) != 2 || resp.Returning[1] != 1 {
+		t.Errorf("visitas de retorno deveriam vir do rollup: %v", resp.Returning)
+	}
+	// Os indicadores de visitantes só cobrem a janela retida e a resposta avisa
+	since, err := time.Parse(time.RFC3339, resp.Summary.VisitorsSince)
+	if !resp.Summary.VisitorsPartial || err != nil || time.Since(since) > 91*24*time.Hour {
+		t.Errorf("resumo de visitantes deveria indicar a janela de retenção: %+v", resp.Summary)
+	}
+	if len(resp.Data) != 2 || resp.Data[0] != 2 || resp.Data[1] != 1 || resp.Unique[0] != 1 || resp.Unique[1] != 1 {
+		t.Errorf("série diária deveria vir do rollup: %s", w.Body.String())
+	}
+
+	// Mesmo intervalo em Brasília: buckets por dia local lidos do rollup horário
+	req = httptest.NewRequest(http.MethodGet, "/api/link-stats?id=pub1&password=123&from=2025-01-15&to=2025-01-16&granularity=day&tz=America/Sao_Paulo", nil)
+	w = httptest.NewRecorder()
+	LinkStatsHandler(w, req)
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if len(resp.Data) != 2 || resp.Data[0] != 2 || resp.Data[1] != 1 {
+		t.Errorf("série local deveria vir do rollup horário: %s", w.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/link-geo?id=pub1&password=123&format=json", nil)
+	w = httptest.NewRecorder()
+	LinkGeoHandler(w, req)
+	if !bytes.Contains(w.Body.Bytes(), []byte(`{"country":"BR","views":2}`)) {
+		t.Errorf("países deveriam vir do rollup diário: %s", w.Body.String())
+	}
+}
+
+func TestRecordHit_MarksReturningVisitInRollup(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	insertTestLink(t, "ret1", "https://crom.run", "approved", "", 0, 0, false)
+
+	// Mesmo ip_hash único duas vezes (voltou depois da janela do anti-F5) e um visitante novo
+	recordHit(accessHit{LinkID: "ret1", FingerprintHash: "fp_a", Unique: true})
+	recordHit(accessHit{LinkID: "ret1", FingerprintHash: "fp_a", Unique: true})
+	recordHit(accessHit{LinkID: "ret1", FingerprintHash: "fp_b", Unique: true})
+
+	var views, uniques, returning int
+	database.DB.QueryRow("SELECT SUM(views), SUM(uniques), SUM(returning_visits) FROM stats_daily WHERE link_id = 'ret1'").Scan(&views, &uniques, &returning)
+	if views != 3 || uniques != 3 || returning != 1 {
+		t.Errorf("esperava 3 views/3 únicos/1 retorno, obteve %d/%d/%d", views, uniques, returning)
+	}
+}
@@ -0,0 +1,437 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"crom-vision/internal/database"
+	"crom-vision/internal/utils"
+)
+
+// Granularidades aceitas em ?granularity=
+const (
+	granMinute   = "minute"
+	gran10Minute = "10minute"
+	granHour     = "hour"
+	granDay      = "day"
+	granWeek     = "week"
+	granMonth    = "month"
+)
+
+// maxStatsBuckets evita respostas gigantes (ex: granularity=minute com period=all)
+const maxStatsBuckets = 1500
+
+const sqliteTimeFormat = "2006-01-02 15:04:05"
+
+// statsRange é o intervalo [From, To) de uma consulta de série temporal, já no fuso pedido
+type statsRange struct {
+	Period      string
+	From        time.Time
+	To          time.Time
+	Granularity string
+	Loc         *time.Location
+}
+
+// presetRanges mapeia os períodos prontos para a duração e a granularidade padrão
+var presetRanges = map[string]struct {
+	d    time.Duration
+	gran string
+}{
+	"10m": {10 * time.Minute, granMinute},
+	"1h":  {time.Hour, gran10Minute},
+	"24h": {24 * time.Hour, granHour},
+	"7d":  {7 * 24 * time.Hour, granDay},
+	"30d": {30 * 24 * time.Hour, granDay},
+	"90d": {90 * 24 * time.Hour, granDay},
+}
+
+// defaultGranularity escolhe a granularidade conforme o tamanho do intervalo
+func defaultGranularity(d time.Duration) string {
+	switch {
+	case d <= 2*time.Hour:
+		return granMinute
+	case d <= 3*24*time.Hour:
+		return granHour
+	case d <= 180*24*time.Hour:
+		return granDay
+	case d <= 3*365*24*time.Hour:
+		return granWeek
+	}
+	return granMonth
+}
+
+// parseStatsTime aceita RFC3339 ou datas/horários locais no fuso informado
+func parseStatsTime(raw string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t.In(loc), nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, raw, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("data inválida: " + raw)
+}
+
+// loadStatsLocation resolve ?tz= (IANA, ex: America/Sao_Paulo); padrão STATS_DEFAULT_TZ ou UTC
+func loadStatsLocation(tz string) (*time.Location, error) {
+	if tz == "" {
+		tz = os.Getenv("STATS_DEFAULT_TZ")
+	}
+	if tz == "" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(tz)
+}
+
+// parseStatsRange interpreta period/from/to/granularity/tz de uma requisição de série
+func parseStatsRange(q url.Values, linkID string, now time.Time) (statsRange, error) {
+	var sr statsRange
+	loc, err := loadStatsLocation(q.Get("tz"))
+	if err != nil {
+		return sr, errors.New("tz inválido")
+	}
+	sr.Loc = loc
+	now = now.In(loc)
+
+	from, to := q.Get("from"), q.Get("to")
+	switch {
+	case from != "" || to != "":
+		sr.Period = "custom"
+		sr.To = now
+		if to != "" {
+			if sr.To, err = parseStatsTime(to, loc); err != nil {
+				return sr, err
+			}
+			// "to" só com data inclui o dia inteiro
+			if len(to) == len("2006-01-02") {
+				sr.To = sr.To.AddDate(0, 0, 1)
+			}
+		}
+		if from == "" {
+			return sr, errors.New("from é obrigatório quando to é informado")
+		}
+		if sr.From, err = parseStatsTime(from, loc); err != nil {
+			return sr, err
+		}
+		if !sr.From.Before(sr.To) {
+			return sr, errors.New("from deve ser anterior a to")
+		}
+	default:
+		sr.Period = q.Get("period")
+		if sr.Period == "" {
+			sr.Period = "24h"
+		}
+		sr.To = now
+		if sr.Period == "all" {
+			var created time.Time
+			if database.DB.QueryRow("SELECT created_at FROM links WHERE id = ?", linkID).Scan(&created) != nil || created.IsZero() {
+				created = now.Add(-24 * time.Hour)
+			}
+			sr.From = created.In(loc)
+		} else {
+			preset, ok := presetRanges[sr.Period]
+			if !ok {
+				return sr, errors.New("Invalid period")
+			}
+			sr.From = now.Add(-preset.d)
+			sr.Granularity = preset.gran
+		}
+	}
+
+	if g := q.Get("granularity"); g != "" {
+		switch g {
+		case granMinute, gran10Minute, granHour, granDay, granWeek, granMonth:
+			sr.Granularity = g
+		default:
+			return sr, errors.New("granularity inválida (minute, 10minute, hour, day, week, month)")
+		}
+	}
+	if sr.Granularity == "" {
+		sr.Granularity = defaultGranularity(sr.To.Sub(sr.From))
+	}
+	return sr, nil
+}
+
+// truncate alinha t ao início do bucket que o contém, no fuso do intervalo
+func (sr statsRange) truncate(t time.Time) time.Time {
+	t = t.In(sr.Loc)
+	y, m, d := t.Date()
+	switch sr.Granularity {
+	case granMinute:
+		return time.Date(y, m, d, t.Hour(), t.Minute(), 0, 0, sr.Loc)
+	case gran10Minute:
+		return time.Date(y, m, d, t.Hour(), t.Minute()-t.Minute()%10, 0, 0, sr.Loc)
+	case granHour:
+		return time.Date(y, m, d, t.Hour(), 0, 0, 0, sr.Loc)
+	case granWeek:
+		// Semanas começam na segunda-feira
+		offset := (int(t.Weekday()) + 6) % 7
+		return time.Date(y, m, d-offset, 0, 0, 0, 0, sr.Loc)
+	case granMonth:
+		return time.Date(y, m, 1, 0, 0, 0, 0, sr.Loc)
+	}
+	return time.Date(y, m, d, 0, 0, 0, 0, sr.Loc)
+}
+
+// next avança um bucket (dias/semanas/meses pelo calendário, respeitando horário de verão)
+func (sr statsRange) next(t time.Time) time.Time {
+	switch sr.Granularity {
+	case granMinute:
+		return t.Add(time.Minute)
+	case gran10Minute:
+		return t.Add(10 * time.Minute)
+	case granHour:
+		return t.Add(time.Hour)
+	case granWeek:
+		return t.AddDate(0, 0, 7)
+	case granMonth:
+		return t.AddDate(0, 1, 0)
+	}
+	return t.AddDate(0, 0, 1)
+}
+
+// label formata o bucket nos mesmos formatos usados pelos gráficos do dashboard
+func (sr statsRange) label(t time.Time) string {
+	switch sr.Granularity {
+	case granMinute, gran10Minute:
+		if sr.To.Sub(sr.From) > 24*time.Hour {
+			return t.Format("2006-01-02 15:04")
+		}
+		return t.Format("15:04")
+	case granHour:
+		return t.Format("2006-01-02 15:00")
+	case granMonth:
+		return t.Format("2006-01")
+	}
+	return t.Format("2006-01-02")
+}
+
+// buckets devolve o início de cada bucket do intervalo, incluindo os vazios
+func (sr statsRange) buckets() ([]time.Time, error) {
+	var out []time.Time
+	for t := sr.truncate(sr.From); t.Before(sr.To); t = sr.next(t) {
+		out = append(out, t)
+		if len(out) > maxStatsBuckets {
+			return nil, errors.New("intervalo grande demais para esta granularidade")
+		}
+	}
+	return out, nil
+}
+
+// series distribui contagens por minuto (em UTC) nos buckets do intervalo
+func (sr statsRange) series(starts []time.Time, perMinute map[time.Time]int) []int {
+	index := make(map[int64]int, len(starts))
+	for i, s := range starts {
+		index[s.Unix()] = i
+	}
+	data := make([]int, len(starts))
+	for minute, count := range perMinute {
+		if i, ok := index[sr.truncate(minute).Unix()]; ok {
+			data[i] += count
+		}
+	}
+	return data
+}
+
+// utcBounds devolve o intervalo em UTC no formato gravado em accessed_at
+func (sr statsRange) utcBounds() (string, string) {
+	return sr.From.UTC().Format(sqliteTimeFormat), sr.To.UTC().Format(sqliteTimeFormat)
+}
+
+// rollupTable escolhe o rollup que atende o intervalo ("" = ler os access_logs brutos).
+// O diário só serve buckets de dia ou maiores em fuso UTC; o horário serve qualquer fuso
+// com deslocamento de horas inteiras. Minuto e 10 minutos sempre vêm dos logs brutos.
+func (sr statsRange) rollupTable() string {
+	if sr.Granularity == granMinute || sr.Granularity == gran10Minute {
+		return ""
+	}
+	_, offFrom := sr.From.Zone()
+	_, offTo := sr.To.Zone()
+	if sr.Granularity != granHour && offFrom == 0 && offTo == 0 {
+		return "stats_daily"
+	}
+	if offFrom%3600 == 0 && offTo%3600 == 0 {
+		return "stats_hourly"
+	}
+	return ""
+}
+
+// rollupCounts lê views, únicos e retornos do rollup, indexados pelo início (UTC) de cada hora ou dia
+func rollupCounts(linkID string, sr statsRange, table string) (visitCounts, error) {
+	counts := visitCounts{
+		Total:     make(map[time.Time]int),
+		Unique:    make(map[time.Time]int),
+		Returning: make(map[time.Time]int),
+	}
+	layout := sqliteTimeFormat
+	from, to := sr.utcBounds()
+	if table == "stats_daily" {
+		layout = "2006-01-02"
+		fromUTC, toUTC := sr.From.UTC(), sr.To.UTC()
+		// Dia parcial no fim do intervalo (ex: "agora") entra inteiro
+		toDay := time.Date(toUTC.Year(), toUTC.Month(), toUTC.Day(), 0, 0, 0, 0, time.UTC)
+		if toDay.Before(toUTC) {
+			toDay = toDay.AddDate(0, 0, 1)
+		}
+		from, to = fromUTC.Format(layout), toDay.Format(layout)
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT bucket, SUM(views), SUM(uniques), SUM(COALESCE(returning_visits, 0)) FROM `+table+`
+		WHERE link_id = ? AND bucket >= ? AND bucket < ?
+		GROUP BY bucket`, linkID, from, to)
+	if err != nil {
+		return counts, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var bucket string
+		var views, uniques, returning int
+		if rows.Scan(&bucket, &views, &uniques, &returning) != nil {
+			continue
+		}
+		if t, err := time.ParseInLocation(layout, bucket, time.UTC); err == nil {
+			counts.Total[t] += views
+			counts.Unique[t] += uniques
+			counts.Returning[t] += returning
+		}
+	}
+	return counts, rows.Err()
+}
+
+// visitCounts são as contagens de um minuto: hits, visitas únicas e visitas de quem já tinha vindo antes
+type visitCounts struct {
+	Total     map[time.Time]int
+	Unique    map[time.Time]int
+	Returning map[time.Time]int
+}
+
+// minuteCounts agrega os hits servidos do link por minuto UTC dentro do intervalo.
+// Uma visita é única quando o mesmo ip_hash não acessou o link dentro da janela de
+// unicidade (utils.CooldownPeriod, a mesma do anti-F5); é de retorno quando é única
+// mas o ip_hash já tinha acessado o link alguma vez antes. O LAG olha também o
+// histórico anterior a "from" para não tratar como novo quem voltou no período.
+func minuteCounts(linkID string, sr statsRange) (visitCounts, error) {
+	counts := visitCounts{
+		Total:     make(map[time.Time]int),
+		Unique:    make(map[time.Time]int),
+		Returning: make(map[time.Time]int),
+	}
+	from, to := sr.utcBounds()
+	window := utils.CooldownPeriod.Seconds()
+	rows, err := database.DB.Query(`
+		WITH hits AS (
+			SELECT accessed_at,
+				LAG(accessed_at) OVER (PARTITION BY ip_hash ORDER BY accessed_at, id) AS prev_at
+			FROM access_logs
+			WHERE link_id = ? AND status = 'served' AND accessed_at < ?
+		)
+		SELECT strftime('%Y-%m-%d %H:%M', accessed_at) AS minute,
+			COUNT(*),
+			SUM(CASE WHEN prev_at IS NULL OR (julianday(accessed_at) - julianday(prev_at)) * 86400 >= ? THEN 1 ELSE 0 END),
+			SUM(CASE WHEN prev_at IS NOT NULL AND (julianday(accessed_at) - julianday(prev_at)) * 86400 >= ? THEN 1 ELSE 0 END)
+		FROM hits
+		WHERE accessed_at >= ?
+		GROUP BY minute`, linkID, to, window, window, from)
+	if err != nil {
+		return counts, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var minute string
+		var total, unique, returning int
+		if rows.Scan(&minute, &total, &unique, &returning) != nil {
+			continue
+		}
+		if t, err := time.ParseInLocation("2006-01-02 15:04", strings.TrimSpace(minute), time.UTC); err == nil {
+			counts.Total[t] += total
+			counts.Unique[t] += unique
+			counts.Returning[t] += returning
+		}
+	}
+	return counts, rows.Err()
+}
+
+// loadVisitCounts lê a série dos rollups quando o intervalo permite (sobrevivem à retenção
+// dos logs); só granularidades abaixo de hora, ou fusos fracionados, varrem os logs brutos
+func loadVisitCounts(linkID string, sr statsRange) (visitCounts, error) {
+	if table := sr.rollupTable(); table != "" {
+		return rollupCounts(linkID, sr, table)
+	}
+	return minuteCounts(linkID, sr)
+}
+
+// visitorSummary resume o intervalo: visitantes distintos, novos vs. de retorno e views por visitante.
+// Os indicadores de visitantes dependem do ip_hash dos logs brutos, então só cobrem a partir de
+// VisitorsSince (início do intervalo ou da retenção de LOG_RETENTION_DAYS, o que for mais recente).
+type visitorSummary struct {
+	Total              int     `json:"total"`
+	Unique             int     `json:"unique"`
+	Visitors           int     `json:"visitors"`
+	NewVisitors        int     `json:"new_visitors"`
+	ReturningVisitors  int     `json:"returning_visitors"`
+	NewVsReturning     float64 `json:"new_vs_returning"`
+	ReturningPct       float64 `json:"returning_pct"`
+	AvgViewsPerVisitor float64 `json:"avg_views_per_visitor"`
+	VisitorsSince      string  `json:"visitors_since"`
+	VisitorsPartial    bool    `json:"visitors_partial"`
+}
+
+// logRetentionStart é o acesso mais antigo que ainda pode existir em access_logs
+// (mesma regra de LOG_RETENTION_DAYS da limpeza em segundo plano)
+func logRetentionStart(now time.Time) time.Time {
+	days := utils.EnvInt("LOG_RETENTION_DAYS", 90)
+	if days <= 0 {
+		days = 90
+	}
+	return now.UTC().AddDate(0, 0, -days)
+}
+
+// summarizeVisitors calcula em SQL os indicadores de visitantes do intervalo, limitado à
+// janela de retenção dos logs brutos. Novo = ip_hash cujo primeiro acesso retido ao link
+// caiu dentro do intervalo; de retorno = ip_hash que já tinha acessado antes e voltou.
+func summarizeVisitors(linkID string, sr statsRange) (visitorSummary, error) {
+	var v visitorSummary
+	from, to := sr.utcBounds()
+	retained := logRetentionStart(time.Now())
+	since := sr.From.UTC()
+	if since.Before(retained) {
+		since, v.VisitorsPartial = retained, true
+		from = since.Format(sqliteTimeFormat)
+	}
+	v.VisitorsSince = since.In(sr.Loc).Format(time.RFC3339)
+	err := database.DB.QueryRow(`
+		WITH visitors AS (
+			SELECT ip_hash,
+				SUM(CASE WHEN accessed_at >= ? THEN 1 ELSE 0 END) AS views_in_range,
+				MIN(accessed_at) AS first_seen
+			FROM access_logs
+			WHERE link_id = ? AND status = 'served' AND accessed_at >= ? AND accessed_at < ?
+			GROUP BY ip_hash
+		)
+		SELECT
+			COUNT(*),
+			COALESCE(SUM(views_in_range), 0),
+			COALESCE(SUM(CASE WHEN first_seen >= ? THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN first_seen < ? THEN 1 ELSE 0 END), 0),
+			COALESCE(ROUND(CAST(SUM(views_in_range) AS REAL) / COUNT(*), 2), 0)
+		FROM visitors
+		WHERE views_in_range > 0`, from, linkID, retained.Format(sqliteTimeFormat), to, from, from).
+		Scan(&v.Visitors, &v.Total, &v.NewVisitors, &v.ReturningVisitors, &v.AvgViewsPerVisitor)
+	if err != nil {
+		return v, err
+	}
+	if v.ReturningVisitors > 0 {
+		v.NewVsReturning = math.Round(float64(v.NewVisitors)/float64(v.ReturningVisitors)*100) / 100
+	}
+	if v.Visitors > 0 {
+		v.ReturningPct = math.Round(float64(v.ReturningVisitors)/float64(v.Visitors)*1000) / 10
+	}
+	return v, nil
+}
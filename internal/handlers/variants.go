@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"strconv"
+
+	"crom-vision/internal/database"
+)
+
+// linkVariant representa uma das imagens servidas alternadamente pelo mesmo /i/:id
+type linkVariant struct {
+	ID       int64
+	Label    string
+	FilePath string
+	Weight   int
+}
+
+// loadVariants retorna as variantes cadastradas para o link (vazio se não houver teste A/B)
+func loadVariants(linkID string) ([]linkVariant, error) {
+	rows, err := database.DB.Query("SELECT id, label, file_path, weight FROM link_variants WHERE link_id = ? ORDER BY id ASC", linkID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var variants []linkVariant
+	for rows.Next() {
+		var v linkVariant
+		if err := rows.Scan(&v.ID, &v.Label, &v.FilePath, &v.Weight); err != nil {
+			return nil, err
+		}
+		if v.Weight < 0 {
+			v.Weight = 0
+		}
+		variants = append(variants, v)
+	}
+	return variants, rows.Err()
+}
+
+// pickVariant escolhe a variante a ser servida.
+// Modo "sticky": o mesmo fingerprint sempre recebe a mesma variante (hash → faixa de peso).
+// Modo "weighted" (padrão): sorteio proporcional ao peso a cada requisição.
+func pickVariant(variants []linkVariant, mode, fingerprintHash string) *linkVariant {
+	totalWeight := 0
+	for _, v := range variants {
+		totalWeight += v.Weight
+	}
+	if totalWeight == 0 {
+		return nil
+	}
+
+	var slot int
+	if mode == "sticky" && len(fingerprintHash) >= 8 {
+		n, err := strconv.ParseUint(fingerprintHash[:8], 16, 32)
+		if err != nil {
+			slot = rand.Intn(totalWeight)
+		} else {
+			slot = int(n % uint64(totalWeight))
+		}
+	} else {
+		slot = rand.Intn(totalWeight)
+	}
+
+	for i := range variants {
+		if slot < variants[i].Weight {
+			return &variants[i]
+		}
+		slot -= variants[i].Weight
+	}
+	return nil
+}
+
+// LinkVariantsHandler retorna as estatísticas por variante de um link com teste A/B
+// GET /api/link-variants?id=xxx[&password=yyy]
+// Exige a senha, a sessão do dono ou um share token com o escopo variants.
+func LinkVariantsHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "ID missing", http.StatusBadRequest)
+		return
+	}
+	if !authorizeStats(w, r, id, scopeVariants) {
+		return
+	}
+
+	var mode string
+	if err := database.DB.QueryRow("SELECT COALESCE(variant_mode, 'weighted') FROM links WHERE id = ?", id).Scan(&mode); err != nil {
+		http.Error(w, "Asset not found", http.StatusNotFound)
+		return
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT id, label, weight, total_views, unique_views
+		FROM link_variants WHERE link_id = ? ORDER BY id ASC`, id)
+	if err != nil {
+		http.Error(w, "DB Error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	type variantStats struct {
+		ID          int64   `json:"id"`
+		Label       string  `json:"label"`
+		Weight      int     `json:"weight"`
+		TotalViews  int     `json:"total_views"`
+		UniqueViews int     `json:"unique_views"`
+		Share       float64 `json:"share"`
+	}
+
+	variants := []variantStats{}
+	sum := 0
+	for rows.Next() {
+		var v variantStats
+		rows.Scan(&v.ID, &v.Label, &v.Weight, &v.TotalViews, &v.UniqueViews)
+		sum += v.TotalViews
+		variants = append(variants, v)
+	}
+	for i := range variants {
+		if sum > 0 {
+			variants[i].Share = float64(variants[i].TotalViews) / float64(sum)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":       id,
+		"mode":     mode,
+		"variants": variants,
+	})
+}
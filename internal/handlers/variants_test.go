@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"crom-vision/internal/database"
+)
+
+func TestPickVariant_Weighted(t *testing.T) {
+	variants := []linkVariant{
+		{ID: 1, Label: "A", Weight: 0},
+		{ID: 2, Label: "B", Weight: 5},
+	}
+	for i := 0; i < 50; i++ {
+		v := pickVariant(variants, "weighted", "")
+		if v == nil || v.Label != "B" {
+			t.Fatalf("variante com peso 0 nunca deve ser escolhida, obteve %+v", v)
+		}
+	}
+}
+
+func TestPickVariant_Sticky(t *testing.T) {
+	variants := []linkVariant{
+		{ID: 1, Label: "A", Weight: 1},
+		{ID: 2, Label: "B", Weight: 1},
+		{ID: 3, Label: "C", Weight: 1},
+	}
+	fp := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	first := pickVariant(variants, "sticky", fp)
+	if first == nil {
+		t.Fatal("deveria escolher uma variante")
+	}
+	for i := 0; i < 20; i++ {
+		if v := pickVariant(variants, "sticky", fp); v.ID != first.ID {
+			t.Fatalf("modo sticky deve repetir a variante: %s vs %s", first.Label, v.Label)
+		}
+	}
+}
+
+func TestPickVariant_NoWeight(t *testing.T) {
+	if v := pickVariant([]linkVariant{{ID: 1, Weight: 0}}, "weighted", ""); v != nil {
+		t.Errorf("sem peso total nenhuma variante deve ser escolhida, obteve %+v", v)
+	}
+}
+
+func TestImageHandler_ServesVariant(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+
+	tmpDir := t.TempDir()
+	fileA := filepath.Join(tmpDir, "a.png")
+	fileB := filepath.Join(tmpDir, "b.png")
+	os.WriteFile(fileA, []byte("variant-a"), 0644)
+	os.WriteFile(fileB, []byte("variant-b"), 0644)
+
+	insertLivePasswordLink(t, "test_ab")
+	database.DB.Exec("UPDATE links SET variant_mode = 'sticky' WHERE id = 'test_ab'")
+	database.DB.Exec("INSERT INTO link_variants (link_id, label, file_path, weight) VALUES ('test_ab', 'A', ?, 0)", fileA)
+	database.DB.Exec("INSERT INTO link_variants (link_id, label, file_path, weight) VALUES ('test_ab', 'B', ?, 1)", fileB)
+
+	req := httptest.NewRequest(http.MethodGet, "/i/test_ab", nil)
+	w := httptest.NewRecorder()
+	ImageHandler(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("esperava 200 (variante tem prioridade sobre redirect), obteve %d", w.Code)
+	}
+	body, _ := io.ReadAll(w.Result().Body)
+	if string(body) != "variant-b" {
+		t.Errorf("esperava conteúdo da variante B, obteve %q", string(body))
+	}
+	if w.Header().Get("X-Crom-Variant") != "B" {
+		t.Errorf("esperava X-Crom-Variant=B, obteve %q", w.Header().Get("X-Crom-Variant"))
+	}
+
+	// A contabilização roda em goroutine
+	time.Sleep(100 * time.Millisecond)
+
+	req = httptest.NewRequest(http.MethodGet, "/api/link-variants?id=test_ab&password=123", nil)
+	w = httptest.NewRecorder()
+	LinkVariantsHandler(w, req)
+
+	var resp struct {
+		Mode     string `json:"mode"`
+		Variants []struct {
+			Label      string `json:"label"`
+			TotalViews int    `json:"total_views"`
+		} `json:"variants"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.Mode != "sticky" || len(resp.Variants) != 2 {
+		t.Fatalf("resposta inesperada: %s", w.Body.String())
+	}
+	if resp.Variants[1].TotalViews != 1 || resp.Variants[0].TotalViews != 0 {
+		t.Errorf("views por variante incorretas: %s", w.Body.String())
+	}
+
+	var logged int
+	database.DB.QueryRow("SELECT COUNT(*) FROM access_logs WHERE link_id = 'test_ab' AND variant_id IS NOT NULL").Scan(&logged)
+	if logged != 1 {
+		t.Errorf("access_log deveria registrar a variante, count=%d", logged)
+	}
+}
+
+func TestLinkVariantsHandler_UnknownLink(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+
+	// Sem credencial válida, um link inexistente responde como qualquer outro
+	req := httptest.NewRequest(http.MethodGet, "/api/link-variants?id=nada&password=123", nil)
+	w := httptest.NewRecorder()
+	LinkVariantsHandler(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("esperava 401, obteve %d", w.Code)
+	}
+}
+
+func TestCheckoutHandler_Variants(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	os.MkdirAll(os.Getenv("STORAGE_PATH"), 0755)
+
+	gif := []byte("GIF89a\x01\x00\x01\x00\x80\x00\x00")
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("tier", "7d")
+	writer.WriteField("variant_weights", "70,30")
+	writer.WriteField("variant_labels", "Azul,Verde")
+	writer.WriteField("variant_mode", "sticky")
+	for _, name := range []string{"azul.gif", "verde.gif"} {
+		part, _ := writer.CreateFormFile("variants", name)
+		part.Write(gif)
+		part.Write(make([]byte, 512))
+	}
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/checkout", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	CheckoutHandler(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("esperava 200, obteve %d — body: %s", w.Code, w.Body.String())
+	}
+
+	var resp map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp["variants"] != float64(2) {
+		t.Errorf("esperava 2 variantes, obteve %v", resp["variants"])
+	}
+
+	id := resp["id"].(string)
+	var mode, filePath string
+	database.DB.QueryRow("SELECT variant_mode, file_path FROM links WHERE id = ?", id).Scan(&mode, &filePath)
+	if mode != "sticky" {
+		t.Errorf("esperava variant_mode=sticky, obteve %q", mode)
+	}
+	if filePath == "" {
+		t.Error("sem imagem principal, a primeira variante deve virar o file_path do link")
+	}
+
+	var weight int
+	database.DB.QueryRow("SELECT weight FROM link_variants WHERE link_id = ? AND label = 'Azul'", id).Scan(&weight)
+	if weight != 70 {
+		t.Errorf("esperava peso 70 para Azul, obteve %d", weight)
+	}
+}
@@ -82,10 +82,19 @@ func WebhookMPHandler(w http.ResponseWriter, r *http.Request) {
 
 // activateLink marca um link como aprovado e notifica o dono
 func activateLink(linkID string) {
-	database.DB.Exec("UPDATE links SET payment_status = 'approved' WHERE id = ?", linkID)
+	// O IPN do MP e o polling podem confirmar o mesmo pagamento várias vezes:
+	// e-mail e evento payment_approved só saem na transição para approved
+	res, err := database.DB.Exec("UPDATE links SET payment_status = 'approved' WHERE id = ? AND COALESCE(payment_status, '') != 'approved'", linkID)
+	if err != nil {
+		log.Printf("[MP ERR] Falha ativando link %s: %v", linkID, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n != 1 {
+		return
+	}
 
 	var email sql.NullString
-	err := database.DB.QueryRow("SELECT email FROM links WHERE id = ?", linkID).Scan(&email)
+	err = database.DB.QueryRow("SELECT email FROM links WHERE id = ?", linkID).Scan(&email)
 	if err == nil && email.Valid && email.String != "" {
 		baseURL := os.Getenv("BASE_URL")
 		if baseURL == "" {
@@ -95,5 +104,6 @@ func activateLink(linkID string) {
 		<p>Seu proxy tracker <b>%s</b> foi pago e ativado com sucesso.</p>
 		<p>Acesse seu Dashboard: <a href="%s/?dashboard=%s">%s/?dashboard=%s</a></p>`, linkID, baseURL, linkID, baseURL, linkID))
 	}
+	services.EnqueueLinkEvent(linkID, services.EventPaymentApproved, nil)
 	log.Printf("[💰 Pagamento Ativado] Link %s liberado!", linkID)
 }
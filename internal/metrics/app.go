@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Métricas da aplicação, atualizadas por handlers e serviços
+var (
+	HTTPRequests = NewCounterVec("crom_http_requests_total",
+		"Requisições HTTP por rota (padrão do mux) e status.", "route", "status")
+	PixelHits = NewCounterVec("crom_pixel_hits_total",
+		"Hits no pixel /i/ pelo desfecho (X-Crom-Status; Served quando a imagem foi entregue).", "outcome")
+	IngestQueueDepth = NewGauge("crom_ingest_queue_depth",
+		"Hits aceitos aguardando gravação (contadores, access_logs e rollups).")
+	MercadoPagoDuration = NewHistogramVec("crom_mercadopago_request_duration_seconds",
+		"Latência das chamadas à API do Mercado Pago.", DefaultLatencyBuckets, "operation")
+	MercadoPagoErrors = NewCounterVec("crom_mercadopago_errors_total",
+		"Chamadas ao Mercado Pago que falharam (rede ou status inesperado).", "operation")
+	EmailsSent = NewCounterVec("crom_emails_total",
+		"E-mails por resultado do envio SMTP (success, failure, skipped).", "result")
+	CleanupRuns = NewCounterVec("crom_cleanup_runs_total",
+		"Execuções do HardDeleteExpired por resultado.", "result")
+	CleanupDeleted = NewCounterVec("crom_cleanup_deleted_total",
+		"Registros removidos pelo HardDeleteExpired (links expirados, logs dos links, logs por retenção).", "kind")
+	CleanupLastRun = NewGauge("crom_cleanup_last_run_timestamp_seconds",
+		"Unix timestamp da última execução do HardDeleteExpired.")
+	StorageBytes = NewGaugeFunc("crom_storage_bytes",
+		"Bytes ocupados pelos arquivos em STORAGE_PATH (recalculado no máximo a cada minuto).", storageBytes)
+)
+
+var storageCache struct {
+	sync.Mutex
+	at    time.Time
+	bytes float64
+}
+
+// storageBytes soma o tamanho dos arquivos do storage, com cache para não varrer o disco a cada coleta
+func storageBytes() float64 {
+	storageCache.Lock()
+	defer storageCache.Unlock()
+	if time.Since(storageCache.at) < time.Minute {
+		return storageCache.bytes
+	}
+	root := os.Getenv("STORAGE_PATH")
+	if root == "" {
+		root = "./storage"
+	}
+	var total int64
+	filepath.WalkDir(root, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			total += info.Size()
+		}
+		return nil
+	})
+	storageCache.at, storageCache.bytes = time.Now(), float64(total)
+	return storageCache.bytes
+}
@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteText_CounterAndHistogram(t *testing.T) {
+	c := &CounterVec{name: "t_total", help: "teste", labels: []string{"route"}, values: map[string]float64{}, order: map[string][]string{}}
+	c.Inc(`/a"b`)
+	c.Add(2, "/i/")
+	c.Add(-1, "/i/") // contadores não descem
+	h := &HistogramVec{name: "t_seconds", help: "teste", labels: []string{"op"}, buckets: []float64{0.1, 1}, series: map[string]*histogramSeries{}}
+	h.Observe(0.05, "x")
+	h.Observe(0.5, "x")
+	h.Observe(3, "x")
+
+	var buf bytes.Buffer
+	c.write(&buf)
+	h.write(&buf)
+	out := buf.String()
+	for _, want := range []string{
+		"# TYPE t_total counter\n",
+		`t_total{route="/a\"b"} 1` + "\n",
+		`t_total{route="/i/"} 2` + "\n",
+		"# TYPE t_seconds histogram\n",
+		`t_seconds_bucket{op="x",le="0.1"} 1` + "\n",
+		`t_seconds_bucket{op="x",le="1"} 2` + "\n",
+		`t_seconds_bucket{op="x",le="+Inf"} 3` + "\n",
+		`t_seconds_sum{op="x"} 3.55` + "\n",
+		`t_seconds_count{op="x"} 3` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("saída sem %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestInstrumentMux_UsesRoutePattern(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/i/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	h := InstrumentMux(mux)
+
+	before := HTTPRequests.Value("/i/", "418")
+	for _, path := range []string{"/i/abc", "/i/def", "/ok"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	if got := HTTPRequests.Value("/i/", "418") - before; got != 2 {
+		t.Errorf("esperava 2 requisições em /i/ com 418, obteve %v", got)
+	}
+	if HTTPRequests.Value("/ok", "200") < 1 {
+		t.Error("status padrão deveria ser 200")
+	}
+	if HTTPRequests.Value("/i/abc", "418") != 0 {
+		t.Error("o label route deve usar o padrão do mux, não o caminho")
+	}
+}
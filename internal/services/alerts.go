@@ -0,0 +1,71 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+
+	"crom-vision/internal/database"
+)
+
+// alertMailer é substituível nos testes
+var alertMailer = SendEmail
+
+type linkAlerts struct {
+	Email        string
+	FirstOpen    bool
+	Threshold    int
+	NearLimitPct int
+}
+
+// claimAlert marca o alerta como enviado apenas se ainda não foi; com acessos
+// concorrentes só um dos hits ganha o UPDATE e dispara o e-mail
+func claimAlert(linkID, column string) bool {
+	res, err := database.DB.Exec("UPDATE links SET "+column+" = ? WHERE id = ? AND "+column+" IS NULL",
+		time.Now().UTC().Format("2006-01-02 15:04:05"), linkID)
+	if err != nil {
+		return false
+	}
+	n, _ := res.RowsAffected()
+	return n == 1
+}
+
+// CheckViewAlerts envia os alertas de primeira abertura, marco de visualizações
+// e proximidade do limite. totalViews é o valor exato após o incremento deste hit.
+func CheckViewAlerts(linkID string, totalViews, maxViews int) {
+	var email sql.NullString
+	var a linkAlerts
+	err := database.DB.QueryRow(`
+		SELECT email, COALESCE(alert_first_open, 0), COALESCE(alert_threshold, 0), COALESCE(alert_near_limit_pct, 0)
+		FROM links WHERE id = ?`, linkID).Scan(&email, &a.FirstOpen, &a.Threshold, &a.NearLimitPct)
+	if err != nil || !email.Valid || email.String == "" {
+		return
+	}
+	if !a.FirstOpen && a.Threshold <= 0 && a.NearLimitPct <= 0 {
+		return
+	}
+	a.Email = email.String
+
+	baseURL := os.Getenv("BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:8080"
+	}
+	dashboard := fmt.Sprintf(`<p>Acompanhe no Dashboard: <a href="%s/?dashboard=%s">%s/?dashboard=%s</a></p>`, baseURL, linkID, baseURL, linkID)
+
+	if a.FirstOpen && totalViews >= 1 && claimAlert(linkID, "alert_first_open_sent_at") {
+		go alertMailer(a.Email, "Crom-Vision - Sua imagem foi aberta!", fmt.Sprintf(`<h2>Primeira abertura</h2>
+		<p>O ativo <b>%s</b> acabou de ser visualizado pela primeira vez.</p>%s`, linkID, dashboard))
+	}
+
+	if a.Threshold > 0 && totalViews >= a.Threshold && claimAlert(linkID, "alert_threshold_sent_at") {
+		go alertMailer(a.Email, fmt.Sprintf("Crom-Vision - %d visualizações atingidas", a.Threshold), fmt.Sprintf(`<h2>Marco atingido</h2>
+		<p>O ativo <b>%s</b> chegou a <b>%d</b> visualizações.</p>%s`, linkID, totalViews, dashboard))
+	}
+
+	if a.NearLimitPct > 0 && maxViews > 0 && totalViews*100 >= maxViews*a.NearLimitPct && claimAlert(linkID, "alert_near_limit_sent_at") {
+		go alertMailer(a.Email, "Crom-Vision - Limite de visualizações próximo", fmt.Sprintf(`<h2>Quase no limite</h2>
+		<p>O ativo <b>%s</b> já usou <b>%d de %d</b> visualizações (%d%%). Ao atingir o limite, a imagem deixa de ser entregue.</p>%s`,
+			linkID, totalViews, maxViews, totalViews*100/maxViews, dashboard))
+	}
+}
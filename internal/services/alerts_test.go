@@ -0,0 +1,96 @@
+package services
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"crom-vision/internal/database"
+)
+
+type sentMail struct{ to, subject string }
+
+// captureAlerts troca o envio real por um coletor e devolve a função de leitura
+func captureAlerts(t *testing.T) func() []sentMail {
+	t.Helper()
+	var mu sync.Mutex
+	var sent []sentMail
+	alertMailer = func(to, subject, body string) {
+		mu.Lock()
+		sent = append(sent, sentMail{to, subject})
+		mu.Unlock()
+	}
+	t.Cleanup(func() { alertMailer = SendEmail })
+	return func() []sentMail {
+		time.Sleep(50 * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]sentMail(nil), sent...)
+	}
+}
+
+func TestCheckViewAlerts_FiresOnce(t *testing.T) {
+	cleanup, _ := setupBgDB(t)
+	defer cleanup()
+	read := captureAlerts(t)
+
+	database.DB.Exec(`INSERT INTO links (id, email, max_views, expires_at, alert_first_open, alert_threshold, alert_near_limit_pct)
+		VALUES ('al1', 'dono@test.com', 10, datetime('now', '+7 days'), 1, 3, 80)`)
+
+	for total := 1; total <= 10; total++ {
+		CheckViewAlerts("al1", total, 10)
+	}
+
+	sent := read()
+	if len(sent) != 3 {
+		t.Fatalf("esperava 3 alertas (abertura, marco, limite), obteve %d: %v", len(sent), sent)
+	}
+	for _, m := range sent {
+		if m.to != "dono@test.com" {
+			t.Errorf("destinatário inesperado: %s", m.to)
+		}
+	}
+
+	var nearSent string
+	database.DB.QueryRow("SELECT COALESCE(alert_near_limit_sent_at, '') FROM links WHERE id = 'al1'").Scan(&nearSent)
+	if nearSent == "" {
+		t.Error("alert_near_limit_sent_at deveria estar preenchido")
+	}
+}
+
+func TestCheckViewAlerts_SkipsWithoutEmailOrConfig(t *testing.T) {
+	cleanup, _ := setupBgDB(t)
+	defer cleanup()
+	read := captureAlerts(t)
+
+	database.DB.Exec(`INSERT INTO links (id, expires_at, alert_first_open) VALUES ('al2', datetime('now', '+7 days'), 1)`)
+	database.DB.Exec(`INSERT INTO links (id, email, expires_at) VALUES ('al3', 'x@test.com', datetime('now', '+7 days'))`)
+	CheckViewAlerts("al2", 1, 0)
+	CheckViewAlerts("al3", 1, 0)
+
+	if sent := read(); len(sent) != 0 {
+		t.Errorf("nenhum alerta deveria ser enviado, obteve %v", sent)
+	}
+}
+
+func TestCheckViewAlerts_ConcurrentHitsSendOnce(t *testing.T) {
+	cleanup, _ := setupBgDB(t)
+	defer cleanup()
+	read := captureAlerts(t)
+
+	database.DB.Exec(`INSERT INTO links (id, email, expires_at, alert_threshold) VALUES ('al4', 'x@test.com', datetime('now', '+7 days'), 5)`)
+
+	var wg sync.WaitGroup
+	for total := 5; total < 15; total++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			CheckViewAlerts("al4", n, 0)
+		}(total)
+	}
+	wg.Wait()
+
+	if sent := read(); len(sent) != 1 {
+		t.Errorf("marco deveria disparar uma única vez, obteve %d", len(sent))
+	}
+}
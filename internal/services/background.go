@@ -7,6 +7,8 @@ import (
 	"time"
 
 	"crom-vision/internal/database"
+	"crom-vision/internal/metrics"
+	"crom-vision/internal/utils"
 )
 
 func HardDeleteExpired() {
@@ -24,7 +26,10 @@ func HardDeleteExpired() {
 		}
 
 		// 1. Coletar os file_paths primeiro antes de apagar
-		rows, err := database.DB.Query(`SELECT file_path FROM links WHERE expires_at IS NOT NULL AND expires_at < CURRENT_TIMESTAMP AND file_path IS NOT NULL AND file_path != ''`)
+		rows, err := database.DB.Query(`
+			SELECT file_path FROM links WHERE expires_at IS NOT NULL AND expires_at < CURRENT_TIMESTAMP AND file_path IS NOT NULL AND file_path != ''
+			UNION
+			SELECT file_path FROM link_variants WHERE link_id IN (SELECT id FROM links WHERE expires_at IS NOT NULL AND expires_at < CURRENT_TIMESTAMP) AND file_path IS NOT NULL AND file_path != ''`)
 		if err == nil {
 			for rows.Next() {
 				var fPath string
@@ -39,23 +44,56 @@ func HardDeleteExpired() {
 						fullPath = storagePath + "/" + fPath
 					}
 					os.Remove(fullPath)
+					utils.RemoveRenditions(fPath)
 				}
 			}
 			rows.Close()
 		}
 
+		// Avisar os webhooks antes de apagar (a entrega guarda URL e segredo próprios)
+		expRows, err := database.DB.Query(`SELECT id, total_views, unique_views FROM links WHERE expires_at IS NOT NULL AND expires_at < CURRENT_TIMESTAMP`)
+		if err == nil {
+			type expiredLink struct {
+				id          string
+				total, uniq int
+			}
+			var expired []expiredLink
+			for expRows.Next() {
+				var el expiredLink
+				if expRows.Scan(&el.id, &el.total, &el.uniq) == nil {
+					expired = append(expired, el)
+				}
+			}
+			expRows.Close()
+			for _, el := range expired {
+				EnqueueLinkEvent(el.id, EventExpired, map[string]interface{}{"total_views": el.total, "unique_views": el.uniq})
+			}
+		}
+
 		// 2. Apagar links expirados e seus logs
 		res, err := database.DB.Exec(`DELETE FROM access_logs WHERE link_id IN (SELECT id FROM links WHERE expires_at IS NOT NULL AND expires_at < CURRENT_TIMESTAMP)`)
 		if err == nil {
 			rowsLog, _ := res.RowsAffected()
+			database.DB.Exec(`DELETE FROM link_variants WHERE link_id IN (SELECT id FROM links WHERE expires_at IS NOT NULL AND expires_at < CURRENT_TIMESTAMP)`)
+			database.DB.Exec(`DELETE FROM read_sessions WHERE link_id IN (SELECT id FROM links WHERE expires_at IS NOT NULL AND expires_at < CURRENT_TIMESTAMP)`)
+			database.DB.Exec(`DELETE FROM webhooks WHERE link_id IN (SELECT id FROM links WHERE expires_at IS NOT NULL AND expires_at < CURRENT_TIMESTAMP)`)
+			database.DB.Exec(`DELETE FROM share_tokens WHERE link_id IN (SELECT id FROM links WHERE expires_at IS NOT NULL AND expires_at < CURRENT_TIMESTAMP)`)
+			database.DB.Exec(`DELETE FROM stats_hourly WHERE link_id IN (SELECT id FROM links WHERE expires_at IS NOT NULL AND expires_at < CURRENT_TIMESTAMP)`)
+			database.DB.Exec(`DELETE FROM stats_daily WHERE link_id IN (SELECT id FROM links WHERE expires_at IS NOT NULL AND expires_at < CURRENT_TIMESTAMP)`)
+			database.DB.Exec(`DELETE FROM stats_visitors WHERE link_id IN (SELECT id FROM links WHERE expires_at IS NOT NULL AND expires_at < CURRENT_TIMESTAMP)`)
 			resLinks, _ := database.DB.Exec(`DELETE FROM links WHERE expires_at IS NOT NULL AND expires_at < CURRENT_TIMESTAMP`)
 			rowsLinks, _ := resLinks.RowsAffected()
 			if rowsLog > 0 || rowsLinks > 0 {
 				log.Printf("[🧹 AUDITORIA] Hard Delete: %d links expirados, %d logs removidos, arquivos de imagem apagados", rowsLinks, rowsLog)
 			}
+			metrics.CleanupDeleted.Add(float64(rowsLinks), "links")
+			metrics.CleanupDeleted.Add(float64(rowsLog), "link_logs")
+			metrics.CleanupRuns.Inc("success")
 		} else {
 			log.Printf("[CRIT] Falha varrendo banco: %v", err)
+			metrics.CleanupRuns.Inc("failure")
 		}
+		metrics.CleanupLastRun.Set(float64(time.Now().Unix()))
 
 		// 3. Política de retenção: apagar logs antigos independente do link
 		resOld, err := database.DB.Exec(`DELETE FROM access_logs WHERE accessed_at < datetime('now', '-' || ? || ' days')`, maxDays)
@@ -64,6 +102,14 @@ func HardDeleteExpired() {
 			if oldRows > 0 {
 				log.Printf("[🧹 RETENÇÃO] %d logs com mais de %d dias removidos", oldRows, maxDays)
 			}
+			metrics.CleanupDeleted.Add(float64(oldRows), "retention_logs")
 		}
+		database.DB.Exec(`DELETE FROM read_sessions WHERE started_at < datetime('now', '-' || ? || ' days')`, maxDays)
+		database.DB.Exec(`DELETE FROM webhook_deliveries WHERE status != 'pending' AND created_at < datetime('now', '-' || ? || ' days')`, maxDays)
+
+		// 4. Sessões e links de acesso (login) vencidos
+		now := time.Now().UTC().Format(sqliteTime)
+		database.DB.Exec(`DELETE FROM sessions WHERE expires_at < ?`, now)
+		database.DB.Exec(`DELETE FROM login_tokens WHERE expires_at < ?`, now)
 	}
 }
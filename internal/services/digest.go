@@ -0,0 +1,319 @@
+package services
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"crom-vision/internal/database"
+	"crom-vision/internal/utils"
+)
+
+const (
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+// digestMailer é substituível nos testes
+var digestMailer = SendEmailWithInline
+
+const sqliteTime = "2006-01-02 15:04:05"
+
+// DigestUnsubscribeToken assina o e-mail para o link de descadastro
+func DigestUnsubscribeToken(email string) string {
+	salt := os.Getenv("APP_SALT")
+	if salt == "" {
+		salt = "default_salt"
+	}
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write([]byte("digest-unsubscribe:" + strings.ToLower(strings.TrimSpace(email))))
+	return hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+// VerifyDigestUnsubscribe confere o token do link de descadastro
+func VerifyDigestUnsubscribe(email, token string) bool {
+	return email != "" && hmac.Equal([]byte(DigestUnsubscribeToken(email)), []byte(token))
+}
+
+// SubscribeDigest ativa (ou altera) o resumo periódico do e-mail. O primeiro envio
+// acontece no próximo horário agendado, nunca imediatamente.
+func SubscribeDigest(email, frequency string) error {
+	if frequency != DigestDaily && frequency != DigestWeekly {
+		return errors.New("frequência inválida (use daily ou weekly)")
+	}
+	_, err := database.DB.Exec(`
+		INSERT INTO digest_subscriptions (email, frequency, last_sent_at) VALUES (?, ?, ?)
+		ON CONFLICT(email) DO UPDATE SET frequency = excluded.frequency`,
+		strings.ToLower(strings.TrimSpace(email)), frequency, time.Now().UTC().Format(sqliteTime))
+	return err
+}
+
+// UnsubscribeDigest desativa o resumo do e-mail
+func UnsubscribeDigest(email string) {
+	database.DB.Exec("DELETE FROM digest_subscriptions WHERE email = ?", strings.ToLower(strings.TrimSpace(email)))
+}
+
+// digestSlot devolve o horário agendado mais recente <= now: todo dia na hora
+// DIGEST_HOUR_UTC (diário) ou toda segunda-feira nessa hora (semanal)
+func digestSlot(frequency string, now time.Time, hourUTC int) time.Time {
+	now = now.UTC()
+	slot := time.Date(now.Year(), now.Month(), now.Day(), hourUTC, 0, 0, 0, time.UTC)
+	if slot.After(now) {
+		slot = slot.AddDate(0, 0, -1)
+	}
+	if frequency == DigestWeekly {
+		for slot.Weekday() != time.Monday {
+			slot = slot.AddDate(0, 0, -1)
+		}
+	}
+	return slot
+}
+
+type digestLink struct {
+	ID           string
+	Views        int
+	Uniques      int
+	PrevViews    int
+	TopCountries []string
+}
+
+// trend descreve a variação em relação ao período anterior
+func (l digestLink) trend() string {
+	switch {
+	case l.PrevViews == 0 && l.Views == 0:
+		return "—"
+	case l.PrevViews == 0:
+		return "novo"
+	}
+	pct := (l.Views - l.PrevViews) * 100 / l.PrevViews
+	if pct > 0 {
+		return fmt.Sprintf("▲ %d%%", pct)
+	}
+	if pct < 0 {
+		return fmt.Sprintf("▼ %d%%", -pct)
+	}
+	return "= 0%"
+}
+
+// digestPeriod devolve a janela coberta e a largura de cada barra do gráfico
+func digestPeriod(frequency string, end time.Time) (time.Time, time.Duration, int) {
+	if frequency == DigestWeekly {
+		return end.AddDate(0, 0, -7), 24 * time.Hour, 7
+	}
+	return end.Add(-24 * time.Hour), time.Hour, 24
+}
+
+// collectDigest resume os links ativos do e-mail na janela [start, end) e monta a série do gráfico
+func collectDigest(email string, start, end time.Time, bucket time.Duration, buckets int) ([]digestLink, []int) {
+	rows, err := database.DB.Query(`
+		SELECT id FROM links
+		WHERE LOWER(email) = ? AND payment_status = 'approved' AND (expires_at IS NULL OR expires_at > ?)
+		ORDER BY created_at ASC`, email, end.Format(sqliteTime))
+	if err != nil {
+		return nil, nil
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	prevStart := start.Add(-end.Sub(start))
+	series := make([]int, buckets)
+	var links []digestLink
+	for _, id := range ids {
+		l := digestLink{ID: id}
+		database.DB.QueryRow(`
+			SELECT COUNT(*), COALESCE(SUM(is_unique), 0) FROM access_logs
+			WHERE link_id = ? AND status = 'served' AND accessed_at >= ? AND accessed_at < ?`,
+			id, start.Format(sqliteTime), end.Format(sqliteTime)).Scan(&l.Views, &l.Uniques)
+		database.DB.QueryRow(`
+			SELECT COUNT(*) FROM access_logs
+			WHERE link_id = ? AND status = 'served' AND accessed_at >= ? AND accessed_at < ?`,
+			id, prevStart.Format(sqliteTime), start.Format(sqliteTime)).Scan(&l.PrevViews)
+
+		cRows, err := database.DB.Query(`
+			SELECT country FROM access_logs
+			WHERE link_id = ? AND status = 'served' AND accessed_at >= ? AND accessed_at < ? AND country IS NOT NULL AND country != ''
+			GROUP BY country ORDER BY COUNT(*) DESC LIMIT 3`,
+			id, start.Format(sqliteTime), end.Format(sqliteTime))
+		if err == nil {
+			for cRows.Next() {
+				var c string
+				if cRows.Scan(&c) == nil {
+					l.TopCountries = append(l.TopCountries, c)
+				}
+			}
+			cRows.Close()
+		}
+
+		tRows, err := database.DB.Query(`
+			SELECT accessed_at FROM access_logs
+			WHERE link_id = ? AND status = 'served' AND accessed_at >= ? AND accessed_at < ?`,
+			id, start.Format(sqliteTime), end.Format(sqliteTime))
+		if err == nil {
+			for tRows.Next() {
+				var at time.Time
+				if tRows.Scan(&at) == nil {
+					if i := int(at.Sub(start) / bucket); i >= 0 && i < buckets {
+						series[i]++
+					}
+				}
+			}
+			tRows.Close()
+		}
+		links = append(links, l)
+	}
+	return links, series
+}
+
+// renderDigestChart desenha um gráfico de barras simples em PNG para ser embutido no e-mail
+func renderDigestChart(series []int) []byte {
+	const width, height, pad = 480, 120, 4
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	bg := color.RGBA{0xf8, 0xfa, 0xfc, 0xff}
+	bar := color.RGBA{0x10, 0xb9, 0x81, 0xff}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, bg)
+		}
+	}
+
+	max := 0
+	for _, v := range series {
+		if v > max {
+			max = v
+		}
+	}
+	if len(series) > 0 && max > 0 {
+		slot := width / len(series)
+		for i, v := range series {
+			h := v * (height - pad) / max
+			if v > 0 && h == 0 {
+				h = 1
+			}
+			x0 := i*slot + pad/2
+			x1 := (i+1)*slot - pad/2
+			for y := height - h; y < height; y++ {
+				for x := x0; x < x1; x++ {
+					img.Set(x, y, bar)
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	return buf.Bytes()
+}
+
+// buildDigestHTML monta o corpo do e-mail; o gráfico é referenciado como cid:digest-chart
+func buildDigestHTML(email, frequency string, links []digestLink) string {
+	baseURL := os.Getenv("BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:8080"
+	}
+	period := "últimas 24 horas"
+	if frequency == DigestWeekly {
+		period = "últimos 7 dias"
+	}
+
+	totalViews, totalUniques := 0, 0
+	var rowsHTML strings.Builder
+	for _, l := range links {
+		totalViews += l.Views
+		totalUniques += l.Uniques
+		countries := strings.Join(l.TopCountries, ", ")
+		if countries == "" {
+			countries = "—"
+		}
+		fmt.Fprintf(&rowsHTML, `<tr><td><a href="%s/?dashboard=%s">%s</a></td><td>%d</td><td>%d</td><td>%s</td><td>%s</td></tr>`,
+			baseURL, l.ID, l.ID, l.Views, l.Uniques, html.EscapeString(countries), l.trend())
+	}
+
+	unsubscribe := fmt.Sprintf("%s/api/digest/unsubscribe?email=%s&token=%s",
+		baseURL, url.QueryEscape(email), DigestUnsubscribeToken(email))
+
+	return fmt.Sprintf(`<h2>Resumo Crom-Vision — %s</h2>
+	<p><b>%d</b> visualizações e <b>%d</b> visitantes únicos em %d link(s) ativo(s).</p>
+	<p><img src="cid:digest-chart" alt="Visualizações no período" width="480" height="120"></p>
+	<table cellpadding="6" style="border-collapse:collapse">
+	<tr><th align="left">Link</th><th>Views</th><th>Únicos</th><th>Top países</th><th>Tendência</th></tr>
+	%s
+	</table>
+	<hr><p style="font-size:12px;color:#6b7280">Não quer mais receber este resumo? <a href="%s">Desativar resumo</a></p>`,
+		period, totalViews, totalUniques, len(links), rowsHTML.String(), unsubscribe)
+}
+
+// ProcessDigests envia os resumos cujo horário agendado já passou desde o último envio
+func ProcessDigests(now time.Time) {
+	hour := utils.EnvInt("DIGEST_HOUR_UTC", 11)
+	if hour < 0 || hour > 23 {
+		hour = 11
+	}
+
+	rows, err := database.DB.Query("SELECT email, frequency, last_sent_at FROM digest_subscriptions")
+	if err != nil {
+		log.Printf("[DIGEST ERR] Falha lendo inscrições: %v", err)
+		return
+	}
+	type subscription struct {
+		email, frequency string
+		lastSent         sql.NullTime
+	}
+	var subs []subscription
+	for rows.Next() {
+		var s subscription
+		if rows.Scan(&s.email, &s.frequency, &s.lastSent) == nil {
+			subs = append(subs, s)
+		}
+	}
+	rows.Close()
+
+	for _, s := range subs {
+		slot := digestSlot(s.frequency, now, hour)
+		if s.lastSent.Valid && !s.lastSent.Time.Before(slot) {
+			continue
+		}
+
+		start, bucket, buckets := digestPeriod(s.frequency, slot)
+		links, series := collectDigest(s.email, start, slot, bucket, buckets)
+		// Marca antes de enviar: em caso de falha de SMTP o resumo é pulado, não repetido
+		database.DB.Exec("UPDATE digest_subscriptions SET last_sent_at = ? WHERE email = ?", now.UTC().Format(sqliteTime), s.email)
+		if len(links) == 0 {
+			continue
+		}
+
+		subject := "Crom-Vision - Resumo diário"
+		if s.frequency == DigestWeekly {
+			subject = "Crom-Vision - Resumo semanal"
+		}
+		digestMailer(s.email, subject, buildDigestHTML(s.email, s.frequency, links), []InlineImage{
+			{ContentID: "digest-chart", ContentType: "image/png", Data: renderDigestChart(series)},
+		})
+	}
+}
+
+// RunDigestScheduler verifica periodicamente os resumos pendentes
+func RunDigestScheduler() {
+	for {
+		ProcessDigests(time.Now())
+		time.Sleep(10 * time.Minute)
+	}
+}
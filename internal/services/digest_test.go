@@ -0,0 +1,88 @@
+package services
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+	"time"
+
+	"crom-vision/internal/database"
+)
+
+func TestDigestSlot(t *testing.T) {
+	// Quarta-feira, 10:00 UTC
+	now := time.Date(2025, 1, 15, 10, 0, 0, 0, time.UTC)
+
+	if got := digestSlot(DigestDaily, now, 11); !got.Equal(time.Date(2025, 1, 14, 11, 0, 0, 0, time.UTC)) {
+		t.Errorf("antes do horário o slot diário deveria ser o de ontem, obteve %v", got)
+	}
+	if got := digestSlot(DigestDaily, now, 9); !got.Equal(time.Date(2025, 1, 15, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("slot diário inesperado: %v", got)
+	}
+	if got := digestSlot(DigestWeekly, now, 11); !got.Equal(time.Date(2025, 1, 13, 11, 0, 0, 0, time.UTC)) {
+		t.Errorf("slot semanal deveria cair na segunda-feira, obteve %v", got)
+	}
+}
+
+func TestDigestUnsubscribeToken(t *testing.T) {
+	token := DigestUnsubscribeToken("Dono@Test.com")
+	if !VerifyDigestUnsubscribe("dono@test.com", token) {
+		t.Error("token deveria ser válido independente de maiúsculas")
+	}
+	if VerifyDigestUnsubscribe("outro@test.com", token) || VerifyDigestUnsubscribe("dono@test.com", "") {
+		t.Error("token não deveria valer para outro e-mail ou vazio")
+	}
+}
+
+func TestRenderDigestChart(t *testing.T) {
+	img, err := png.Decode(bytes.NewReader(renderDigestChart([]int{0, 3, 10, 1})))
+	if err != nil {
+		t.Fatalf("gráfico não é um PNG válido: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != 480 || b.Dy() != 120 {
+		t.Errorf("dimensões inesperadas: %v", b)
+	}
+}
+
+func TestProcessDigests_SendsOncePerSlot(t *testing.T) {
+	cleanup, _ := setupBgDB(t)
+	defer cleanup()
+
+	type mail struct {
+		to, subject, body string
+		images           []InlineImage
+	}
+	var sent []mail
+	digestMailer = func(to, subject, body string, images []InlineImage) {
+		sent = append(sent, mail{to, subject, body, images})
+	}
+	defer func() { digestMailer = SendEmailWithInline }()
+
+	now := time.Date(2025, 1, 15, 12, 0, 0, 0, time.UTC)
+	database.DB.Exec(`INSERT INTO links (id, email, payment_status, expires_at) VALUES ('dg1', 'dono@test.com', 'approved', '2025-02-01 00:00:00')`)
+	database.DB.Exec(`INSERT INTO links (id, email, payment_status, expires_at) VALUES ('dg2', 'dono@test.com', 'approved', '2025-01-01 00:00:00')`)
+	for _, at := range []string{"2025-01-14 15:00:00", "2025-01-14 16:00:00", "2025-01-15 10:59:00"} {
+		database.DB.Exec(`INSERT INTO access_logs (link_id, ip_hash, country, is_unique, accessed_at) VALUES ('dg1', 'h', 'BR', 1, ?)`, at)
+	}
+	// Fora da janela (depois do slot das 11h) não entra no resumo
+	database.DB.Exec(`INSERT INTO access_logs (link_id, ip_hash, country, accessed_at) VALUES ('dg1', 'h', 'US', '2025-01-15 11:30:00')`)
+	database.DB.Exec(`INSERT INTO digest_subscriptions (email, frequency, last_sent_at) VALUES ('dono@test.com', 'daily', '2025-01-14 11:00:00')`)
+
+	ProcessDigests(now)
+	ProcessDigests(now.Add(30 * time.Minute))
+
+	if len(sent) != 1 {
+		t.Fatalf("esperava um único resumo, obteve %d", len(sent))
+	}
+	m := sent[0]
+	if !strings.Contains(m.body, "<b>3</b> visualizações") || !strings.Contains(m.body, "dg1") || strings.Contains(m.body, "dg2") {
+		t.Errorf("corpo inesperado: %s", m.body)
+	}
+	if !strings.Contains(m.body, "cid:digest-chart") || len(m.images) != 1 || m.images[0].ContentID != "digest-chart" {
+		t.Error("gráfico deveria ser embutido via CID")
+	}
+	if !strings.Contains(m.body, "/api/digest/unsubscribe?email=dono%40test.com&token="+DigestUnsubscribeToken("dono@test.com")) {
+		t.Error("link de descadastro assinado ausente")
+	}
+}
@@ -1,12 +1,69 @@
 package services
 
 import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
 	"log"
+	"mime/multipart"
 	"net/smtp"
+	"net/textproto"
 	"os"
+
+	"crom-vision/internal/metrics"
 )
 
+// InlineImage é uma imagem embutida no corpo HTML, referenciada por <img src="cid:ID">
+type InlineImage struct {
+	ContentID   string
+	ContentType string
+	Data        []byte
+}
+
 func SendEmail(to, subject, body string) {
+	// RFC 822 format headers
+	msg := []byte("To: " + to + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"MIME-version: 1.0;\nContent-Type: text/html; charset=\"UTF-8\";\n\n" +
+		body + "\r\n")
+
+	deliverEmail(to, msg)
+}
+
+// SendEmailWithInline envia um e-mail multipart/related com imagens embutidas
+func SendEmailWithInline(to, subject, htmlBody string, images []InlineImage) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+
+	part, _ := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {`text/html; charset="UTF-8"`},
+		"Content-Transfer-Encoding": {"8bit"},
+	})
+	part.Write([]byte(htmlBody))
+
+	for _, img := range images {
+		part, _ := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {img.ContentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-ID":                {"<" + img.ContentID + ">"},
+			"Content-Disposition":       {"inline"},
+		})
+		encoded := base64.StdEncoding.EncodeToString(img.Data)
+		// Linhas de no máximo 76 caracteres (RFC 2045)
+		for len(encoded) > 76 {
+			part.Write([]byte(encoded[:76] + "\r\n"))
+			encoded = encoded[76:]
+		}
+		part.Write([]byte(encoded + "\r\n"))
+	}
+	mw.Close()
+
+	header := fmt.Sprintf("To: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\nContent-Type: multipart/related; boundary=%q\r\n\r\n",
+		to, subject, mw.Boundary())
+	deliverEmail(to, append([]byte(header), buf.Bytes()...))
+}
+
+func deliverEmail(to string, msg []byte) {
 	host := os.Getenv("SMTP_HOST")
 	port := os.Getenv("SMTP_PORT")
 	if port == "" {
@@ -17,21 +74,18 @@ func SendEmail(to, subject, body string) {
 
 	if host == "" || user == "" || pass == "" {
 		log.Println("[EMAIL SKIP] Variáveis ausentes para SMTP. Não enviaremos email para:", to)
+		metrics.EmailsSent.Inc("skipped")
 		return
 	}
 
 	auth := smtp.PlainAuth("", user, pass, host)
 
-	// RFC 822 format headers
-	msg := []byte("To: " + to + "\r\n" +
-		"Subject: " + subject + "\r\n" +
-		"MIME-version: 1.0;\nContent-Type: text/html; charset=\"UTF-8\";\n\n" +
-		body + "\r\n")
-
 	err := smtp.SendMail(host+":"+port, auth, user, []string{to}, msg)
 	if err != nil {
 		log.Printf("[CRIT] Falha enviando E-mail para %s: %v", to, err)
+		metrics.EmailsSent.Inc("failure")
 	} else {
 		log.Printf("[MAIL] E-mail enviado com sucesso para %s", to)
+		metrics.EmailsSent.Inc("success")
 	}
 }
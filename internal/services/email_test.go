@@ -41,3 +41,13 @@ func TestSendEmail_DoesNotPanicWithInvalidConfig(t *testing.T) {
 	// Deve logar erro mas não deve dar panic
 	SendEmail("dest@test.com", "Test", "Body")
 }
+
+func TestSendEmailWithInline_SkipsWhenNoConfig(t *testing.T) {
+	os.Unsetenv("SMTP_HOST")
+	os.Unsetenv("SMTP_USER")
+	os.Unsetenv("SMTP_PASS")
+
+	SendEmailWithInline("test@test.com", "Subject", `<img src="cid:x">`, []InlineImage{
+		{ContentID: "x", ContentType: "image/png", Data: []byte("png")},
+	})
+}
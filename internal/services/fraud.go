@@ -0,0 +1,157 @@
+package services
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"crom-vision/internal/database"
+)
+
+// Motivos gravados em access_logs.suspicious_reason e links.fraud_reason
+const (
+	FraudFingerprintRate = "fingerprint_rate"
+	FraudASNBurst        = "asn_burst"
+	FraudIdenticalUA     = "identical_ua"
+)
+
+// fraudConfig são os limites por link dentro da janela deslizante
+type fraudConfig struct {
+	Window         time.Duration
+	FingerprintMax int     // hits do mesmo fingerprint (IP+UA)
+	ASNMax         int     // hits do mesmo ASN (exige GeoLite2-ASN)
+	UAMin          int     // hits mínimos do mesmo User-Agent para avaliar a concentração
+	UAShare        float64 // fração dos hits do link vinda de um único User-Agent
+	// EmailFingerprintMax vale para proxies de e-mail verificados (GoogleImageProxy, YahooMailProxy
+	// vindos do ASN ou da faixa do operador): eles buscam a imagem por muitos leitores a partir
+	// de poucos IPs, com o mesmo UA e ASN
+	EmailFingerprintMax int
+}
+
+func envInt(name string, def int) int {
+	if n, err := strconv.Atoi(os.Getenv(name)); err == nil && n > 0 {
+		return n
+	}
+	return def
+}
+
+func loadFraudConfig() fraudConfig {
+	cfg := fraudConfig{
+		Window:         time.Duration(envInt("FRAUD_WINDOW_SECONDS", 60)) * time.Second,
+		FingerprintMax: envInt("FRAUD_FINGERPRINT_MAX", 20),
+		ASNMax:         envInt("FRAUD_ASN_MAX", 300),
+		UAMin:          envInt("FRAUD_UA_MIN", 60),
+		UAShare:        0.9,
+
+		EmailFingerprintMax: envInt("FRAUD_EMAIL_FINGERPRINT_MAX", 600),
+	}
+	if f, err := strconv.ParseFloat(os.Getenv("FRAUD_UA_SHARE"), 64); err == nil && f > 0 && f <= 1 {
+		cfg.UAShare = f
+	}
+	return cfg
+}
+
+// maxTrackedHits limita a memória de cada janela (um script martelando o pixel não cresce a lista)
+const maxTrackedHits = 10000
+
+// FraudDetector conta hits recentes por link em janelas deslizantes, só em memória
+// (mesma abordagem do anti-F5). Reiniciar o processo zera as janelas.
+type FraudDetector struct {
+	cfg       fraudConfig
+	mu        sync.Mutex
+	hits      map[string][]time.Time
+	lastPrune time.Time
+}
+
+func NewFraudDetector(cfg fraudConfig) *FraudDetector {
+	return &FraudDetector{cfg: cfg, hits: make(map[string][]time.Time)}
+}
+
+var (
+	fraudOnce     sync.Once
+	fraudDetector *FraudDetector
+)
+
+// CheckFraud avalia o hit no detector global (configurado pelo .env no primeiro uso)
+func CheckFraud(linkID, fingerprint string, asn uint, ua string, emailProxy bool) string {
+	fraudOnce.Do(func() { fraudDetector = NewFraudDetector(loadFraudConfig()) })
+	return fraudDetector.Check(linkID, fingerprint, asn, ua, emailProxy, time.Now())
+}
+
+// track registra o hit na janela da chave e devolve quantos hits ela tem agora
+func (d *FraudDetector) track(key string, now time.Time, keep int) int {
+	cutoff := now.Add(-d.cfg.Window)
+	list := d.hits[key]
+	i := 0
+	for i < len(list) && !list[i].After(cutoff) {
+		i++
+	}
+	list = append(list[i:], now)
+	if len(list) > keep {
+		list = list[len(list)-keep:]
+	}
+	d.hits[key] = list
+	return len(list)
+}
+
+// Check registra o hit e devolve o motivo se ele for suspeito ("" = normal).
+// Hits de proxies de e-mail verificados (emailProxy, ver utils.IsVerifiedEmailProxy: UA e
+// origem do operador) não passam pelas regras de ASN e de User-Agent, que uma newsletter
+// legítima dispararia, e têm um limite próprio por fingerprint. Só o UA não basta.
+func (d *FraudDetector) Check(linkID, fingerprint string, asn uint, ua string, emailProxy bool, now time.Time) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if now.Sub(d.lastPrune) > d.cfg.Window {
+		d.prune(now)
+	}
+
+	if emailProxy {
+		if d.track("email:"+linkID+":"+fingerprint, now, d.cfg.EmailFingerprintMax+1) > d.cfg.EmailFingerprintMax {
+			return FraudFingerprintRate
+		}
+		return ""
+	}
+
+	reason := ""
+	if d.track("fp:"+linkID+":"+fingerprint, now, d.cfg.FingerprintMax+1) > d.cfg.FingerprintMax {
+		reason = FraudFingerprintRate
+	}
+	if asn != 0 {
+		if d.track("asn:"+linkID+":"+strconv.FormatUint(uint64(asn), 10), now, d.cfg.ASNMax+1) > d.cfg.ASNMax && reason == "" {
+			reason = FraudASNBurst
+		}
+	}
+	total := d.track("all:"+linkID, now, maxTrackedHits)
+	sameUA := d.track("ua:"+linkID+":"+ua, now, maxTrackedHits)
+	if reason == "" && sameUA >= d.cfg.UAMin && float64(sameUA) >= float64(total)*d.cfg.UAShare {
+		reason = FraudIdenticalUA
+	}
+	return reason
+}
+
+// prune descarta as janelas sem hits recentes
+func (d *FraudDetector) prune(now time.Time) {
+	cutoff := now.Add(-d.cfg.Window)
+	for key, list := range d.hits {
+		if len(list) == 0 || !list[len(list)-1].After(cutoff) {
+			delete(d.hits, key)
+		}
+	}
+	d.lastPrune = now
+}
+
+// FlagLinkForReview marca o link para revisão na primeira detecção (as seguintes não sobrescrevem)
+func FlagLinkForReview(linkID, reason string) {
+	res, err := database.DB.Exec("UPDATE links SET fraud_flagged_at = ?, fraud_reason = ? WHERE id = ? AND fraud_flagged_at IS NULL",
+		time.Now().UTC().Format(sqliteTime), reason, linkID)
+	if err != nil {
+		log.Printf("[DB ERR] Falha ao marcar link %s para revisão: %v", linkID, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 1 {
+		log.Printf("[🚨 FRAUDE] Link %s marcado para revisão: %s", linkID, reason)
+	}
+}
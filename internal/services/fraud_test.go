@@ -0,0 +1,127 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"crom-vision/internal/database"
+)
+
+func testFraudConfig() fraudConfig {
+	return fraudConfig{Window: time.Minute, FingerprintMax: 3, ASNMax: 5, UAMin: 4, UAShare: 0.9}
+}
+
+func TestFraudDetector_FingerprintRate(t *testing.T) {
+	d := NewFraudDetector(testFraudConfig())
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if r := d.Check("l1", "fp", 0, fmt.Sprintf("ua-%d", i), false, now); r != "" {
+			t.Fatalf("hit %d dentro do limite marcado como %q", i, r)
+		}
+	}
+	if r := d.Check("l1", "fp", 0, "ua-x", false, now); r != FraudFingerprintRate {
+		t.Errorf("esperava %s no 4º hit do mesmo fingerprint, obteve %q", FraudFingerprintRate, r)
+	}
+	// Outro link não herda a janela
+	if r := d.Check("l2", "fp", 0, "ua-y", false, now); r != "" {
+		t.Errorf("link diferente não deveria ser afetado, obteve %q", r)
+	}
+	// Depois da janela o fingerprint volta ao normal
+	if r := d.Check("l1", "fp", 0, "ua-z", false, now.Add(2*time.Minute)); r != "" {
+		t.Errorf("janela expirada deveria zerar a contagem, obteve %q", r)
+	}
+}
+
+func TestFraudDetector_ASNBurst(t *testing.T) {
+	d := NewFraudDetector(testFraudConfig())
+	now := time.Now()
+
+	var last string
+	for i := 0; i < 6; i++ {
+		last = d.Check("l1", fmt.Sprintf("fp-%d", i), 64500, fmt.Sprintf("ua-%d", i), false, now)
+	}
+	if last != FraudASNBurst {
+		t.Errorf("esperava %s no 6º hit do mesmo ASN, obteve %q", FraudASNBurst, last)
+	}
+	// ASN desconhecido (0) nunca dispara a regra
+	for i := 0; i < 6; i++ {
+		last = d.Check("l2", fmt.Sprintf("fp-%d", i), 0, fmt.Sprintf("ua-%d", i), false, now)
+	}
+	if last != "" {
+		t.Errorf("ASN 0 não deveria ser avaliado, obteve %q", last)
+	}
+}
+
+func TestFraudDetector_IdenticalUA(t *testing.T) {
+	d := NewFraudDetector(testFraudConfig())
+	now := time.Now()
+
+	var last string
+	for i := 0; i < 4; i++ {
+		last = d.Check("l1", fmt.Sprintf("fp-%d", i), 0, "bot/1.0", false, now)
+	}
+	if last != FraudIdenticalUA {
+		t.Errorf("esperava %s com 100%% dos hits do mesmo UA, obteve %q", FraudIdenticalUA, last)
+	}
+
+	// Tráfego variado dilui o UA repetido abaixo da fração
+	d = NewFraudDetector(testFraudConfig())
+	for i := 0; i < 4; i++ {
+		d.Check("l2", fmt.Sprintf("o-%d", i), 0, fmt.Sprintf("browser-%d", i), false, now)
+	}
+	for i := 0; i < 4; i++ {
+		last = d.Check("l2", fmt.Sprintf("fp-%d", i), 0, "bot/1.0", false, now)
+	}
+	if last != "" {
+		t.Errorf("UA com 50%% do tráfego não deveria ser suspeito, obteve %q", last)
+	}
+}
+
+func TestFlagLinkForReview_KeepsFirstReason(t *testing.T) {
+	cleanup, _ := setupBgDB(t)
+	defer cleanup()
+
+	database.DB.Exec("INSERT INTO links (id, original_url, expires_at) VALUES ('f1', 'x', '2099-01-01 00:00:00')")
+	FlagLinkForReview("f1", FraudFingerprintRate)
+	FlagLinkForReview("f1", FraudASNBurst)
+
+	var reason string
+	database.DB.QueryRow("SELECT fraud_reason FROM links WHERE id = 'f1' AND fraud_flagged_at IS NOT NULL").Scan(&reason)
+	if reason != FraudFingerprintRate {
+		t.Errorf("esperava o primeiro motivo %s, obteve %q", FraudFingerprintRate, reason)
+	}
+}
+
+func TestFraudDetector_EmailProxyBurstIsNotFlagged(t *testing.T) {
+	d := NewFraudDetector(fraudConfig{Window: time.Minute, FingerprintMax: 3, ASNMax: 5, UAMin: 4, UAShare: 0.9, EmailFingerprintMax: 50})
+	now := time.Now()
+
+	// Newsletter aberta no Gmail: dezenas de hits por minuto dos mesmos poucos IPs do
+	// GoogleImageProxy, todos com o mesmo UA e o ASN do Google
+	ua := "Mozilla/5.0 (Windows NT 5.1; rv:11.0) Gecko Firefox/11.0 (via ggpht.com GoogleImageProxy)"
+	for i := 0; i < 40; i++ {
+		fp := fmt.Sprintf("proxy-%d", i%3)
+		if r := d.Check("news", fp, 15169, ua, true, now.Add(time.Duration(i)*time.Second)); r != "" {
+			t.Fatalf("abertura %d via proxy de e-mail marcada como %q", i, r)
+		}
+	}
+
+	// O mesmo padrão vindo de um navegador comum continua suspeito
+	var last string
+	for i := 0; i < 40; i++ {
+		last = d.Check("bot", fmt.Sprintf("fp-%d", i%3), 15169, "curl/8.0", false, now)
+	}
+	if last == "" {
+		t.Error("rajada fora de proxy de e-mail deveria ser marcada")
+	}
+
+	// Um único IP de proxy martelando muito acima do normal ainda é pego
+	for i := 0; i < 50; i++ {
+		d.Check("news2", "proxy-x", 15169, ua, true, now)
+	}
+	if r := d.Check("news2", "proxy-x", 15169, ua, true, now); r != FraudFingerprintRate {
+		t.Errorf("esperava %s acima de EmailFingerprintMax, obteve %q", FraudFingerprintRate, r)
+	}
+}
@@ -8,9 +8,12 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	"crypto/rand"
 	mrand "math/rand"
+
+	"crom-vision/internal/metrics"
 )
 
 // PixPaymentResult contém os dados retornados pelo Mercado Pago após criar um pagamento PIX
@@ -57,8 +60,11 @@ func CreatePixPayment(amount float64, description, payerEmail, externalRef strin
 	req.Header.Set("X-Idempotency-Key", idempotencyKey)
 
 	client := &http.Client{}
+	start := time.Now()
 	resp, err := client.Do(req)
+	metrics.MercadoPagoDuration.Observe(time.Since(start).Seconds(), "create_payment")
 	if err != nil {
+		metrics.MercadoPagoErrors.Inc("create_payment")
 		return nil, fmt.Errorf("falha na requisição ao Mercado Pago: %w", err)
 	}
 	defer resp.Body.Close()
@@ -66,6 +72,7 @@ func CreatePixPayment(amount float64, description, payerEmail, externalRef strin
 	respBody, _ := io.ReadAll(resp.Body)
 
 	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		metrics.MercadoPagoErrors.Inc("create_payment")
 		log.Printf("[MP ERR] Status %d — Body: %s", resp.StatusCode, string(respBody))
 		return nil, fmt.Errorf("Mercado Pago retornou status %d: %s", resp.StatusCode, string(respBody))
 	}
@@ -109,11 +116,17 @@ func GetPaymentStatus(paymentID int64) (string, error) {
 	req, _ := http.NewRequest("GET", url, nil)
 	req.Header.Set("Authorization", "Bearer "+accessToken)
 
+	start := time.Now()
 	resp, err := (&http.Client{}).Do(req)
+	metrics.MercadoPagoDuration.Observe(time.Since(start).Seconds(), "get_payment")
 	if err != nil {
+		metrics.MercadoPagoErrors.Inc("get_payment")
 		return "", err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		metrics.MercadoPagoErrors.Inc("get_payment")
+	}
 
 	var result struct {
 		Status string `json:"status"`
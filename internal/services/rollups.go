@@ -0,0 +1,127 @@
+package services
+
+import (
+	"log"
+	"time"
+
+	"crom-vision/internal/database"
+	"crom-vision/internal/utils"
+)
+
+// Rollups por hora e por dia (UTC) de cada link, quebrados por país e classe de cliente.
+// São mantidos a cada hit e não passam pela retenção de LOG_RETENTION_DAYS, então o
+// histórico dos gráficos sobrevive à limpeza dos access_logs brutos.
+const (
+	rollupHourFormat = "2006-01-02 15:00:00"
+	rollupDayFormat  = "2006-01-02"
+)
+
+// RecordRollup soma um hit servido nos rollups horário e diário do link. returning marca a
+// visita única de quem já tinha acessado o link antes (visita de retorno)
+func RecordRollup(linkID string, at time.Time, country, clientClass string, unique, returning bool) {
+	at = at.UTC()
+	uniq, ret := 0, 0
+	if unique {
+		uniq = 1
+	}
+	if returning {
+		ret = 1
+	}
+	for _, q := range []struct{ table, bucket string }{
+		{"stats_hourly", at.Format(rollupHourFormat)},
+		{"stats_daily", at.Format(rollupDayFormat)},
+	} {
+		_, err := database.DB.Exec(`
+			INSERT INTO `+q.table+` (link_id, bucket, country, client_class, views, uniques, returning_visits)
+			VALUES (?, ?, ?, ?, 1, ?, ?)
+			ON CONFLICT (link_id, bucket, country, client_class)
+			DO UPDATE SET views = views + 1, uniques = uniques + excluded.uniques, returning_visits = returning_visits + excluded.returning_visits`,
+			linkID, q.bucket, country, clientClass, uniq, ret)
+		if err != nil {
+			log.Printf("[DB ERR] Falha ao atualizar %s do link %s: %v", q.table, linkID, err)
+		}
+	}
+}
+
+// BackfillRollups monta os rollups a partir dos access_logs para links que ainda não têm
+// nenhuma linha agregada (bases anteriores aos rollups). Links já agregados são ignorados,
+// então rodar de novo é seguro. O único segue a regra do anti-F5: sem acesso do mesmo
+// ip_hash dentro de utils.CooldownPeriod; o de retorno é o único com acesso anterior.
+// Os visitantes distintos por grupo (stats_visitors) são reconstruídos do mesmo jeito.
+func BackfillRollups() error {
+	window := utils.CooldownPeriod.Seconds()
+	for _, q := range []struct{ table, bucket string }{
+		{"stats_hourly", "strftime('%Y-%m-%d %H:00:00', accessed_at)"},
+		{"stats_daily", "strftime('%Y-%m-%d', accessed_at)"},
+	} {
+		res, err := database.DB.Exec(`
+			INSERT INTO `+q.table+` (link_id, bucket, country, client_class, views, uniques, returning_visits)
+			SELECT link_id, `+q.bucket+` AS b, COALESCE(country, ''), COALESCE(client_class, ''), COUNT(*),
+				SUM(CASE WHEN prev_at IS NULL OR (julianday(accessed_at) - julianday(prev_at)) * 86400 >= ? THEN 1 ELSE 0 END),
+				SUM(CASE WHEN prev_at IS NOT NULL AND (julianday(accessed_at) - julianday(prev_at)) * 86400 >= ? THEN 1 ELSE 0 END)
+			FROM (
+				SELECT link_id, accessed_at, country, client_class,
+					LAG(accessed_at) OVER (PARTITION BY link_id, ip_hash ORDER BY accessed_at, id) AS prev_at
+				FROM access_logs
+				WHERE status = 'served' AND link_id NOT IN (SELECT DISTINCT link_id FROM `+q.table+`)
+			)
+			WHERE true
+			GROUP BY link_id, b, COALESCE(country, ''), COALESCE(client_class, '')
+			ON CONFLICT (link_id, bucket, country, client_class) DO NOTHING`, window, window)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			log.Printf("[ROLLUP] %d linhas de %s reconstruídas a partir dos access_logs", n, q.table)
+		}
+	}
+	return backfillVisitors()
+}
+
+// Dimensões de stats_visitors, com os mesmos valores crus gravados nos rollups
+const (
+	VisitorsByCountry = "country"
+	VisitorsByClient  = "client_class"
+)
+
+// RecordVisitor soma um visitante distinto ao grupo (país ou classe de cliente) do link.
+// Quem chama decide se o ip_hash é novo no grupo olhando os access_logs; como os logs
+// seguem LOG_RETENTION_DAYS, um visitante que volta depois da retenção conta de novo.
+// A contagem sobrevive à limpeza dos logs e sustenta o k-anonimato do histórico antigo.
+func RecordVisitor(linkID, dimension, value string) {
+	_, err := database.DB.Exec(`
+		INSERT INTO stats_visitors (link_id, dimension, value, visitors) VALUES (?, ?, ?, 1)
+		ON CONFLICT (link_id, dimension, value) DO UPDATE SET visitors = visitors + 1`,
+		linkID, dimension, value)
+	if err != nil {
+		log.Printf("[DB ERR] Falha ao atualizar stats_visitors do link %s: %v", linkID, err)
+	}
+}
+
+// backfillVisitors conta os ip_hash distintos de cada grupo nos access_logs retidos para
+// links que ainda não têm visitantes agregados na dimensão
+func backfillVisitors() error {
+	for _, dimension := range []string{VisitorsByCountry, VisitorsByClient} {
+		res, err := database.DB.Exec(`
+			INSERT INTO stats_visitors (link_id, dimension, value, visitors)
+			SELECT link_id, ?, COALESCE(`+dimension+`, '') AS v, COUNT(DISTINCT ip_hash)
+			FROM access_logs
+			WHERE status = 'served' AND link_id NOT IN (SELECT DISTINCT link_id FROM stats_visitors WHERE dimension = ?)
+			GROUP BY link_id, v
+			ON CONFLICT (link_id, dimension, value) DO NOTHING`, dimension, dimension)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			log.Printf("[ROLLUP] %d grupos de visitantes (%s) reconstruídos a partir dos access_logs", n, dimension)
+		}
+	}
+	return nil
+}
+
+// DeleteRollups remove os agregados de um link (hard delete e LGPD)
+func DeleteRollups(linkID string) {
+	database.DB.Exec("DELETE FROM stats_hourly WHERE link_id = ?", linkID)
+	database.DB.Exec("DELETE FROM stats_daily WHERE link_id = ?", linkID)
+	database.DB.Exec("DELETE FROM stats_visitors WHERE link_id = ?", linkID)
+}
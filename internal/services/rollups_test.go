@@ -0,0 +1,81 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"crom-vision/internal/database"
+)
+
+func rollupRow(t *testing.T, table, linkID, bucket, country string) (int, int) {
+	t.Helper()
+	var views, uniques int
+	database.DB.QueryRow("SELECT COALESCE(SUM(views), 0), COALESCE(SUM(uniques), 0) FROM "+table+" WHERE link_id = ? AND bucket = ? AND country = ?",
+		linkID, bucket, country).Scan(&views, &uniques)
+	return views, uniques
+}
+
+func TestRecordRollup_Upserts(t *testing.T) {
+	cleanup, _ := setupBgDB(t)
+	defer cleanup()
+
+	at := time.Date(2025, 3, 10, 14, 59, 0, 0, time.FixedZone("BRT", -3*3600))
+	RecordRollup("r1", at, "BR", "browser", true, false)
+	RecordRollup("r1", at, "BR", "browser", false, false)
+	RecordRollup("r1", at, "US", "browser", true, true)
+
+	if v, u := rollupRow(t, "stats_hourly", "r1", "2025-03-10 17:00:00", "BR"); v != 2 || u != 1 {
+		t.Errorf("hora BR: esperava 2 views/1 único, obteve %d/%d", v, u)
+	}
+	if v, u := rollupRow(t, "stats_daily", "r1", "2025-03-10", "US"); v != 1 || u != 1 {
+		t.Errorf("dia US: esperava 1/1, obteve %d/%d", v, u)
+	}
+	var returning int
+	database.DB.QueryRow("SELECT returning_visits FROM stats_hourly WHERE link_id = 'r1' AND country = 'US'").Scan(&returning)
+	if returning != 1 {
+		t.Errorf("hora US: esperava 1 visita de retorno, obteve %d", returning)
+	}
+}
+
+func TestBackfillRollups(t *testing.T) {
+	cleanup, _ := setupBgDB(t)
+	defer cleanup()
+
+	for _, h := range []struct{ ip, at, status string }{
+		{"a", "2025-03-10 10:00:00", "served"},
+		{"a", "2025-03-10 10:02:00", "served"}, // F5 dentro da janela
+		{"b", "2025-03-10 10:30:00", "served"},
+		{"a", "2025-03-11 09:00:00", "served"},
+		{"c", "2025-03-10 10:40:00", "unfurl"}, // robô não entra
+	} {
+		database.DB.Exec("INSERT INTO access_logs (link_id, ip_hash, country, accessed_at, status) VALUES ('b1', ?, 'BR', ?, ?)", h.ip, h.at, h.status)
+	}
+	// Link já agregado não é refeito
+	RecordRollup("done", time.Date(2025, 3, 10, 10, 0, 0, 0, time.UTC), "BR", "", true, false)
+	database.DB.Exec("INSERT INTO access_logs (link_id, ip_hash, country, accessed_at) VALUES ('done', 'x', 'BR', '2025-03-10 10:05:00')")
+
+	if err := BackfillRollups(); err != nil {
+		t.Fatalf("backfill falhou: %v", err)
+	}
+	if v, u := rollupRow(t, "stats_hourly", "b1", "2025-03-10 10:00:00", "BR"); v != 3 || u != 2 {
+		t.Errorf("hora 10h: esperava 3 views/2 únicos, obteve %d/%d", v, u)
+	}
+	if v, u := rollupRow(t, "stats_daily", "b1", "2025-03-11", "BR"); v != 1 || u != 1 {
+		t.Errorf("dia 11: esperava 1/1, obteve %d/%d", v, u)
+	}
+	// "a" volta no dia 11 depois da janela do anti-F5: visita de retorno
+	var returning int
+	database.DB.QueryRow("SELECT returning_visits FROM stats_daily WHERE link_id = 'b1' AND bucket = '2025-03-11'").Scan(&returning)
+	if returning != 1 {
+		t.Errorf("dia 11: esperava 1 visita de retorno, obteve %d", returning)
+	}
+	if v, _ := rollupRow(t, "stats_daily", "done", "2025-03-10", "BR"); v != 1 {
+		t.Errorf("link já agregado não deveria ser somado de novo, views=%d", v)
+	}
+
+	// Rodar de novo não duplica
+	BackfillRollups()
+	if v, _ := rollupRow(t, "stats_daily", "b1", "2025-03-10", "BR"); v != 3 {
+		t.Errorf("backfill repetido duplicou views: %d", v)
+	}
+}
@@ -0,0 +1,321 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"crom-vision/internal/database"
+	"crom-vision/internal/utils"
+)
+
+// Eventos que um dono de link pode assinar
+const (
+	EventFirstView       = "first_view"
+	EventView            = "view" // amostrado por sample_rate
+	EventThreshold       = "threshold_reached"
+	EventLimitReached    = "limit_reached"
+	EventExpired         = "expired"
+	EventPaymentApproved = "payment_approved"
+)
+
+// WebhookEvents lista os eventos válidos para cadastro
+var WebhookEvents = []string{EventFirstView, EventView, EventThreshold, EventLimitReached, EventExpired, EventPaymentApproved}
+
+type linkWebhook struct {
+	ID         string
+	URL        string
+	Secret     string
+	Events     string
+	SampleRate float64
+	Threshold  int
+}
+
+func (wh linkWebhook) subscribes(event string) bool {
+	for _, e := range strings.Split(wh.Events, ",") {
+		if e = strings.TrimSpace(e); e == event || e == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func loadWebhooks(linkID string) []linkWebhook {
+	rows, err := database.DB.Query("SELECT id, url, secret, events, sample_rate, threshold FROM webhooks WHERE link_id = ?", linkID)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+
+	var hooks []linkWebhook
+	for rows.Next() {
+		var wh linkWebhook
+		if rows.Scan(&wh.ID, &wh.URL, &wh.Secret, &wh.Events, &wh.SampleRate, &wh.Threshold) == nil {
+			hooks = append(hooks, wh)
+		}
+	}
+	return hooks
+}
+
+// enqueueDelivery grava a entrega na fila persistente; o despachante cuida do envio.
+// URL e segredo são copiados para que eventos finais (ex: expired) sobrevivam à remoção do link.
+func enqueueDelivery(wh linkWebhook, linkID, event string, data map[string]interface{}) {
+	payload, _ := json.Marshal(map[string]interface{}{
+		"event":       event,
+		"link_id":     linkID,
+		"webhook_id":  wh.ID,
+		"occurred_at": time.Now().UTC(),
+		"data":        data,
+	})
+	_, err := database.DB.Exec(`
+		INSERT INTO webhook_deliveries (webhook_id, link_id, event, url, secret, payload)
+		VALUES (?, ?, ?, ?, ?, ?)`, wh.ID, linkID, event, wh.URL, wh.Secret, string(payload))
+	if err != nil {
+		log.Printf("[WEBHOOK ERR] Falha ao enfileirar %s do link %s: %v", event, linkID, err)
+	}
+}
+
+// EnqueueLinkEvent enfileira o evento para todos os webhooks do link que o assinam
+func EnqueueLinkEvent(linkID, event string, data map[string]interface{}) {
+	for _, wh := range loadWebhooks(linkID) {
+		if wh.subscribes(event) {
+			enqueueDelivery(wh, linkID, event, data)
+		}
+	}
+}
+
+// NotifyLinkView dispara os eventos derivados de uma visualização já contabilizada.
+// totalViews é o valor exato após o incremento deste hit.
+func NotifyLinkView(linkID string, totalViews, maxViews int) {
+	hooks := loadWebhooks(linkID)
+	if len(hooks) == 0 {
+		return
+	}
+
+	data := map[string]interface{}{"total_views": totalViews, "max_views": maxViews}
+	for _, wh := range hooks {
+		if totalViews == 1 && wh.subscribes(EventFirstView) {
+			enqueueDelivery(wh, linkID, EventFirstView, data)
+		}
+		if wh.subscribes(EventView) && rand.Float64() < wh.SampleRate {
+			enqueueDelivery(wh, linkID, EventView, data)
+		}
+		if wh.Threshold > 0 && totalViews == wh.Threshold && wh.subscribes(EventThreshold) {
+			enqueueDelivery(wh, linkID, EventThreshold, map[string]interface{}{
+				"total_views": totalViews, "max_views": maxViews, "threshold": wh.Threshold,
+			})
+		}
+		if maxViews > 0 && totalViews == maxViews && wh.subscribes(EventLimitReached) {
+			enqueueDelivery(wh, linkID, EventLimitReached, data)
+		}
+	}
+}
+
+// SignWebhookPayload gera a assinatura enviada em X-Crom-Signature: "t=<unix>,v1=<hex>",
+// onde v1 = HMAC-SHA256(secret, "<unix>.<corpo>")
+func SignWebhookPayload(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// webhookBackoff cresce exponencialmente a partir de 30s, limitado a 6h
+func webhookBackoff(attempts int) time.Duration {
+	d := 30 * time.Second
+	for i := 1; i < attempts && d < 6*time.Hour; i++ {
+		d *= 2
+	}
+	if d > 6*time.Hour {
+		d = 6 * time.Hour
+	}
+	return d
+}
+
+// privateWebhookTargetsAllowed libera destinos internos (WEBHOOK_ALLOW_PRIVATE_TARGETS=true),
+// útil só em desenvolvimento: em produção um webhook para 127.0.0.1 ou 169.254.169.254
+// viraria uma sonda SSRF, já que as entregas devolvem response_code e last_error ao dono
+func privateWebhookTargetsAllowed() bool {
+	return os.Getenv("WEBHOOK_ALLOW_PRIVATE_TARGETS") == "true"
+}
+
+// cgnatBlock (100.64.0.0/10) não entra em IsPrivate, mas também não é roteável na internet
+var cgnatBlock = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// blockedWebhookIP indica endereços que não podem receber webhooks: loopback, redes privadas,
+// link-local (inclui o metadata de nuvem), não especificados e multicast
+func blockedWebhookIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil && (ip4[0] == 0 || cgnatBlock.Contains(ip4)) {
+		return true
+	}
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast()
+}
+
+// ValidateWebhookURL confere esquema e host do destino e resolve o nome, recusando-o se
+// qualquer endereço for interno. A checagem é repetida na conexão (webhookDialControl),
+// então um DNS que muda de resposta depois do cadastro não escapa
+func ValidateWebhookURL(raw string) error {
+	parsed, err := url.Parse(raw)
+	if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Hostname() == "" {
+		return errors.New("URL do webhook inválida (use http:// ou https://)")
+	}
+	if privateWebhookTargetsAllowed() {
+		return nil
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(parsed.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return errors.New("destino do webhook não pode ser um endereço interno")
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if blockedWebhookIP(ip) {
+			return errors.New("destino do webhook não pode ser um endereço interno")
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return fmt.Errorf("não foi possível resolver o host do webhook: %s", host)
+	}
+	for _, a := range addrs {
+		if blockedWebhookIP(a.IP) {
+			return errors.New("destino do webhook não pode ser um endereço interno")
+		}
+	}
+	return nil
+}
+
+// webhookDialControl roda a cada conexão já com o IP resolvido (inclusive após redirects)
+func webhookDialControl(network, address string, _ syscall.RawConn) error {
+	if privateWebhookTargetsAllowed() {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || blockedWebhookIP(ip) {
+		return fmt.Errorf("destino bloqueado: %s é um endereço interno", host)
+	}
+	return nil
+}
+
+// webhookClient não usa proxy do ambiente: o Control precisa ver o IP do destino final
+var webhookClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: 5 * time.Second, Control: webhookDialControl}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+		MaxIdleConns:        20,
+		IdleConnTimeout:     90 * time.Second,
+	},
+}
+
+// ProcessWebhookQueue envia as entregas pendentes cujo horário de tentativa chegou
+func ProcessWebhookQueue() {
+	maxAttempts := utils.EnvInt("WEBHOOK_MAX_ATTEMPTS", 8)
+
+	rows, err := database.DB.Query(`
+		SELECT id, event, url, secret, payload, attempts
+		FROM webhook_deliveries
+		WHERE status = 'pending' AND next_attempt_at <= ?
+		ORDER BY id ASC LIMIT 50`, time.Now().UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		log.Printf("[WEBHOOK ERR] Falha lendo fila: %v", err)
+		return
+	}
+
+	type delivery struct {
+		id       int64
+		event    string
+		url      string
+		secret   string
+		payload  string
+		attempts int
+	}
+	var due []delivery
+	for rows.Next() {
+		var d delivery
+		if rows.Scan(&d.id, &d.event, &d.url, &d.secret, &d.payload, &d.attempts) == nil {
+			due = append(due, d)
+		}
+	}
+	rows.Close()
+
+	for _, d := range due {
+		attempts := d.attempts + 1
+		code, sendErr := sendWebhook(d.id, d.event, d.url, d.secret, []byte(d.payload))
+
+		if sendErr == nil {
+			database.DB.Exec(`UPDATE webhook_deliveries SET status = 'delivered', attempts = ?, response_code = ?, last_error = NULL, delivered_at = ? WHERE id = ?`,
+				attempts, code, time.Now().UTC().Format("2006-01-02 15:04:05"), d.id)
+			continue
+		}
+
+		status := "pending"
+		if attempts >= maxAttempts {
+			status = "failed"
+			log.Printf("[WEBHOOK] Entrega %d (%s) desistida após %d tentativas: %v", d.id, d.event, attempts, sendErr)
+		}
+		next := time.Now().UTC().Add(webhookBackoff(attempts)).Format("2006-01-02 15:04:05")
+		database.DB.Exec(`UPDATE webhook_deliveries SET status = ?, attempts = ?, response_code = ?, last_error = ?, next_attempt_at = ? WHERE id = ?`,
+			status, attempts, sql.NullInt64{Int64: int64(code), Valid: code != 0}, sendErr.Error(), next, d.id)
+	}
+}
+
+func sendWebhook(deliveryID int64, event, url, secret string, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Crom-Vision-Webhooks/1.0")
+	req.Header.Set("X-Crom-Event", event)
+	req.Header.Set("X-Crom-Delivery", strconv.FormatInt(deliveryID, 10))
+	req.Header.Set("X-Crom-Signature", SignWebhookPayload(secret, time.Now().Unix(), body))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// RunWebhookDispatcher processa a fila de webhooks continuamente
+func RunWebhookDispatcher() {
+	interval := time.Duration(utils.EnvInt("WEBHOOK_POLL_SECONDS", 5)) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	for {
+		ProcessWebhookQueue()
+		time.Sleep(interval)
+	}
+}
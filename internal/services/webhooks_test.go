@@ -0,0 +1,215 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"crom-vision/internal/database"
+)
+
+func insertTestWebhook(t *testing.T, linkID, url, events string, threshold int) {
+	t.Helper()
+	database.DB.Exec(`INSERT INTO links (id, expires_at, payment_status) VALUES (?, datetime('now', '+7 days'), 'approved')`, linkID)
+	_, err := database.DB.Exec(`INSERT INTO webhooks (id, link_id, url, secret, events, threshold) VALUES (?, ?, ?, 'whsec_teste', ?, ?)`,
+		"wh_"+linkID, linkID, url, events, threshold)
+	if err != nil {
+		t.Fatalf("falha ao inserir webhook: %v", err)
+	}
+}
+
+func TestSignWebhookPayload(t *testing.T) {
+	body := []byte(`{"event":"view"}`)
+	sig := SignWebhookPayload("segredo", 1700000000, body)
+
+	mac := hmac.New(sha256.New, []byte("segredo"))
+	mac.Write([]byte("1700000000." + string(body)))
+	want := "t=1700000000,v1=" + hex.EncodeToString(mac.Sum(nil))
+	if sig != want {
+		t.Errorf("assinatura inesperada:\n%s\n%s", sig, want)
+	}
+}
+
+func TestNotifyLinkView_EventSelection(t *testing.T) {
+	cleanup, _ := setupBgDB(t)
+	defer cleanup()
+	insertTestWebhook(t, "whl1", "http://example.invalid", "first_view,threshold_reached,limit_reached", 3)
+
+	for total := 1; total <= 5; total++ {
+		NotifyLinkView("whl1", total, 5)
+	}
+
+	rows, _ := database.DB.Query("SELECT event FROM webhook_deliveries WHERE link_id = 'whl1' ORDER BY id")
+	var events []string
+	for rows.Next() {
+		var e string
+		rows.Scan(&e)
+		events = append(events, e)
+	}
+	rows.Close()
+
+	got := strings.Join(events, ",")
+	if got != "first_view,threshold_reached,limit_reached" {
+		t.Errorf("eventos enfileirados inesperados: %s", got)
+	}
+}
+
+func TestProcessWebhookQueue_DeliversSigned(t *testing.T) {
+	cleanup, _ := setupBgDB(t)
+	defer cleanup()
+	// O httptest escuta em 127.0.0.1
+	t.Setenv("WEBHOOK_ALLOW_PRIVATE_TARGETS", "true")
+
+	var gotSig, gotEvent, gotBody string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		gotBody = string(b)
+		gotSig = r.Header.Get("X-Crom-Signature")
+		gotEvent = r.Header.Get("X-Crom-Event")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	insertTestWebhook(t, "whl2", srv.URL, "payment_approved", 0)
+	EnqueueLinkEvent("whl2", EventPaymentApproved, nil)
+	ProcessWebhookQueue()
+
+	if gotEvent != EventPaymentApproved {
+		t.Fatalf("X-Crom-Event inesperado: %q", gotEvent)
+	}
+	var ts int64
+	var v1 string
+	for _, part := range strings.Split(gotSig, ",") {
+		if strings.HasPrefix(part, "t=") {
+			ts, _ = strconv.ParseInt(part[2:], 10, 64)
+		}
+		if strings.HasPrefix(part, "v1=") {
+			v1 = part[3:]
+		}
+	}
+	if SignWebhookPayload("whsec_teste", ts, []byte(gotBody)) != gotSig || v1 == "" {
+		t.Errorf("assinatura não confere: %s", gotSig)
+	}
+
+	var status string
+	var attempts int
+	database.DB.QueryRow("SELECT status, attempts FROM webhook_deliveries WHERE link_id = 'whl2'").Scan(&status, &attempts)
+	if status != "delivered" || attempts != 1 {
+		t.Errorf("esperava delivered/1, obteve %s/%d", status, attempts)
+	}
+}
+
+func TestProcessWebhookQueue_RetriesThenFails(t *testing.T) {
+	cleanup, _ := setupBgDB(t)
+	defer cleanup()
+	// O httptest escuta em 127.0.0.1
+	t.Setenv("WEBHOOK_ALLOW_PRIVATE_TARGETS", "true")
+	os.Setenv("WEBHOOK_MAX_ATTEMPTS", "2")
+	defer os.Unsetenv("WEBHOOK_MAX_ATTEMPTS")
+
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	insertTestWebhook(t, "whl3", srv.URL, "expired", 0)
+	EnqueueLinkEvent("whl3", EventExpired, nil)
+
+	ProcessWebhookQueue()
+	var status string
+	var code, attempts int
+	var next time.Time
+	database.DB.QueryRow("SELECT status, attempts, response_code, next_attempt_at FROM webhook_deliveries WHERE link_id = 'whl3'").Scan(&status, &attempts, &code, &next)
+	if status != "pending" || attempts != 1 || code != 500 {
+		t.Fatalf("após a 1ª falha esperava pending/1/500, obteve %s/%d/%d", status, attempts, code)
+	}
+	if next.Before(time.Now().UTC().Add(20 * time.Second)) {
+		t.Errorf("próxima tentativa deveria respeitar o backoff, agendada para %v", next)
+	}
+
+	// Uma nova passada antes do backoff não reenvia
+	ProcessWebhookQueue()
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Fatalf("entrega reenviada antes do backoff")
+	}
+
+	database.DB.Exec("UPDATE webhook_deliveries SET next_attempt_at = datetime('now', '-1 second') WHERE link_id = 'whl3'")
+	ProcessWebhookQueue()
+	database.DB.QueryRow("SELECT status, attempts FROM webhook_deliveries WHERE link_id = 'whl3'").Scan(&status, &attempts)
+	if status != "failed" || attempts != 2 {
+		t.Errorf("esperava failed/2 após o limite de tentativas, obteve %s/%d", status, attempts)
+	}
+}
+
+func TestProcessWebhookQueue_BlocksInternalTargetsAtDial(t *testing.T) {
+	cleanup, _ := setupBgDB(t)
+	defer cleanup()
+
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	}))
+	defer srv.Close()
+
+	// Cadastrado quando o host ainda resolvia para fora (DNS rebinding): a conexão é recusada
+	insertTestWebhook(t, "whl5", srv.URL, "expired", 0)
+	EnqueueLinkEvent("whl5", EventExpired, nil)
+	ProcessWebhookQueue()
+
+	if atomic.LoadInt32(&calls) != 0 {
+		t.Fatal("entrega não deveria alcançar um endereço de loopback")
+	}
+	var lastError string
+	database.DB.QueryRow("SELECT COALESCE(last_error, '') FROM webhook_deliveries WHERE link_id = 'whl5'").Scan(&lastError)
+	if !strings.Contains(lastError, "destino bloqueado") {
+		t.Errorf("last_error deveria indicar o bloqueio, obteve %q", lastError)
+	}
+}
+
+func TestValidateWebhookURL(t *testing.T) {
+	tests := []struct {
+		url string
+		ok  bool
+	}{
+		{"https://203.0.113.10/hook", true},
+		{"http://[2001:db8::1]:8443/hook", true},
+		{"ftp://203.0.113.10/hook", false},
+		{"https:///hook", false},
+		{"http://127.0.0.1/hook", false},
+		{"http://LOCALHOST./hook", false},
+		{"http://api.localhost/hook", false},
+		{"http://169.254.169.254/latest/meta-data", false},
+		{"http://192.168.0.10/hook", false},
+		{"http://172.16.5.4/hook", false},
+		{"http://100.64.0.1/hook", false},
+		{"http://0.0.0.0/hook", false},
+		{"http://[::ffff:127.0.0.1]/hook", false},
+		{"http://[fe80::1]/hook", false},
+		{"http://[fd00::1]/hook", false},
+	}
+	for _, tt := range tests {
+		if err := ValidateWebhookURL(tt.url); (err == nil) != tt.ok {
+			t.Errorf("ValidateWebhookURL(%q) = %v, esperava ok=%v", tt.url, err, tt.ok)
+		}
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	if webhookBackoff(1) != 30*time.Second || webhookBackoff(2) != time.Minute {
+		t.Errorf("backoff inicial inesperado: %v %v", webhookBackoff(1), webhookBackoff(2))
+	}
+	if webhookBackoff(50) != 6*time.Hour {
+		t.Errorf("backoff deveria ser limitado a 6h, obteve %v", webhookBackoff(50))
+	}
+}
@@ -0,0 +1,118 @@
+package utils
+
+import (
+	"net"
+	"strings"
+)
+
+// Classes de cliente registradas em access_logs.client_class
+const (
+	ClientEmail    = "email"    // proxies de imagem de webmail (Gmail, Yahoo)
+	ClientUnfurler = "unfurler" // robôs que geram o cartão de pré-visualização de links (Slack, WhatsApp...)
+	ClientBot      = "bot"
+	ClientMobile   = "mobile"
+	ClientDesktop  = "desktop"
+	ClientUnknown  = "unknown"
+)
+
+// emailMarkers são os proxies de imagem de webmail. Clientes de e-mail de desktop (Outlook,
+// Thunderbird) buscam a imagem do próprio computador do leitor e são navegadores comuns aqui.
+var emailMarkers = []string{"googleimageproxy", "yahoomailproxy", "ymailproxy"}
+
+// emailProxyOperator é um operador de proxy de imagens de webmail: o marcador do User-Agent e
+// de onde as buscas realmente saem (ASNs e faixas de IP publicadas pelo operador)
+type emailProxyOperator struct {
+	markers []string
+	asns    []uint
+	cidrs   []string
+}
+
+var emailProxyOperators = []emailProxyOperator{
+	{ // Google (GoogleImageProxy do Gmail)
+		markers: []string{"googleimageproxy"},
+		asns:    []uint{15169},
+		cidrs:   []string{"64.233.160.0/19", "66.102.0.0/20", "66.249.64.0/19", "72.14.192.0/18", "74.125.0.0/16", "209.85.128.0/17", "2001:4860::/32"},
+	},
+	{ // Yahoo (YahooMailProxy, também usado pelo AOL Mail)
+		markers: []string{"yahoomailproxy", "ymailproxy"},
+		asns:    []uint{10310, 26101, 36647},
+		cidrs:   []string{"66.196.64.0/18", "67.195.0.0/16", "74.6.0.0/16", "98.136.0.0/14"},
+	},
+}
+
+// IsVerifiedEmailProxy indica se o hit vem mesmo de um proxy de imagens de webmail: o
+// User-Agent precisa ser o do operador e a origem (ASN ou faixa de IP) também. O UA
+// sozinho é trivial de forjar e não basta para aliviar as regras de fraude.
+func IsVerifiedEmailProxy(ua, ip string, asn uint) bool {
+	lower := strings.ToLower(ua)
+	addr := net.ParseIP(ip)
+	for _, op := range emailProxyOperators {
+		matched := false
+		for _, m := range op.markers {
+			if strings.Contains(lower, m) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+		for _, a := range op.asns {
+			if asn != 0 && asn == a {
+				return true
+			}
+		}
+		if addr == nil {
+			continue
+		}
+		for _, c := range op.cidrs {
+			if _, n, err := net.ParseCIDR(c); err == nil && n.Contains(addr) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var unfurlerMarkers = []string{"facebookexternalhit", "facebookcatalog", "twitterbot", "slackbot", "slack-imgproxy", "discordbot",
+	"telegrambot", "whatsapp", "linkedinbot", "skypeuripreview", "embedly", "iframely", "redditbot", "pinterestbot",
+	"vkshare", "mastodon", "bluesky", "applebot", "mattermost", "rocket.chat", "tabnews"}
+
+var botMarkers = []string{"bot", "crawler", "spider", "curl/", "wget/", "python-requests", "go-http-client", "headless", "preview"}
+
+var mobileMarkers = []string{"mobile", "android", "iphone", "ipad", "ipod"}
+
+// ClassifyClient agrupa o User-Agent numa classe grosseira, suficiente para os
+// relatórios sem guardar detalhes que identifiquem o leitor
+func ClassifyClient(ua string) string {
+	lower := strings.ToLower(ua)
+	if strings.TrimSpace(lower) == "" {
+		return ClientUnknown
+	}
+	for _, m := range unfurlerMarkers {
+		if strings.Contains(lower, m) {
+			return ClientUnfurler
+		}
+	}
+	for _, m := range emailMarkers {
+		if strings.Contains(lower, m) {
+			return ClientEmail
+		}
+	}
+	for _, m := range botMarkers {
+		if strings.Contains(lower, m) {
+			return ClientBot
+		}
+	}
+	for _, m := range mobileMarkers {
+		if strings.Contains(lower, m) {
+			return ClientMobile
+		}
+	}
+	return ClientDesktop
+}
+
+// IsUnfurler indica se o User-Agent é de um gerador de pré-visualização de links
+func IsUnfurler(ua string) bool {
+	return ClassifyClient(ua) == ClientUnfurler
+}
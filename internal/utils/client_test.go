@@ -0,0 +1,55 @@
+package utils
+
+import "testing"
+
+func TestClassifyClient(t *testing.T) {
+	tests := []struct {
+		ua   string
+		want string
+	}{
+		{"", ClientUnknown},
+		{"Mozilla/5.0 (Windows NT 5.1; rv:11.0) Gecko Firefox/11.0 (via ggpht.com GoogleImageProxy)", ClientEmail},
+		{"YahooMailProxy; https://help.yahoo.com/kb/yahoo-mail-proxy-SLN28749.html", ClientEmail},
+		{"Microsoft Office/16.0 (Windows NT 10.0; Microsoft Outlook 16.0.17029; Pro)", ClientDesktop},
+		{"Mozilla/5.0 (X11; Linux x86_64; rv:115.0) Gecko/20100101 Thunderbird/115.6.0", ClientDesktop},
+		{"Googlebot/2.1 (+http://www.google.com/bot.html)", ClientBot},
+		{"curl/8.4.0", ClientBot},
+		{"facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)", ClientUnfurler},
+		{"Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)", ClientUnfurler},
+		{"WhatsApp/2.23.20.0 A", ClientUnfurler},
+		{"Mozilla/5.0 (compatible; Discordbot/2.0; +https://discordapp.com)", ClientUnfurler},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 Mobile/15E148", ClientMobile},
+		{"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 Chrome/120.0 Mobile Safari/537.36", ClientMobile},
+		{"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Safari/537.36", ClientDesktop},
+	}
+	for _, tt := range tests {
+		if got := ClassifyClient(tt.ua); got != tt.want {
+			t.Errorf("ClassifyClient(%q): esperava %q, obteve %q", tt.ua, tt.want, got)
+		}
+	}
+}
+
+func TestIsVerifiedEmailProxy(t *testing.T) {
+	gmail := "Mozilla/5.0 (Windows NT 5.1; rv:11.0) Gecko Firefox/11.0 (via ggpht.com GoogleImageProxy)"
+	yahoo := "YahooMailProxy; https://help.yahoo.com/kb/yahoo-mail-proxy-SLN28749.html"
+	tests := []struct {
+		name string
+		ua   string
+		ip   string
+		asn  uint
+		want bool
+	}{
+		{"Gmail pelo ASN do Google", gmail, "203.0.113.9", 15169, true},
+		{"Gmail pela faixa do Google sem base de ASN", gmail, "66.249.84.10", 0, true},
+		{"Yahoo pela faixa do Yahoo", yahoo, "98.137.1.2", 0, true},
+		{"UA do Gmail forjado de outro ASN", gmail, "203.0.113.9", 64500, false},
+		{"UA do Gmail forjado sem ASN", gmail, "198.51.100.7", 0, false},
+		{"UA do Yahoo vindo do ASN do Google", yahoo, "203.0.113.9", 15169, false},
+		{"navegador comum no ASN do Google", "Mozilla/5.0 (X11; Linux x86_64) Chrome/120.0", "66.249.84.10", 15169, false},
+	}
+	for _, tt := range tests {
+		if got := IsVerifiedEmailProxy(tt.ua, tt.ip, tt.asn); got != tt.want {
+			t.Errorf("%s: esperava %v, obteve %v", tt.name, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package utils
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// defaultTrustedProxies cobre o NGINX rodando no mesmo host (ver DEPLOY.md)
+const defaultTrustedProxies = "127.0.0.0/8,::1/128"
+
+// trustedProxies lê TRUSTED_PROXIES (CIDRs ou IPs avulsos separados por vírgula).
+// "none" desliga a confiança em qualquer proxy.
+func trustedProxies() []*net.IPNet {
+	raw := os.Getenv("TRUSTED_PROXIES")
+	if raw == "" {
+		raw = defaultTrustedProxies
+	}
+	if strings.ToLower(strings.TrimSpace(raw)) == "none" {
+		return nil
+	}
+
+	var nets []*net.IPNet
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil {
+				bits := 128
+				if ip.To4() != nil {
+					bits = 32
+				}
+				entry = entry + "/" + strconv.Itoa(bits)
+			}
+		}
+		if _, n, err := net.ParseCIDR(entry); err == nil {
+			nets = append(nets, n)
+		}
+	}
+	return nets
+}
+
+func isTrusted(ip net.IP, nets []*net.IPNet) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHostIP remove porta, colchetes e aspas de um endereço ("[2001:db8::1]:4711" → 2001:db8::1)
+func parseHostIP(raw string) net.IP {
+	raw = strings.Trim(strings.TrimSpace(raw), `"`)
+	if host, _, err := net.SplitHostPort(raw); err == nil {
+		raw = host
+	}
+	raw = strings.TrimSuffix(strings.TrimPrefix(raw, "["), "]")
+	return net.ParseIP(raw)
+}
+
+// clientIPHeader lê CLIENT_IP_HEADER: qual header de encaminhamento o proxy confiável
+// escreve ("xff", padrão, para X-Forwarded-For; "forwarded" para o RFC 7239). Só esse
+// é lido: o outro chega intacto do cliente e não pode decidir o IP.
+func clientIPHeader() string {
+	if strings.EqualFold(strings.TrimSpace(os.Getenv("CLIENT_IP_HEADER")), "forwarded") {
+		return "forwarded"
+	}
+	return "xff"
+}
+
+// forwardedChain monta a cadeia de saltos (cliente → ... → último proxy) a partir
+// do header configurado em CLIENT_IP_HEADER
+func forwardedChain(r *http.Request) []string {
+	var chain []string
+	if clientIPHeader() == "forwarded" {
+		for _, v := range r.Header.Values("Forwarded") {
+			for _, element := range strings.Split(v, ",") {
+				for _, pair := range strings.Split(element, ";") {
+					kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+					if len(kv) == 2 && strings.EqualFold(kv[0], "for") {
+						chain = append(chain, kv[1])
+					}
+				}
+			}
+		}
+		return chain
+	}
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(v, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				chain = append(chain, hop)
+			}
+		}
+	}
+	return chain
+}
+
+// ClientIP resolve o IP real do visitante. Headers de encaminhamento só são
+// considerados quando a conexão vem de um proxy confiável (TRUSTED_PROXIES); a
+// cadeia é percorrida da direita para a esquerda, pulando proxies confiáveis, e o
+// primeiro endereço não confiável é o cliente. Qualquer valor que o próprio
+// cliente tenha injetado à esquerda desse ponto é ignorado.
+func ClientIP(r *http.Request) string {
+	remote := parseHostIP(r.RemoteAddr)
+	if remote == nil {
+		return r.RemoteAddr
+	}
+
+	nets := trustedProxies()
+	if !isTrusted(remote, nets) {
+		return remote.String()
+	}
+
+	client := remote
+	chain := forwardedChain(r)
+	for i := len(chain) - 1; i >= 0; i-- {
+		hop := parseHostIP(chain[i])
+		if hop == nil {
+			// "unknown", identificadores ofuscados ou lixo: não há como seguir a cadeia
+			break
+		}
+		client = hop
+		if !isTrusted(hop, nets) {
+			break
+		}
+	}
+	return client.String()
+}
+
+// AggregateIP trunca o endereço ao prefixo configurado antes de ser usado em
+// hashes de unicidade, cotas e limites de taxa. Um cliente IPv6 que troca de
+// endereço dentro do próprio /64 continua sendo o mesmo visitante.
+// IPV6_PREFIX_BITS (padrão 64) e IPV4_PREFIX_BITS (padrão 32, ex: 24 agrupa a sub-rede).
+// Valores que não são IP são devolvidos sem alteração.
+func AggregateIP(raw string) string {
+	ip := parseHostIP(raw)
+	if ip == nil {
+		return raw
+	}
+
+	if v4 := ip.To4(); v4 != nil {
+		bits := EnvInt("IPV4_PREFIX_BITS", 32)
+		if bits <= 0 || bits > 32 {
+			bits = 32
+		}
+		return v4.Mask(net.CIDRMask(bits, 32)).String()
+	}
+
+	bits := EnvInt("IPV6_PREFIX_BITS", 64)
+	if bits <= 0 || bits > 128 {
+		bits = 64
+	}
+	return ip.Mask(net.CIDRMask(bits, 128)).String()
+}
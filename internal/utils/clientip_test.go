@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name      string
+		trusted   string
+		header    string
+		remote    string
+		xff       []string
+		forwarded string
+		want      string
+	}{
+		{"sem proxy remove a porta", "", "", "203.0.113.7:5555", nil, "", "203.0.113.7"},
+		{"cliente direto não pode forjar XFF", "", "", "203.0.113.7:5555", []string{"1.1.1.1"}, "", "203.0.113.7"},
+		{"proxy local confiável", "", "", "127.0.0.1:80", []string{"198.51.100.23"}, "", "198.51.100.23"},
+		{"cadeia com valor forjado à esquerda", "10.0.0.0/8", "", "10.0.0.2:80", []string{"6.6.6.6, 198.51.100.23, 10.0.0.9"}, "", "198.51.100.23"},
+		{"múltiplos headers XFF", "10.0.0.0/8", "", "10.0.0.2:80", []string{"6.6.6.6", "198.51.100.23"}, "", "198.51.100.23"},
+		{"todos os saltos confiáveis", "10.0.0.0/8", "", "10.0.0.2:80", []string{"10.1.1.1, 10.0.0.9"}, "", "10.1.1.1"},
+		{"valor inválido interrompe a cadeia", "10.0.0.0/8", "", "10.0.0.2:80", []string{"lixo, 10.0.0.9"}, "", "10.0.0.9"},
+		{"IP avulso em TRUSTED_PROXIES", "172.17.0.1", "", "172.17.0.1:80", []string{"198.51.100.23"}, "", "198.51.100.23"},
+		{"none desliga os proxies", "none", "", "127.0.0.1:80", []string{"198.51.100.23"}, "", "127.0.0.1"},
+		{"Forwarded injetado pelo cliente não substitui o XFF do proxy", "", "", "127.0.0.1:80", []string{"198.51.100.23"}, "for=192.0.2.60;by=127.0.0.1", "198.51.100.23"},
+		{"Forwarded sozinho é ignorado no modo xff", "", "", "127.0.0.1:80", nil, "for=192.0.2.60", "127.0.0.1"},
+		{"header Forwarded com IPv6", "", "forwarded", "[::1]:80", nil, `for=6.6.6.6, for="[2001:db8::1]:4711";proto=https`, "2001:db8::1"},
+		{"XFF injetado é ignorado no modo forwarded", "", "forwarded", "127.0.0.1:80", []string{"6.6.6.6"}, "for=192.0.2.60;by=127.0.0.1", "192.0.2.60"},
+		{"Forwarded unknown", "", "forwarded", "127.0.0.1:80", nil, "for=unknown", "127.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.trusted != "" {
+				os.Setenv("TRUSTED_PROXIES", tt.trusted)
+				defer os.Unsetenv("TRUSTED_PROXIES")
+			}
+			if tt.header != "" {
+				os.Setenv("CLIENT_IP_HEADER", tt.header)
+				defer os.Unsetenv("CLIENT_IP_HEADER")
+			}
+			r := httptest.NewRequest("GET", "/i/x", nil)
+			r.RemoteAddr = tt.remote
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if tt.forwarded != "" {
+				r.Header.Set("Forwarded", tt.forwarded)
+			}
+			if got := ClientIP(r); got != tt.want {
+				t.Errorf("esperava %q, obteve %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestAggregateIP(t *testing.T) {
+	t.Run("IPv6 agrupado no /64 por padrão", func(t *testing.T) {
+		a := AggregateIP("2001:db8:abcd:12:1111:2222:3333:4444")
+		b := AggregateIP("2001:db8:abcd:12:ffff::1")
+		if a != b || a != "2001:db8:abcd:12::" {
+			t.Errorf("endereços do mesmo /64 deveriam agregar: %q vs %q", a, b)
+		}
+	})
+
+	t.Run("IPv4 intacto por padrão", func(t *testing.T) {
+		if got := AggregateIP("198.51.100.23"); got != "198.51.100.23" {
+			t.Errorf("esperava IPv4 inalterado, obteve %q", got)
+		}
+	})
+
+	t.Run("IPv4 agrupado no /24 quando configurado", func(t *testing.T) {
+		os.Setenv("IPV4_PREFIX_BITS", "24")
+		defer os.Unsetenv("IPV4_PREFIX_BITS")
+		if got := AggregateIP("198.51.100.23"); got != "198.51.100.0" {
+			t.Errorf("esperava 198.51.100.0, obteve %q", got)
+		}
+	})
+
+	t.Run("prefixo IPv6 configurável", func(t *testing.T) {
+		os.Setenv("IPV6_PREFIX_BITS", "48")
+		defer os.Unsetenv("IPV6_PREFIX_BITS")
+		if got := AggregateIP("2001:db8:abcd:12::1"); got != "2001:db8:abcd::" {
+			t.Errorf("esperava 2001:db8:abcd::, obteve %q", got)
+		}
+	})
+
+	t.Run("valor que não é IP passa direto", func(t *testing.T) {
+		if got := AggregateIP("not-an-ip"); got != "not-an-ip" {
+			t.Errorf("esperava valor original, obteve %q", got)
+		}
+	})
+}
+
+func TestComposeFingerprintHash_IPv6Rotation(t *testing.T) {
+	a := ComposeFingerprintHash("2001:db8:1:2:aaaa::1", "Chrome")
+	b := ComposeFingerprintHash("2001:db8:1:2:bbbb::2", "Chrome")
+	if a != b {
+		t.Error("rotação de endereço dentro do /64 não deve gerar novo visitante")
+	}
+}
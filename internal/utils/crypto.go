@@ -13,12 +13,14 @@ func GenerateRandomString(n int) string {
 	return hex.EncodeToString(b)
 }
 
+// ComposeFingerprintHash gera o hash pseudônimo do visitante. O IP é agregado ao
+// prefixo configurado (ver AggregateIP) antes de entrar no hash.
 func ComposeFingerprintHash(ip, ua string) string {
 	salt := os.Getenv("APP_SALT")
 	if salt == "" {
 		salt = "default_salt"
 	}
 	h := sha256.New()
-	h.Write([]byte(ip + "-salt-" + salt + "-" + ua))
+	h.Write([]byte(AggregateIP(ip) + "-salt-" + salt + "-" + ua))
 	return hex.EncodeToString(h.Sum(nil))
 }
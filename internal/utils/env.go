@@ -0,0 +1,15 @@
+package utils
+
+import (
+	"os"
+	"strconv"
+)
+
+// EnvInt lê uma variável de ambiente inteira, caindo no padrão quando ausente ou inválida
+func EnvInt(name string, def int) int {
+	v, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		return def
+	}
+	return v
+}
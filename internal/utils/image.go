@@ -5,4 +5,22 @@ import "encoding/base64"
 var (
 	// TransparentGif 1x1 em GIF decodificado a partir de Base64
 	TransparentGif, _ = base64.StdEncoding.DecodeString("R0lGODlhAQABAIAAAAAAAP///yH5BAEAAAAALAAAAAABAAEAAAIBRAA7")
+
+	// DripGifHeader abre um GIF animado 1x1 (assinatura + tela lógica + paleta preto/branco)
+	// que é transmitido quadro a quadro pelo modo de medição de leitura
+	DripGifHeader = []byte{
+		'G', 'I', 'F', '8', '9', 'a',
+		0x01, 0x00, 0x01, 0x00, 0x80, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0xFF, 0xFF, 0xFF,
+	}
+
+	// DripGifFrame é um quadro transparente de 1 segundo (delay de 100 centésimos)
+	DripGifFrame = []byte{
+		0x21, 0xF9, 0x04, 0x01, 0x64, 0x00, 0x00, 0x00,
+		0x2C, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00,
+		0x02, 0x02, 0x44, 0x01, 0x00,
+	}
+
+	// GifTrailer encerra o stream do GIF
+	GifTrailer = []byte{0x3B}
 )
@@ -1,6 +1,8 @@
 package utils
 
 import (
+	"bytes"
+	"image/gif"
 	"testing"
 )
 
@@ -36,3 +38,25 @@ func TestTransparentGif(t *testing.T) {
 		}
 	})
 }
+
+func TestDripGif(t *testing.T) {
+	t.Run("stream montado deve ser um GIF animado decodificável", func(t *testing.T) {
+		var stream []byte
+		stream = append(stream, DripGifHeader...)
+		for i := 0; i < 3; i++ {
+			stream = append(stream, DripGifFrame...)
+		}
+		stream = append(stream, GifTrailer...)
+
+		g, err := gif.DecodeAll(bytes.NewReader(stream))
+		if err != nil {
+			t.Fatalf("GIF inválido: %v", err)
+		}
+		if len(g.Image) != 3 {
+			t.Errorf("esperava 3 quadros, obteve %d", len(g.Image))
+		}
+		if g.Delay[0] != 100 {
+			t.Errorf("cada quadro deve durar 1s (100 centésimos), obteve %d", g.Delay[0])
+		}
+	})
+}
@@ -18,6 +18,8 @@ var (
 
 	geoDB   *geoip2.Reader
 	geoOnce sync.Once
+
+	asnDB *geoip2.Reader
 )
 
 // InitGeoIP carrega o banco MaxMind GeoLite2-City.mmdb localmente.
@@ -38,6 +40,7 @@ func InitGeoIP() {
 		} else {
 			log.Printf("[GEO] ✅ GeoLite2-City carregado com sucesso de %s", dbPath)
 		}
+		initASN()
 	})
 }
 
@@ -46,11 +49,57 @@ func CloseGeoIP() {
 	if geoDB != nil {
 		geoDB.Close()
 	}
+	if asnDB != nil {
+		asnDB.Close()
+	}
+}
+
+// initASN carrega o GeoLite2-ASN.mmdb opcional (usado pela detecção de fraude).
+// Sem o arquivo, LookupASN devolve 0 e a regra de rajada por ASN fica desligada.
+func initASN() {
+	path := os.Getenv("GEOIP_ASN_DB_PATH")
+	if path == "" {
+		path = "./GeoLite2-ASN.mmdb"
+	}
+	db, err := geoip2.Open(path)
+	if err != nil {
+		log.Printf("[GEO] GeoLite2-ASN não encontrado em %s — detecção por ASN desativada", path)
+		return
+	}
+	asnDB = db
+	log.Printf("[GEO] ✅ GeoLite2-ASN carregado com sucesso de %s", path)
+}
+
+// LookupASN devolve o número do sistema autônomo do IP, ou 0 se desconhecido
+func LookupASN(rawIP string) uint {
+	if asnDB == nil {
+		return 0
+	}
+	ip := rawIP
+	if host, _, err := net.SplitHostPort(rawIP); err == nil {
+		ip = host
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return 0
+	}
+	record, err := asnDB.ASN(parsed)
+	if err != nil {
+		return 0
+	}
+	return record.AutonomousSystemNumber
 }
 
 // LookupGeoIP resolve a localização real do IP usando banco local.
 // Se o banco não estiver carregado, cai no fallback placeholder.
 func LookupGeoIP(rawIP string) (country, city string) {
+	country, _, city = LookupGeoIPDetail(rawIP)
+	return country, city
+}
+
+// LookupGeoIPDetail é o LookupGeoIP com a região (estado/subdivisão) do registro GeoLite2.
+// Região vazia quando o banco não traz subdivisão para o IP.
+func LookupGeoIPDetail(rawIP string) (country, region, city string) {
 	// Limpar porta do IP se existir (ex: "10.0.0.1:54321" → "10.0.0.1")
 	ip := rawIP
 	if idx := strings.LastIndex(ip, ":"); idx != -1 {
@@ -62,18 +111,19 @@ func LookupGeoIP(rawIP string) (country, city string) {
 
 	// Se banco GeoIP não carregou, usa fallback
 	if geoDB == nil {
-		return PlaceholderGeoLocation(ip)
+		country, city = PlaceholderGeoLocation(ip)
+		return country, placeholderRegions[city], city
 	}
 
 	parsed := net.ParseIP(ip)
 	if parsed == nil {
-		return "??", "Unknown"
+		return "??", "", "Unknown"
 	}
 
 	// Lookup local — zero chamadas externas
 	record, err := geoDB.City(parsed)
 	if err != nil {
-		return "??", "Unknown"
+		return "??", "", "Unknown"
 	}
 
 	country = record.Country.IsoCode
@@ -81,6 +131,14 @@ func LookupGeoIP(rawIP string) (country, city string) {
 		country = "??"
 	}
 
+	// Subdivisão mais ampla (ex: estado no Brasil), mesmo critério de idioma da cidade
+	if len(record.Subdivisions) > 0 {
+		region = record.Subdivisions[0].Names["pt-BR"]
+		if region == "" {
+			region = record.Subdivisions[0].Names["en"]
+		}
+	}
+
 	// Tenta nome da cidade em pt-BR, depois en, depois o default
 	city = record.City.Names["pt-BR"]
 	if city == "" {
@@ -90,7 +148,16 @@ func LookupGeoIP(rawIP string) (country, city string) {
 		city = "Unknown"
 	}
 
-	return country, city
+	return country, region, city
+}
+
+// placeholderRegions completa o fallback com a região de cada cidade simulada
+var placeholderRegions = map[string]string{
+	"São Paulo": "São Paulo",
+	"New York":  "New York",
+	"Lisbon":    "Lisboa",
+	"Tokyo":     "Tóquio",
+	"Berlin":    "Berlim",
 }
 
 // PlaceholderGeoLocation é o fallback quando GeoLite2 não está disponível.
@@ -49,6 +49,18 @@ func TestPlaceholderGeoLocation(t *testing.T) {
 	})
 }
 
+func TestLookupGeoIPDetail_Placeholder(t *testing.T) {
+	// Sem GeoLite2 carregado, a região acompanha a cidade simulada
+	country, region, city := LookupGeoIPDetail("10.0.0.1:54321")
+	if country != "BR" || region != "São Paulo" || city != "São Paulo" {
+		t.Errorf("esperava BR/São Paulo/São Paulo, obteve %s/%s/%s", country, region, city)
+	}
+	country, region, city = LookupGeoIPDetail("10.0.0.7")
+	if country != "PT" || region != "Lisboa" || city != "Lisbon" {
+		t.Errorf("esperava PT/Lisboa/Lisbon, obteve %s/%s/%s", country, region, city)
+	}
+}
+
 func TestIsUniqueAccess(t *testing.T) {
 	// Reset o cache para cada teste
 	antiF5Mutex.Lock()
@@ -0,0 +1,238 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // registra o decodificador de GIF
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// MaxRenditionSide limita o lado maior das versões redimensionadas geradas sob demanda
+const MaxRenditionSide = 2048
+
+// RenditionSizes são os únicos lados aceitos para as versões redimensionadas: limitam o
+// cache a poucas variações por imagem, em vez de uma por combinação de w e h
+var RenditionSizes = []int{64, 128, 160, 240, 320, 400, 480, 640, 800, 1024, 1280, 1600, MaxRenditionSide}
+
+// DefaultRenditionSourcePixels é o padrão de RENDITION_MAX_SOURCE_PIXELS (40 megapixels)
+const DefaultRenditionSourcePixels = 40_000_000
+
+// ErrImageTooLarge indica uma origem acima do orçamento de pixels (ex: bomba de descompressão)
+var ErrImageTooLarge = errors.New("imagem grande demais para redimensionar")
+
+// SnapRenditionSide arredonda o lado pedido para baixo até o tamanho aceito mais próximo
+// (0 continua 0 = sem limite naquele eixo)
+func SnapRenditionSide(n int) int {
+	if n <= 0 {
+		return 0
+	}
+	snapped := RenditionSizes[0]
+	for _, s := range RenditionSizes {
+		if s <= n {
+			snapped = s
+		}
+	}
+	return snapped
+}
+
+// ImageDimensions lê apenas o cabeçalho da imagem (JPG, PNG ou GIF) para obter largura e altura
+func ImageDimensions(path string) (int, int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return 0, 0, err
+	}
+	return cfg.Width, cfg.Height, nil
+}
+
+// FitDimensions reduz (nunca amplia) width x height para caber em maxW x maxH mantendo a proporção.
+// Zero em maxW ou maxH significa sem limite naquele eixo.
+func FitDimensions(width, height, maxW, maxH int) (int, int) {
+	if width <= 0 || height <= 0 {
+		return width, height
+	}
+	w, h := width, height
+	if maxW > 0 && w > maxW {
+		h = h * maxW / w
+		w = maxW
+	}
+	if maxH > 0 && h > maxH {
+		w = w * maxH / h
+		h = maxH
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+	return w, h
+}
+
+// ResizeImage gera uma versão reduzida da imagem que caiba em maxW x maxH.
+// JPG continua JPG; PNG e GIF viram PNG (GIF animado perde a animação).
+// Origens com mais de maxPixels pixels são recusadas antes de decodificar (0 = sem limite).
+func ResizeImage(path string, maxW, maxH, maxPixels int) ([]byte, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return nil, "", err
+	}
+	if maxPixels > 0 && cfg.Width*cfg.Height > maxPixels {
+		return nil, "", ErrImageTooLarge
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, "", err
+	}
+
+	src, format, err := image.Decode(f)
+	if err != nil {
+		return nil, "", err
+	}
+	b := src.Bounds()
+	w, h := FitDimensions(b.Dx(), b.Dy(), maxW, maxH)
+	dst := scaleBox(src, w, h)
+
+	var buf bytes.Buffer
+	if format == "jpeg" {
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85})
+		return buf.Bytes(), "image/jpeg", err
+	}
+	err = png.Encode(&buf, dst)
+	return buf.Bytes(), "image/png", err
+}
+
+// renditionSlots limita quantas versões são geradas ao mesmo tempo (decodificar é caro)
+var renditionSlots = make(chan struct{}, 2)
+
+// RenditionCacheDir é onde as versões redimensionadas ficam gravadas
+// (RENDITION_CACHE_PATH, padrão STORAGE_PATH/renditions)
+func RenditionCacheDir() string {
+	if dir := os.Getenv("RENDITION_CACHE_PATH"); dir != "" {
+		return dir
+	}
+	storagePath := os.Getenv("STORAGE_PATH")
+	if storagePath == "" {
+		storagePath = "./storage"
+	}
+	return filepath.Join(storagePath, "renditions")
+}
+
+// renditionPrefix identifica no cache todas as versões de um arquivo de origem
+func renditionPrefix(path string) string {
+	sum := sha256.Sum256([]byte(path))
+	return hex.EncodeToString(sum[:12])
+}
+
+// CachedRendition devolve a versão maxW x maxH do arquivo, gerando-a só na primeira vez.
+// A chave inclui data e tamanho da origem, então uma imagem substituída não serve a versão antiga.
+func CachedRendition(path string, maxW, maxH, maxPixels int) ([]byte, string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, "", err
+	}
+	dir := RenditionCacheDir()
+	base := filepath.Join(dir, fmt.Sprintf("%s_%d_%d_%dx%d", renditionPrefix(path), info.ModTime().Unix(), info.Size(), maxW, maxH))
+
+	read := func() ([]byte, string, bool) {
+		if data, err := os.ReadFile(base + ".jpg"); err == nil {
+			return data, "image/jpeg", true
+		}
+		if data, err := os.ReadFile(base + ".png"); err == nil {
+			return data, "image/png", true
+		}
+		return nil, "", false
+	}
+	if data, contentType, ok := read(); ok {
+		return data, contentType, nil
+	}
+
+	renditionSlots <- struct{}{}
+	defer func() { <-renditionSlots }()
+	// Outra requisição pode ter gerado a mesma versão enquanto esta esperava a vez
+	if data, contentType, ok := read(); ok {
+		return data, contentType, nil
+	}
+
+	data, contentType, err := ResizeImage(path, maxW, maxH, maxPixels)
+	if err != nil {
+		return nil, "", err
+	}
+	ext := ".png"
+	if contentType == "image/jpeg" {
+		ext = ".jpg"
+	}
+	// Falha ao gravar o cache não impede servir a versão recém-gerada
+	if os.MkdirAll(dir, 0755) == nil {
+		if tmp, err := os.CreateTemp(dir, ".tmp-*"); err == nil {
+			_, werr := tmp.Write(data)
+			tmp.Close()
+			if werr != nil || os.Rename(tmp.Name(), base+ext) != nil {
+				os.Remove(tmp.Name())
+			}
+		}
+	}
+	return data, contentType, nil
+}
+
+// RemoveRenditions apaga do cache todas as versões geradas a partir do arquivo
+func RemoveRenditions(path string) {
+	matches, _ := filepath.Glob(filepath.Join(RenditionCacheDir(), renditionPrefix(path)+"_*"))
+	for _, m := range matches {
+		os.Remove(m)
+	}
+}
+
+// scaleBox reduz a imagem pela média de cada bloco de pixels de origem (box filter)
+func scaleBox(src image.Image, w, h int) *image.NRGBA {
+	sb := src.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	sw, sh := sb.Dx(), sb.Dy()
+
+	for y := 0; y < h; y++ {
+		y0 := sb.Min.Y + y*sh/h
+		y1 := sb.Min.Y + (y+1)*sh/h
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < w; x++ {
+			x0 := sb.Min.X + x*sw/w
+			x1 := sb.Min.X + (x+1)*sw/w
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					c := color.NRGBAModel.Convert(src.At(sx, sy)).(color.NRGBA)
+					r += uint64(c.R)
+					g += uint64(c.G)
+					bl += uint64(c.B)
+					a += uint64(c.A)
+					n++
+				}
+			}
+			dst.SetNRGBA(x, y, color.NRGBA{R: uint8(r / n), G: uint8(g / n), B: uint8(bl / n), A: uint8(a / n)})
+		}
+	}
+	return dst
+}
@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestPNG(t *testing.T, w, h int) string {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.NRGBA{R: 200, G: 100, B: 50, A: 255})
+		}
+	}
+	path := filepath.Join(t.TempDir(), "img.png")
+	f, _ := os.Create(path)
+	png.Encode(f, img)
+	f.Close()
+	return path
+}
+
+func TestFitDimensions(t *testing.T) {
+	tests := []struct {
+		w, h, maxW, maxH, wantW, wantH int
+	}{
+		{800, 600, 400, 0, 400, 300},
+		{800, 600, 0, 300, 400, 300},
+		{800, 600, 400, 100, 133, 100},
+		{800, 600, 1600, 1200, 800, 600}, // nunca amplia
+		{800, 600, 0, 0, 800, 600},
+	}
+	for _, tt := range tests {
+		if w, h := FitDimensions(tt.w, tt.h, tt.maxW, tt.maxH); w != tt.wantW || h != tt.wantH {
+			t.Errorf("FitDimensions(%d,%d,%d,%d) = %dx%d, esperava %dx%d", tt.w, tt.h, tt.maxW, tt.maxH, w, h, tt.wantW, tt.wantH)
+		}
+	}
+}
+
+func TestImageDimensionsAndResize(t *testing.T) {
+	path := writeTestPNG(t, 120, 80)
+
+	w, h, err := ImageDimensions(path)
+	if err != nil || w != 120 || h != 80 {
+		t.Fatalf("dimensões inesperadas: %dx%d (%v)", w, h, err)
+	}
+
+	data, contentType, err := ResizeImage(path, 60, 0, 0)
+	if err != nil || contentType != "image/png" {
+		t.Fatalf("falha ao redimensionar: %v (%s)", err, contentType)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("rendition não é PNG válido: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != 60 || b.Dy() != 40 {
+		t.Errorf("esperava 60x40, obteve %dx%d", b.Dx(), b.Dy())
+	}
+	if r, g, _, _ := img.At(10, 10).RGBA(); r>>8 != 200 || g>>8 != 100 {
+		t.Error("cor deveria ser preservada pela média")
+	}
+}
+
+func TestResizeImage_RejectsSourceAbovePixelBudget(t *testing.T) {
+	path := writeTestPNG(t, 120, 80)
+	if _, _, err := ResizeImage(path, 60, 0, 120*80-1); err != ErrImageTooLarge {
+		t.Errorf("esperava ErrImageTooLarge, obteve %v", err)
+	}
+	if _, _, err := ResizeImage(path, 60, 0, 120*80); err != nil {
+		t.Errorf("origem dentro do orçamento deveria ser redimensionada: %v", err)
+	}
+}
+
+func TestSnapRenditionSide(t *testing.T) {
+	tests := map[int]int{0: 0, -5: 0, 1: 64, 64: 64, 399: 320, 400: 400, 1000: 800, 99999: MaxRenditionSide}
+	for n, want := range tests {
+		if got := SnapRenditionSide(n); got != want {
+			t.Errorf("SnapRenditionSide(%d) = %d, esperava %d", n, got, want)
+		}
+	}
+}
+
+func TestCachedRendition_ReusesAndRemoves(t *testing.T) {
+	t.Setenv("RENDITION_CACHE_PATH", t.TempDir())
+	path := writeTestPNG(t, 120, 80)
+
+	first, contentType, err := CachedRendition(path, 64, 0, 0)
+	if err != nil || contentType != "image/png" {
+		t.Fatalf("falha ao gerar a versão: %v (%s)", err, contentType)
+	}
+	cached, _ := filepath.Glob(filepath.Join(RenditionCacheDir(), "*.png"))
+	if len(cached) != 1 {
+		t.Fatalf("esperava 1 versão em cache, obteve %v", cached)
+	}
+
+	// A segunda leitura vem do disco, mesmo que a origem já não decodifique
+	os.WriteFile(cached[0], []byte("cache"), 0644)
+	second, _, err := CachedRendition(path, 64, 0, 0)
+	if err != nil || string(second) != "cache" || bytes.Equal(first, second) {
+		t.Errorf("segunda leitura deveria vir do cache: %v", err)
+	}
+
+	RemoveRenditions(path)
+	if left, _ := filepath.Glob(filepath.Join(RenditionCacheDir(), "*")); len(left) != 0 {
+		t.Errorf("RemoveRenditions deveria limpar o cache da origem, restou %v", left)
+	}
+}